@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cmdArgs - command line switches keyed by name without the prefix
+type cmdArgs map[string]string
+
+// parseArgs - splits the command line into the command and its switches.
+// The command is the first argument that does not start with / or -.
+// Switches are written as /key=value or --key=value. A switch without
+// a value (/apply, --apply) is stored as "1".
+func parseArgs(argswp []string) (cmd string, args cmdArgs) {
+	args = make(cmdArgs)
+
+	for _, kv := range argswp {
+		if !strings.HasPrefix(kv, "/") && !strings.HasPrefix(kv, "-") {
+			if cmd == "" {
+				cmd = strings.ToLower(kv)
+			} else {
+				cmd += " " + strings.ToLower(kv)
+			}
+			continue
+		}
+
+		kv = strings.TrimLeft(kv, "/-")
+		k, v := kv, "1"
+		if p := strings.Index(kv, "="); p != -1 {
+			k = kv[:p]
+			v = kv[p+1:]
+		}
+
+		args[strings.ToLower(k)] = v
+	}
+
+	return cmd, args
+}
+
+// String - returns the value of a switch or the default value
+func (a cmdArgs) String(key string, def string) string {
+	if v, ok := a[key]; ok {
+		return v
+	}
+	return def
+}
+
+// Int - returns the value of a switch as an integer or the default value
+func (a cmdArgs) Int(key string, def int) int {
+	v, ok := a[key]
+	if !ok {
+		return def
+	}
+
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return def
+	}
+	return i
+}
+
+// Bool - returns true when the switch is present and not set to 0 or false
+func (a cmdArgs) Bool(key string) bool {
+	v, ok := a[key]
+	if !ok {
+		return false
+	}
+
+	v = strings.ToLower(v)
+	return v != "0" && v != "false" && v != "no"
+}
+
+// Time - returns the value of a switch in yyyy-mm-dd format as a date or the default value
+func (a cmdArgs) Time(key string, def time.Time) time.Time {
+	v, ok := a[key]
+	if !ok {
+		return def
+	}
+
+	t, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		return def
+	}
+	return t
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"gosqljobs/invtcommit/functions/bat"
+	"gosqljobs/invtcommit/functions/constants"
+	"io/ioutil"
+	"strings"
+)
+
+// batchCmntModules - module IDs used as keys of the batch comment templates
+var batchCmntModules = map[string]constants.ModuleConstant{
+	"GL": constants.ModuleGL,
+	"AP": constants.ModuleAP,
+	"AR": constants.ModuleAR,
+	"IM": constants.ModuleIM,
+	"SO": constants.ModuleSO,
+	"CM": constants.ModuleCM,
+	"MC": constants.ModuleMC,
+	"PO": constants.ModulePO,
+	"MF": constants.ModuleMF,
+}
+
+// loadBatchCmntTemplates - reads the batch comment templates from the configuration file.
+//
+// The templates are in the BatchCmntTemplates section, keyed by module ID. The
+// Default template is used for the modules without a template:
+//
+//    "BatchCmntTemplates": {
+//        "Default": "{Cmnt} Run {RunID}",
+//        "SO": "{Whse} {TranFrom}-{TranTo} {PostDate} {User} {Cmnt}"
+//    }
+//
+// See bat.FormatBatchCmnt for the placeholders.
+func loadBatchCmntTemplates(configfile string) error {
+	b, err := ioutil.ReadFile(configfile)
+	if err != nil {
+		return err
+	}
+
+	var c struct {
+		BatchCmntTemplates map[string]string
+	}
+	if err = json.Unmarshal(b, &c); err != nil {
+		return err
+	}
+
+	def := ""
+	tpls := make(map[constants.ModuleConstant]string)
+	for k, v := range c.BatchCmntTemplates {
+		if strings.EqualFold(k, "Default") {
+			def = v
+			continue
+		}
+		if m, ok := batchCmntModules[strings.ToUpper(k)]; ok {
+			tpls[m] = v
+		}
+	}
+
+	bat.SetBatchCmntTemplates(def, tpls)
+	return nil
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"gosqljobs/invtcommit/functions/bat"
+	"gosqljobs/invtcommit/functions/constants"
+	"io"
+	"log"
+	"strconv"
+	"time"
+
+	du "github.com/eaglebush/datautils"
+)
+
+// runBatchesList - batches list command
+//
+// Switches:
+//    /company=     Optional. Company ID
+//    /module=      Optional. Module number (ex. 8 for SO)
+//    /type=        Optional. Batch type
+//    /status=      Optional. Status (1-7)
+//    /poststatus=  Optional. PostStatus (0, 100-500, 999)
+//    /hidden=      Optional. 1 = hidden batches only (BatchNo = 0), 0 = numbered batches only
+//    /user=        Optional. User ID that created the batch
+//    /from=        Optional. Created from date (yyyy-mm-dd)
+//    /to=          Optional. Created to date (yyyy-mm-dd)
+//    /format=      text, csv or json
+func runBatchesList(bq *du.BatchQuery, args cmdArgs, w io.Writer) constants.ResultConstant {
+	format := args.String("format", formatText)
+
+	f := bat.BatchFilter{
+		CompanyID: args.String("company", ""),
+		ModuleNo:  constants.ModuleConstant(args.Int("module", 0)),
+		BatchType: args.Int("type", 0),
+		Status:    constants.BatchStatusConstant(args.Int("status", 0)),
+		UserID:    args.String("user", ""),
+		FromDate:  args.Time("from", time.Time{}),
+		ToDate:    args.Time("to", time.Time{}),
+	}
+	if _, ok := args["poststatus"]; ok {
+		ps := constants.BatchPostStatusConstant(args.Int("poststatus", 0))
+		f.PostStatus = &ps
+	}
+	if _, ok := args["hidden"]; ok {
+		hidden := args.Bool("hidden")
+		f.Hidden = &hidden
+	}
+
+	res, batches := bat.ListBatches(bq, f)
+	if res != constants.ResultSuccess {
+		return res
+	}
+
+	if format == formatJSON {
+		writeJSON(w, batches)
+		return res
+	}
+
+	rows := make([][]string, 0, len(batches))
+	for _, b := range batches {
+		rows = append(rows, batchRow(b))
+	}
+	writeTable(w, format, "Batches", batchHeaders, rows)
+
+	return res
+}
+
+// runBatchesShow - batches show command
+//
+// Switches:
+//    /batchkey=  Key of the batch
+//    /format=    text, csv or json
+func runBatchesShow(bq *du.BatchQuery, args cmdArgs, w io.Writer) constants.ResultConstant {
+	format := args.String("format", formatText)
+	batchKey := args.Int("batchkey", 0)
+
+	res, d := bat.ShowBatch(bq, batchKey)
+	if res == constants.ResultFail {
+		log.Printf("Batch %d not found.", batchKey)
+	}
+	if res != constants.ResultSuccess {
+		return res
+	}
+
+	if format == formatJSON {
+		writeJSON(w, d)
+		return res
+	}
+
+	writeTable(w, format, "Batch", batchHeaders, [][]string{batchRow(d.BatchInfo)})
+
+	if d.ModuleBatch != nil {
+		writeTable(w, format, "Module Batch",
+			[]string{"Table", "Comment", "Hold", "User", "PostDate"},
+			[][]string{{
+				d.ModuleBatch.Table,
+				d.ModuleBatch.BatchCmnt,
+				strconv.FormatBool(d.ModuleBatch.Hold),
+				d.ModuleBatch.OrigUserID,
+				fmtDate(d.ModuleBatch.PostDate),
+			}})
+	}
+
+	writeTable(w, format, "Linked Rows",
+		[]string{"tsoPendShipment", "timInvtTran", "tglPosting", "tglTransaction"},
+		[][]string{{
+			strconv.Itoa(d.PendShipments),
+			strconv.Itoa(d.InvtTrans),
+			strconv.Itoa(d.GLPostings),
+			strconv.Itoa(d.GLTransactions),
+		}})
+
+	return res
+}
+
+// batchHeaders - headers of the batch rows
+var batchHeaders = []string{"BatchKey", "BatchID", "BatchNo", "Type", "Module", "Company", "Status", "PostStatus", "User", "PostDate", "Created", "Comment"}
+
+// batchRow - formats a batch for the batch tables
+func batchRow(b bat.BatchInfo) []string {
+	return []string{
+		strconv.Itoa(b.BatchKey),
+		b.BatchID,
+		strconv.Itoa(b.BatchNo),
+		strconv.Itoa(b.BatchType),
+		strconv.Itoa(int(b.ModuleNo)),
+		b.CompanyID,
+		fmt.Sprint(b.Status),
+		fmt.Sprint(b.PostStatus),
+		b.UserID,
+		fmtDate(b.PostDate),
+		b.CreateDate.Format("2006-01-02 15:04"),
+		b.BatchCmnt,
+	}
+}
+
+// runBatchesDelete - batches delete command
+//
+// Switches:
+//    /company=   Company ID of the batch
+//    /batchkey=  Key of the batch
+//    /format=    text, csv or json
+func runBatchesDelete(bq *du.BatchQuery, args cmdArgs, w io.Writer) constants.ResultConstant {
+	format := args.String("format", formatText)
+	batchKey := args.Int("batchkey", 0)
+
+	res, blocking := bat.DeleteBatch(bq, batchKey, args.String("company", ""))
+	switch res {
+	case constants.ResultSuccess:
+		log.Printf("Batch %d deleted.", batchKey)
+	case constants.ResultConstant(3):
+		log.Printf("Batch %d not found for the company.", batchKey)
+	case constants.ResultConstant(4):
+		log.Printf("Batch %d is posted and cannot be deleted.", batchKey)
+	}
+	if res != constants.ResultFail {
+		return res
+	}
+
+	if format == formatJSON {
+		writeJSON(w, blocking)
+		return res
+	}
+
+	rows := make([][]string, 0, len(blocking))
+	for _, d := range blocking {
+		rows = append(rows, []string{d.Table, strconv.Itoa(d.Rows)})
+	}
+	writeTable(w, format, "Rows Attached to the Batch", []string{"Table", "Rows"}, rows)
+	log.Printf("Batch %d was not deleted.", batchKey)
+
+	return res
+}
+
+// runBatchesVerify - batches verify command
+//
+// Switches:
+//    /company=   Company ID of the batches
+//    /batchkey=  Optional. Key of the batch (default all batches of the company)
+//    /apply      Write the recomputed totals to tciBatchLog
+//    /format=    text, csv or json
+func runBatchesVerify(bq *du.BatchQuery, args cmdArgs, w io.Writer) constants.ResultConstant {
+	format := args.String("format", formatText)
+	apply := args.Bool("apply")
+
+	res, diffs := bat.VerifyBatchTotals(bq, args.String("company", ""), args.Int("batchkey", 0), apply)
+	if res == constants.ResultError {
+		return res
+	}
+
+	if format == formatJSON {
+		writeJSON(w, diffs)
+		return res
+	}
+
+	rows := make([][]string, 0, len(diffs))
+	for _, d := range diffs {
+		rows = append(rows, []string{
+			strconv.Itoa(d.BatchKey),
+			d.BatchID,
+			fmtAmt(d.StoredTotal),
+			fmtAmt(d.ComputedTotal),
+			strconv.Itoa(d.StoredNextSeqNo),
+			strconv.Itoa(d.ComputedNextSeqNo),
+		})
+	}
+	writeTable(w, format, "Batch Totals Differences",
+		[]string{"BatchKey", "BatchID", "BatchTotal", "Computed", "NextSeqNo", "Computed"}, rows)
+
+	switch {
+	case len(diffs) == 0:
+		log.Printf("Batch totals agree with the detail rows.")
+	case apply:
+		log.Printf("%d batches corrected.", len(diffs))
+	default:
+		log.Printf("%d batches differ. Run with /apply to correct them.", len(diffs))
+	}
+
+	return res
+}
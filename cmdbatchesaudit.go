@@ -0,0 +1,63 @@
+package main
+
+import (
+	"gosqljobs/invtcommit/functions/bat"
+	"gosqljobs/invtcommit/functions/constants"
+	"io"
+	"log"
+	"strconv"
+
+	du "github.com/eaglebush/datautils"
+)
+
+// runBatchesAudit - batches audit command
+//
+// Switches:
+//    /company=   Optional. Company ID (default all companies)
+//    /type=      Optional. Batch type (default all batch types)
+//    /format=    text, csv or json
+func runBatchesAudit(bq *du.BatchQuery, args cmdArgs, w io.Writer) constants.ResultConstant {
+	format := args.String("format", formatText)
+
+	res, gaps, dups := bat.AuditBatchNumbers(bq, args.String("company", ""), args.Int("type", 0))
+	if res == constants.ResultError {
+		return res
+	}
+
+	if format == formatJSON {
+		writeJSON(w, struct {
+			Gaps []bat.BatchNoGap `json:"gaps"`
+			Dups []bat.BatchIDDup `json:"duplicates"`
+		}{gaps, dups})
+		return res
+	}
+
+	rows := make([][]string, 0, len(gaps))
+	for _, g := range gaps {
+		rows = append(rows, []string{
+			g.CompanyID,
+			strconv.Itoa(g.BatchType),
+			strconv.Itoa(g.FromNo),
+			strconv.Itoa(g.ToNo),
+		})
+	}
+	writeTable(w, format, "Batch Number Gaps", []string{"Company", "BatchType", "From", "To"}, rows)
+
+	rows = make([][]string, 0, len(dups))
+	for _, d := range dups {
+		rows = append(rows, []string{
+			d.CompanyID,
+			d.BatchID,
+			strconv.Itoa(d.Count),
+			strconv.Itoa(d.MinBatchKey),
+			strconv.Itoa(d.MaxBatchKey),
+		})
+	}
+	writeTable(w, format, "Duplicate BatchIDs", []string{"Company", "BatchID", "Count", "MinBatchKey", "MaxBatchKey"}, rows)
+
+	if res == constants.ResultFail {
+		log.Printf("%d gaps and %d duplicate BatchIDs found.", len(gaps), len(dups))
+	}
+
+	return res
+}
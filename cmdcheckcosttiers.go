@@ -0,0 +1,86 @@
+package main
+
+import (
+	"gosqljobs/invtcommit/functions/constants"
+	"gosqljobs/invtcommit/functions/im"
+	"io"
+	"log"
+	"strconv"
+
+	du "github.com/eaglebush/datautils"
+)
+
+// runCheckCostTiers - check-cost-tiers command
+//
+// Switches:
+//    /company=   Company ID
+//    /whse=      Optional. Warehouse ID
+//    /item=      Optional. Item ID
+//    /repair     Recompute the pending quantities and remove the orphan rows
+//    /format=    text, csv or json
+func runCheckCostTiers(bq *du.BatchQuery, args cmdArgs, w io.Writer) constants.ResultConstant {
+	companyID := args.String("company", "")
+	format := args.String("format", formatText)
+	repair := args.Bool("repair")
+
+	whseKey := 0
+	if whseID := args.String("whse", ""); whseID != "" {
+		qr := bq.Get(`SELECT WhseKey FROM timWarehouse WITH (NOLOCK) WHERE CompanyID=? AND WhseID=?;`, companyID, whseID)
+		if !qr.HasData {
+			return constants.ResultError
+		}
+		whseKey = int(qr.First().ValueInt64Ord(0))
+	}
+
+	itemKey := 0
+	if itemID := args.String("item", ""); itemID != "" {
+		qr := bq.Get(`SELECT ItemKey FROM timItem WITH (NOLOCK) WHERE CompanyID=? AND ItemID=?;`, companyID, itemID)
+		if !qr.HasData {
+			return constants.ResultError
+		}
+		itemKey = int(qr.First().ValueInt64Ord(0))
+	}
+
+	res, finds := im.CheckCostTiers(bq, companyID, whseKey, itemKey, repair)
+	if res == constants.ResultError {
+		return res
+	}
+
+	if format == formatJSON {
+		writeJSON(w, finds)
+		return res
+	}
+
+	rows := make([][]string, 0, len(finds))
+	for _, f := range finds {
+		tol := ""
+		if f.TrnsfrOrderLineKey != 0 {
+			tol = strconv.Itoa(f.TrnsfrOrderLineKey)
+		}
+		rows = append(rows, []string{
+			f.Kind,
+			f.WhseID,
+			f.ItemID,
+			strconv.Itoa(f.CostTierKey),
+			tol,
+			fmtQty(f.PendQtyIncrease),
+			fmtQty(f.ExpQtyIncrease),
+			fmtQty(f.PendQtyDecrease),
+			fmtQty(f.ExpQtyDecrease),
+			f.Explanation,
+		})
+	}
+	writeTable(w, format, "Cost Tier Findings",
+		[]string{"Kind", "Whse", "Item", "CostTierKey", "TrnsfrLine", "PendInc", "Expected", "PendDec", "Expected", "Explanation"}, rows)
+
+	switch {
+	case len(finds) == 0:
+		log.Printf("Cost tiers agree with the transactions.")
+	case repair:
+		log.Printf("%d findings repaired.", len(finds))
+	default:
+		log.Printf("%d findings. Run with /repair to correct them.", len(finds))
+	}
+
+	return res
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"gosqljobs/invtcommit/functions/constants"
+	"gosqljobs/invtcommit/functions/gl"
+	"gosqljobs/invtcommit/functions/im"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	du "github.com/eaglebush/datautils"
+)
+
+// runCommit - commit command.  Commits the pending IM transactions and PO receivers
+// through disposable batches and posts them to GL without a batch.
+//
+// Switches:
+//    /company=        Company ID
+//    /whse=           Optional. Warehouse ID
+//    /tranid=         Optional. Transaction ID
+//    /user=           User ID committing the transactions (default admin)
+//    /session=        Optional. Session ID of the error log
+//    /allownegative   Allow the cost tiers to take the quantity on hand below zero
+//    /retries=        Times the item and warehouse locks held by others are requested again (default 3)
+//    /wait=           Seconds between the lock requests (default 5)
+//    /format=         text, csv or json
+//
+// The register procedure of each module is set in the CommitRegisters section of the
+// configuration file (see loadCommitRegisters).
+func runCommit(bq *du.BatchQuery, args cmdArgs, w io.Writer) constants.ResultConstant {
+	companyID := args.String("company", "")
+	whseID := args.String("whse", "")
+	tranID := args.String("tranid", "")
+	userID := args.String("user", "admin")
+	format := args.String("format", formatText)
+	session := args.Int("session", 0)
+
+	bq.Set(`CREATE TABLE #tciTransToCommit (
+				CompanyID         VARCHAR(3) NOT NULL,
+				TranType          INTEGER NOT NULL,
+				PostDate          DATETIME NOT NULL,
+				InvcDate          DATETIME NULL,
+				TranKey           INTEGER NOT NULL,
+				PreCommitBatchKey INTEGER NOT NULL,
+				DispoBatchKey     INTEGER NULL,
+				CommitStatus      INTEGER DEFAULT 0);`)
+
+	imtt := `?` + strings.Repeat(`,?`, len(constants.IMCommitTranTypes)-1)
+	bq.Set(`INSERT INTO #tciTransToCommit (CompanyID, TranType, PostDate, TranKey, PreCommitBatchKey, CommitStatus)
+			SELECT p.CompanyID, p.TranType, p.TranDate, p.InvtTranKey, COALESCE(p.BatchKey, 0), 0
+			FROM timPendInvtTran p WITH (NOLOCK)
+				JOIN timWarehouse w WITH (NOLOCK) ON p.WhseKey = w.WhseKey
+			WHERE p.CompanyID=? AND (?='' OR w.WhseID=?) AND (?='' OR p.TranID=?)
+				AND p.TranType IN (`+imtt+`);`,
+		append([]interface{}{companyID, whseID, whseID, tranID, tranID}, constants.IMCommitTranTypes...)...)
+
+	pott := `?` + strings.Repeat(`,?`, len(constants.POCommitTranTypes)-1)
+	bq.Set(`INSERT INTO #tciTransToCommit (CompanyID, TranType, PostDate, TranKey, PreCommitBatchKey, CommitStatus)
+			SELECT r.CompanyID, r.TranType, r.PostDate, r.RcvrKey, COALESCE(r.BatchKey, 0), 0
+			FROM tpoPendReceiver r WITH (NOLOCK)
+				JOIN timWarehouse w WITH (NOLOCK) ON r.WhseKey = w.WhseKey
+			WHERE r.CompanyID=? AND (?='' OR w.WhseID=?) AND (?='' OR r.TranID=?)
+				AND r.TranType IN (`+pott+`);`,
+		append([]interface{}{companyID, whseID, whseID, tranID, tranID}, constants.POCommitTranTypes...)...)
+	if !bq.OK() {
+		return constants.ResultError
+	}
+
+	qr := bq.Get(`SELECT COUNT(*) FROM #tciTransToCommit;`)
+	if !bq.OK() {
+		return constants.ResultError
+	}
+	if qr.First().ValueInt64Ord(0) == 0 {
+		log.Printf("No pending transactions to commit.")
+		return constants.ResultSuccess
+	}
+
+	res, results := im.CommitInvtTrans(bq, userID, session, runCommitRegister,
+		args.Bool("allownegative"), args.Int("retries", 3), time.Duration(args.Int("wait", 5))*time.Second)
+	switch res {
+	case constants.ResultError:
+		return res
+	case constants.ResultFail:
+		log.Printf("Not enough quantity in the cost tiers. Run with /allownegative or receive the items first.")
+		return res
+	case constants.ResultConstant(3):
+		log.Printf("An item is not set up in its warehouse.")
+		return res
+	case constants.ResultConstant(4):
+		log.Printf("A transaction has an invalid quantity.")
+		return res
+	}
+
+	// The committed transactions are posted to GL without a batch
+	bq.Set(`CREATE TABLE #tciTransToPost (
+				CompanyID  VARCHAR(3) NOT NULL,
+				TranID     VARCHAR(13) NOT NULL,
+				TranType   INTEGER NOT NULL,
+				TranKey    INTEGER NOT NULL,
+				GLBatchKey INTEGER NOT NULL,
+				PostStatus INTEGER DEFAULT 0);`)
+	bq.Set(`INSERT INTO #tciTransToPost (CompanyID, TranID, TranType, TranKey, GLBatchKey, PostStatus)
+			SELECT t.CompanyID, COALESCE(it.TranID, r.TranID, ''), t.TranType, t.TranKey, 0, 0
+			FROM #tciTransToCommit t
+				LEFT JOIN timInvtTran it WITH (NOLOCK) ON t.TranKey = it.InvtTranKey AND t.TranType IN (`+imtt+`)
+				LEFT JOIN tpoReceiver r WITH (NOLOCK) ON t.TranKey = r.RcvrKey AND t.TranType IN (`+pott+`)
+			WHERE COALESCE(t.DispoBatchKey, 0)<>0 AND COALESCE(t.CommitStatus, 0)>=0;`,
+		append(append([]interface{}{}, constants.IMCommitTranTypes...), constants.POCommitTranTypes...)...)
+	if !bq.OK() {
+		return constants.ResultError
+	}
+
+	pres, sessionID := gl.APIPostBatchlessGLPosting(bq, "Inventory Commit", session, userID, true, true, false)
+	if pres == constants.ResultError {
+		return pres
+	}
+
+	if format == formatJSON {
+		writeJSON(w, results)
+	} else {
+		rows := make([][]string, 0, len(results))
+		for _, r := range results {
+			rows = append(rows, []string{
+				strconv.Itoa(r.InvtTranKey),
+				strconv.Itoa(int(r.ValuationMeth)),
+				fmtAmt(r.UnitCost),
+				fmtAmt(r.ExtCost),
+				strconv.Itoa(len(r.Dists)),
+			})
+		}
+		writeTable(w, format, "Committed Transactions",
+			[]string{"InvtTranKey", "Valuation", "UnitCost", "ExtCost", "Tiers"}, rows)
+	}
+
+	qr = bq.Get(`SELECT
+					SUM(CASE WHEN CommitStatus=? THEN 1 ELSE 0 END) AS Deferred,
+					SUM(CASE WHEN CommitStatus=? THEN 1 ELSE 0 END) AS Blocked
+				 FROM #tciTransToCommit;`, constants.CommitStatusLockedByUser, constants.CommitStatusNegInventory)
+	if bq.OK() && qr.HasData {
+		if n := qr.First().ValueInt64("Deferred"); n > 0 {
+			log.Printf("%d transactions deferred. Their items are locked by another commit.", n)
+		}
+		if n := qr.First().ValueInt64("Blocked"); n > 0 {
+			log.Printf("%d transactions blocked by the negative inventory policy. See session %d.", n, session)
+		}
+	}
+
+	if pres != constants.ResultSuccess {
+		fmt.Fprintf(w, "Nothing posted to GL. See session %d.\n", sessionID)
+	}
+
+	return constants.ResultSuccess
+}
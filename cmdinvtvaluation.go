@@ -0,0 +1,82 @@
+package main
+
+import (
+	"gosqljobs/invtcommit/functions/constants"
+	"gosqljobs/invtcommit/functions/im"
+	"io"
+	"log"
+	"time"
+
+	du "github.com/eaglebush/datautils"
+)
+
+// valuationMethNames - names of the valuation methods for report output
+var valuationMethNames = map[constants.ValuationMethConstant]string{
+	constants.ValuationMethStandard: "Standard",
+	constants.ValuationMethAverage:  "Average",
+	constants.ValuationMethFIFO:     "FIFO",
+	constants.ValuationMethLIFO:     "LIFO",
+	constants.ValuationMethActual:   "Actual",
+}
+
+// runInvtValuation - invt-valuation command
+//
+// Switches:
+//    /company=   Company ID
+//    /asof=      Valuation date in yyyy-mm-dd format (default today)
+//    /whse=      Optional. Warehouse ID
+//    /acct=      Inventory account masks, separated by commas (ex. 1300-***-**, separators are ignored)
+//    /format=    text, csv or json
+func runInvtValuation(bq *du.BatchQuery, args cmdArgs, w io.Writer) constants.ResultConstant {
+	companyID := args.String("company", "")
+	asOf := args.Time("asof", time.Now())
+	format := args.String("format", formatText)
+
+	whseKey := 0
+	if whseID := args.String("whse", ""); whseID != "" {
+		qr := bq.Get(`SELECT WhseKey FROM timWarehouse WITH (NOLOCK) WHERE CompanyID=? AND WhseID=?;`, companyID, whseID)
+		if !qr.HasData {
+			return constants.ResultError
+		}
+		whseKey = int(qr.First().ValueInt64Ord(0))
+	}
+
+	res, lines, total := im.InvtValuation(bq, companyID, asOf, whseKey, args.String("acct", ""))
+	if res == constants.ResultError {
+		return res
+	}
+
+	if format == formatJSON {
+		writeJSON(w, struct {
+			Lines []im.InvtValuationLine `json:"lines"`
+			Total im.InvtValuationTotal  `json:"total"`
+		}{lines, total})
+		return res
+	}
+
+	rows := make([][]string, 0, len(lines))
+	for _, l := range lines {
+		rows = append(rows, []string{
+			l.WhseID,
+			l.ItemID,
+			valuationMethNames[l.ValuationMeth],
+			fmtQty(l.QtyOnHand),
+			l.UnitCost.String(),
+			fmtAmt(l.ExtCost),
+			fmtQty(l.PendQtyIncrease),
+			fmtQty(l.PendQtyDecrease),
+		})
+	}
+	writeTable(w, format, "Inventory Valuation as of "+fmtDate(asOf),
+		[]string{"Whse", "Item", "Valuation", "QtyOnHand", "UnitCost", "ExtCost", "PendInc", "PendDec"}, rows)
+
+	writeTable(w, format, "GL Inventory Accounts",
+		[]string{"AsOf", "Inventory", "GL", "Variance"},
+		[][]string{{fmtDate(total.AsOfDate), fmtAmt(total.ExtCost), fmtAmt(total.GLBalance), fmtAmt(total.VarianceAmt)}})
+
+	if whseKey != 0 {
+		log.Printf("The GL balance is for all warehouses.")
+	}
+
+	return res
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"gosqljobs/invtcommit/functions/constants"
+	"gosqljobs/invtcommit/functions/gl"
+	"io"
+	"log"
+	"strconv"
+
+	du "github.com/eaglebush/datautils"
+)
+
+// runRebuildAcctHist - rebuild-accthist command
+//
+// Switches:
+//    /company=   Company ID
+//    /fromyear=  Starting fiscal year
+//    /toyear=    Ending fiscal year (default /fromyear)
+//    --apply     Write the corrections. Without it, only the differences are shown.
+//    /format=    text, csv or json
+func runRebuildAcctHist(bq *du.BatchQuery, args cmdArgs, w io.Writer) constants.ResultConstant {
+	fromYear := args.String("fromyear", "")
+	format := args.String("format", formatText)
+	apply := args.Bool("apply")
+
+	res, diffs := gl.RebuildAcctHist(bq, args.String("company", ""), fromYear, args.String("toyear", fromYear), apply)
+	switch res {
+	case constants.ResultError:
+		return res
+	case constants.ResultConstant(3):
+		log.Println("Fiscal years not found.")
+		return res
+	}
+
+	if format == formatJSON {
+		writeJSON(w, struct {
+			Applied bool              `json:"applied"`
+			Diffs   []gl.AcctHistDiff `json:"diffs"`
+		}{apply && len(diffs) > 0, diffs})
+		return res
+	}
+
+	rows := make([][]string, 0, len(diffs))
+	for _, d := range diffs {
+		ref := ""
+		if d.AcctRefKey != 0 {
+			ref = strconv.Itoa(d.AcctRefKey)
+		}
+		rows = append(rows, []string{
+			d.Table,
+			d.GLAcctNo,
+			d.FiscYear,
+			strconv.Itoa(d.FiscPer),
+			d.CurrID,
+			ref,
+			d.Column,
+			fmtAmt(d.StoredAmt),
+			fmtAmt(d.RebuiltAmt),
+		})
+	}
+	writeTable(w, format, "Account History Differences",
+		[]string{"Table", "Account", "FiscYear", "FiscPer", "Curr", "AcctRefKey", "Column", "Stored", "Rebuilt"}, rows)
+
+	if len(diffs) > 0 {
+		if apply {
+			log.Printf("%d differences corrected.", len(diffs))
+		} else {
+			log.Printf("%d differences found. Run with --apply to correct them.", len(diffs))
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"gosqljobs/invtcommit/functions/constants"
+	"gosqljobs/invtcommit/functions/im"
+	"io"
+	"strconv"
+
+	du "github.com/eaglebush/datautils"
+)
+
+// runReconcileInvt - reconcile-invt command
+//
+// Switches:
+//    /company=   Company ID
+//    /year=      Fiscal year
+//    /fromper=   Starting fiscal period (default 1)
+//    /toper=     Ending fiscal period (default /fromper)
+//    /whse=      Optional. Warehouse ID
+//    /acct=      Inventory account masks, separated by commas (ex. 1300-***-**, separators are ignored)
+//    /format=    text, csv or json
+func runReconcileInvt(bq *du.BatchQuery, args cmdArgs, w io.Writer) constants.ResultConstant {
+	companyID := args.String("company", "")
+	fromPer := args.Int("fromper", 1)
+	toPer := args.Int("toper", fromPer)
+	format := args.String("format", formatText)
+
+	whseKey := 0
+	if whseID := args.String("whse", ""); whseID != "" {
+		qr := bq.Get(`SELECT WhseKey FROM timWarehouse WITH (NOLOCK) WHERE CompanyID=? AND WhseID=?;`, companyID, whseID)
+		if !qr.HasData {
+			return constants.ResultError
+		}
+		whseKey = int(qr.First().ValueInt64Ord(0))
+	}
+
+	res, sum, vars := im.ReconcileInvtToGL(bq, companyID, args.String("year", ""), fromPer, toPer, whseKey, args.String("acct", ""))
+	if res == constants.ResultError {
+		return res
+	}
+
+	if format == formatJSON {
+		writeJSON(w, struct {
+			Summary   []im.InvtGLReconSummary  `json:"summary"`
+			Variances []im.InvtGLReconVariance `json:"variances"`
+		}{sum, vars})
+		return res
+	}
+
+	rows := make([][]string, 0, len(sum))
+	for _, s := range sum {
+		rows = append(rows, []string{
+			s.FiscYear,
+			strconv.Itoa(s.FiscPer),
+			s.WhseID,
+			strconv.Itoa(s.TranCount),
+			fmtAmt(s.SubLedgerAmt),
+			fmtAmt(s.GLAmt),
+			fmtAmt(s.VarianceAmt),
+			strconv.Itoa(s.VarianceCount),
+		})
+	}
+	writeTable(w, format, "Inventory to GL Reconciliation",
+		[]string{"FiscYear", "FiscPer", "Whse", "Trans", "SubLedger", "GL", "Variance", "VarTrans"}, rows)
+
+	rows = make([][]string, 0, len(vars))
+	for _, v := range vars {
+		nogl := ""
+		if v.NoGLTran {
+			nogl = "Y"
+		}
+		rows = append(rows, []string{
+			strconv.Itoa(v.InvtTranKey),
+			strconv.Itoa(v.TranType),
+			v.TranDate.Format("2006-01-02"),
+			v.FiscYear,
+			strconv.Itoa(v.FiscPer),
+			v.WhseID,
+			v.ItemID,
+			fmtAmt(v.SubLedgerAmt),
+			fmtAmt(v.GLAmt),
+			fmtAmt(v.VarianceAmt),
+			nogl,
+		})
+	}
+	writeTable(w, format, "Variances",
+		[]string{"InvtTranKey", "TranType", "TranDate", "FiscYear", "FiscPer", "Whse", "Item", "SubLedger", "GL", "Variance", "NoGL"}, rows)
+
+	return res
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"gosqljobs/invtcommit/functions/constants"
+	"gosqljobs/invtcommit/functions/im"
+	"io"
+	"log"
+	"strconv"
+
+	du "github.com/eaglebush/datautils"
+)
+
+// runReconcilePendQty - reconcile-pendqty command
+//
+// Switches:
+//    /company=   Company ID
+//    /whse=      Optional. Warehouse ID
+//    /apply      Set the pending quantities of the cost tiers to the expected quantities,
+//                with the same repair as check-cost-tiers /repair
+//    /format=    text, csv or json
+func runReconcilePendQty(bq *du.BatchQuery, args cmdArgs, w io.Writer) constants.ResultConstant {
+	companyID := args.String("company", "")
+	format := args.String("format", formatText)
+	apply := args.Bool("apply")
+
+	whseKey := 0
+	if whseID := args.String("whse", ""); whseID != "" {
+		qr := bq.Get(`SELECT WhseKey FROM timWarehouse WITH (NOLOCK) WHERE CompanyID=? AND WhseID=?;`, companyID, whseID)
+		if !qr.HasData {
+			return constants.ResultError
+		}
+		whseKey = int(qr.First().ValueInt64Ord(0))
+	}
+
+	res, diffs := im.ReconcilePendQty(bq, companyID, whseKey, apply)
+	if res == constants.ResultError {
+		return res
+	}
+
+	if format == formatJSON {
+		writeJSON(w, diffs)
+		return res
+	}
+
+	rows := make([][]string, 0, len(diffs))
+	for _, d := range diffs {
+		rows = append(rows, []string{
+			d.WhseID,
+			d.ItemID,
+			strconv.Itoa(d.CostTierKey),
+			fmtQty(d.PendQtyIncrease),
+			fmtQty(d.ExpQtyIncrease),
+			fmtQty(d.PendQtyDecrease),
+			fmtQty(d.ExpQtyDecrease),
+		})
+	}
+	writeTable(w, format, "Pending Quantity Differences",
+		[]string{"Whse", "Item", "CostTierKey", "PendInc", "Expected", "PendDec", "Expected"}, rows)
+
+	switch {
+	case len(diffs) == 0:
+		log.Printf("Pending quantities agree with the pending transactions.")
+	case apply:
+		log.Printf("%d cost tiers corrected.", len(diffs))
+	default:
+		log.Printf("%d cost tiers differ. Run with /apply to correct them.", len(diffs))
+	}
+
+	return res
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"gosqljobs/invtcommit/functions/constants"
+	"gosqljobs/invtcommit/functions/gl"
+	"gosqljobs/invtcommit/functions/so"
+	"io"
+	"log"
+
+	du "github.com/eaglebush/datautils"
+)
+
+// runResume - resume command
+//
+// Switches:
+//    /company=   Company ID
+//    /batchkey=  Key of the interrupted batch
+//    /user=      User ID posting the batch (default admin)
+//    /allownegative  Allow the cost tiers of a disposable batch to go below zero
+func runResume(bq *du.BatchQuery, args cmdArgs, w io.Writer) constants.ResultConstant {
+	batchKey := args.Int("batchkey", 0)
+
+	res, ps := so.ResumeBatchPosting(bq, batchKey, args.String("company", ""), args.String("user", "admin"), gl.PostAPIGLPosting, args.Bool("allownegative"))
+	switch res {
+	case constants.ResultError:
+		return res
+	case constants.ResultSuccess:
+		fmt.Fprintf(w, "Batch %d posted.\n", batchKey)
+	case constants.ResultFail:
+		log.Printf("Batch %d has nothing to resume (PostStatus %d).", batchKey, ps)
+	case constants.ResultConstant(3):
+		log.Printf("Batch %d not found or is not a shipment, IM or MC batch of the company.", batchKey)
+	case constants.ResultConstant(4):
+		log.Printf("Batch %d has pending transactions or not enough quantity in the cost tiers. Run the commit again.", batchKey)
+	case constants.ResultConstant(5):
+		log.Printf("Batch %d failed validation or GL posting at PostStatus %d.", batchKey, ps)
+	}
+
+	return res
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"gosqljobs/invtcommit/functions/constants"
+	"gosqljobs/invtcommit/functions/dec"
+	"gosqljobs/invtcommit/functions/gl"
+	"io"
+	"log"
+	"strconv"
+
+	du "github.com/eaglebush/datautils"
+)
+
+// runTrialBalance - trial-balance command
+//
+// Switches:
+//    /company=   Company ID
+//    /year=      Fiscal year
+//    /fromper=   Starting fiscal period (default 1)
+//    /toper=     Ending fiscal period (default /fromper)
+//    /rollup=    Optional. Account mask to roll up segments (ex. ****-000-00, separators are ignored)
+//    /format=    text, csv or json
+func runTrialBalance(bq *du.BatchQuery, args cmdArgs, w io.Writer) constants.ResultConstant {
+	fromPer := args.Int("fromper", 1)
+	format := args.String("format", formatText)
+
+	res, lines, computed := gl.TrialBalance(bq,
+		args.String("company", ""),
+		args.String("year", ""),
+		fromPer,
+		args.Int("toper", fromPer),
+		args.String("rollup", ""))
+	switch res {
+	case constants.ResultFail:
+		log.Println("Fiscal year or periods not found.")
+	case constants.ResultConstant(3):
+		log.Println("The roll-up mask is longer than the account numbers.")
+	}
+	if res != constants.ResultSuccess {
+		return res
+	}
+
+	if computed {
+		log.Println("Beginning balances have not been rolled for the fiscal year. Balances were computed from prior years.")
+	}
+
+	if format == formatJSON {
+		writeJSON(w, struct {
+			BegBalComputed bool                  `json:"begbalcomputed"`
+			Lines          []gl.TrialBalanceLine `json:"lines"`
+		}{computed, lines})
+		return res
+	}
+
+	var tbeg, tdr, tcr, tend dec.Decimal
+	rows := make([][]string, 0, len(lines)+1)
+	for _, l := range lines {
+		rows = append(rows, []string{
+			l.GLAcctNo,
+			l.Description,
+			strconv.Itoa(l.AcctCatID),
+			fmtAmt(l.BegBal),
+			fmtAmt(l.DebitAmt),
+			fmtAmt(l.CreditAmt),
+			fmtAmt(l.EndBal),
+		})
+		tbeg = tbeg.Add(l.BegBal)
+		tdr = tdr.Add(l.DebitAmt)
+		tcr = tcr.Add(l.CreditAmt)
+		tend = tend.Add(l.EndBal)
+	}
+
+	if format != formatCSV {
+		rows = append(rows, []string{"Total", "", "", fmtAmt(tbeg), fmtAmt(tdr), fmtAmt(tcr), fmtAmt(tend)})
+	}
+
+	writeTable(w, format, "Trial Balance",
+		[]string{"Account", "Description", "Cat", "BegBal", "Debit", "Credit", "EndBal"}, rows)
+
+	return res
+}
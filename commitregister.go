@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"gosqljobs/invtcommit/functions/constants"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	du "github.com/eaglebush/datautils"
+)
+
+// commitRegisters - register procedure of each module, set by loadCommitRegisters
+var commitRegisters = make(map[constants.ModuleConstant]string)
+
+// commitRegisterName - a procedure name, optionally qualified with its schema
+var commitRegisterName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// loadCommitRegisters - reads the register procedures of the commit from the configuration file.
+//
+// The procedures are in the CommitRegisters section, keyed by module ID:
+//
+//    "CommitRegisters": {
+//        "IM": "spimInvtTranRegister_HAI",
+//        "PO": "sppoReceiverRegister_HAI"
+//    }
+//
+// A register procedure creates the posting rows (timPosting and tglPosting) of the
+// pending transactions of a batch and returns 1 when successful:
+//
+//    EXEC @RetVal = <procedure> @iBatchKey, @iCompanyID
+func loadCommitRegisters(configfile string) error {
+	b, err := ioutil.ReadFile(configfile)
+	if err != nil {
+		return err
+	}
+
+	var c struct {
+		CommitRegisters map[string]string
+	}
+	if err = json.Unmarshal(b, &c); err != nil {
+		return err
+	}
+
+	for k, v := range c.CommitRegisters {
+		if m, ok := batchCmntModules[strings.ToUpper(k)]; ok && commitRegisterName.MatchString(v) {
+			commitRegisters[m] = v
+		}
+	}
+
+	return nil
+}
+
+// runCommitRegister - runs the register procedure of the module of a disposable batch (im.RegisterFunc)
+func runCommitRegister(
+	bq *du.BatchQuery,
+	iBatchKey int,
+	iCompanyID string,
+	iModuleNo constants.ModuleConstant) constants.ResultConstant {
+
+	proc, ok := commitRegisters[iModuleNo]
+	if !ok {
+		return constants.ResultError
+	}
+
+	qr := bq.Get(`DECLARE @r INT;
+				  EXEC @r = `+proc+` ?, ?;
+				  SELECT @r;`, iBatchKey, iCompanyID)
+	if !bq.OK() || !qr.HasData {
+		return constants.ResultError
+	}
+
+	return constants.ResultConstant(qr.First().ValueInt64Ord(0))
+}
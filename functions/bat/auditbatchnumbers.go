@@ -0,0 +1,108 @@
+package bat
+
+import (
+	"gosqljobs/invtcommit/functions/constants"
+
+	du "github.com/eaglebush/datautils"
+)
+
+// BatchNoGap - a range of unused batch numbers between used batch numbers
+type BatchNoGap struct {
+	CompanyID string `json:"companyid"`
+	BatchType int    `json:"batchtype"`
+	FromNo    int    `json:"fromno"`
+	ToNo      int    `json:"tono"`
+}
+
+// BatchIDDup - a BatchID used by more than one batch of a company
+type BatchIDDup struct {
+	CompanyID   string `json:"companyid"`
+	BatchID     string `json:"batchid"`
+	Count       int    `json:"count"`
+	MinBatchKey int    `json:"minbatchkey"`
+	MaxBatchKey int    `json:"maxbatchkey"`
+}
+
+// AuditBatchNumbers - Reports the gaps in the batch numbers and the duplicate BatchIDs of tciBatchLog
+// ---------------------------------------------------------------------
+// Hidden batches (BatchNo = 0) are not audited.
+//
+// Input Parameters:
+//    @_iCompanyId       Company ID. Empty for all companies.
+//    @_iBatchType       Type of batch. 0 for all batch types.
+// Output Parameters:
+//    oRetVal      ReturnValue:
+// 	  0 - Did not make it through the procedure
+// 	  1 - No gaps or duplicates
+// 	  2 - Gaps or duplicates found
+//    oGaps        Ranges of unused batch numbers
+//    oDups        Duplicate BatchIDs
+// ---------------------------------------------------------------------
+func AuditBatchNumbers(
+	bq *du.BatchQuery,
+	iCompanyID string,
+	iBatchType int) (Result constants.ResultConstant, Gaps []BatchNoGap, Dups []BatchIDDup) {
+
+	bq.ScopeName("AuditBatchNumbers")
+
+	qr := bq.Get(`SELECT DISTINCT a.SourceCompanyID, a.BatchType, a.BatchNo + 1 AS FromNo,
+						(SELECT MIN(b.BatchNo) FROM tciBatchLog b WITH (NOLOCK)
+						 WHERE b.SourceCompanyID = a.SourceCompanyID
+							AND b.BatchType = a.BatchType
+							AND b.BatchNo > a.BatchNo) - 1 AS ToNo
+				  FROM tciBatchLog a WITH (NOLOCK)
+				  WHERE a.BatchNo > 0
+					AND (a.SourceCompanyID=? OR ?='')
+					AND (a.BatchType=? OR ?=0)
+					AND NOT EXISTS (SELECT 1 FROM tciBatchLog c WITH (NOLOCK)
+									WHERE c.SourceCompanyID = a.SourceCompanyID
+										AND c.BatchType = a.BatchType
+										AND c.BatchNo = a.BatchNo + 1)
+					AND EXISTS (SELECT 1 FROM tciBatchLog d WITH (NOLOCK)
+								WHERE d.SourceCompanyID = a.SourceCompanyID
+									AND d.BatchType = a.BatchType
+									AND d.BatchNo > a.BatchNo)
+				  ORDER BY a.SourceCompanyID, a.BatchType, FromNo;`, iCompanyID, iCompanyID, iBatchType, iBatchType)
+	if !bq.OK() {
+		return constants.ResultError, nil, nil
+	}
+
+	Gaps = make([]BatchNoGap, 0, len(qr.Data))
+	for _, v := range qr.Data {
+		Gaps = append(Gaps, BatchNoGap{
+			CompanyID: v.ValueString("SourceCompanyID"),
+			BatchType: int(v.ValueInt64("BatchType")),
+			FromNo:    int(v.ValueInt64("FromNo")),
+			ToNo:      int(v.ValueInt64("ToNo")),
+		})
+	}
+
+	qr = bq.Get(`SELECT SourceCompanyID, BatchID, COUNT(*) AS Cnt, MIN(BatchKey) AS MinBatchKey, MAX(BatchKey) AS MaxBatchKey
+				 FROM tciBatchLog WITH (NOLOCK)
+				 WHERE BatchNo > 0
+					AND (SourceCompanyID=? OR ?='')
+					AND (BatchType=? OR ?=0)
+				 GROUP BY SourceCompanyID, BatchID
+				 HAVING COUNT(*) > 1
+				 ORDER BY SourceCompanyID, BatchID;`, iCompanyID, iCompanyID, iBatchType, iBatchType)
+	if !bq.OK() {
+		return constants.ResultError, nil, nil
+	}
+
+	Dups = make([]BatchIDDup, 0, len(qr.Data))
+	for _, v := range qr.Data {
+		Dups = append(Dups, BatchIDDup{
+			CompanyID:   v.ValueString("SourceCompanyID"),
+			BatchID:     v.ValueString("BatchID"),
+			Count:       int(v.ValueInt64("Cnt")),
+			MinBatchKey: int(v.ValueInt64("MinBatchKey")),
+			MaxBatchKey: int(v.ValueInt64("MaxBatchKey")),
+		})
+	}
+
+	if len(Gaps) > 0 || len(Dups) > 0 {
+		return constants.ResultFail, Gaps, Dups
+	}
+
+	return constants.ResultSuccess, Gaps, Dups
+}
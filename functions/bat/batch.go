@@ -0,0 +1,272 @@
+package bat
+
+import (
+	"gosqljobs/invtcommit/functions/constants"
+
+	du "github.com/eaglebush/datautils"
+)
+
+// Batch - a tciBatchLog record and its posting life cycle.
+//
+// The Status and PostStatus of a batch are only changed through the transition
+// methods below.  Each method refuses a transition that is not allowed from the
+// current state and updates tciBatchLog only when the UpdateCounter read with the
+// batch is unchanged.  When another process has changed the batch in between, the
+// batch is reloaded and BatchReturnConflict is returned.
+//
+// Posting life cycle (PostStatus):
+//    Open(0) -> PrepStarted(100) -> PrepCompleted(150) -> ModStarted(200) -> ModCompleted(250)
+//            -> GLStarted(300) -> GLCompleted(350) -> ModClnUpStarted(400) -> ModClnUpCompleted(450)
+//            -> Completed(500)
+//    The GL and module clean up steps can be skipped.  A batch can be Deleted(999) while
+//    its module posting has not completed.  Interrupt keeps the PostStatus as a checkpoint
+//    and sets the Status to Interrupted.  Resume sets it back to Posting.
+type Batch struct {
+	BatchKey      int
+	BatchID       string
+	BatchNo       int
+	BatchType     int
+	CompanyID     string
+	Status        constants.BatchStatusConstant
+	PostStatus    constants.BatchPostStatusConstant
+	UpdateCounter int
+	History       []BatchTransition
+}
+
+// BatchTransition - a change of Status and PostStatus made to a batch
+//
+// Transitions are also written to the optional table tciBatchLogHist_HAI:
+//
+//    CREATE TABLE tciBatchLogHist_HAI (
+//       BatchKey        INTEGER NOT NULL,
+//       Transition      VARCHAR(30) NOT NULL,  -- Name of the Batch method
+//       FromStatus      SMALLINT NOT NULL,
+//       ToStatus        SMALLINT NOT NULL,
+//       FromPostStatus  SMALLINT NOT NULL,
+//       ToPostStatus    SMALLINT NOT NULL,
+//       UpdateCounter   INTEGER NOT NULL,      -- UpdateCounter of tciBatchLog after the transition
+//       TransitionDate  DATETIME NOT NULL)
+//
+// Without the table the transitions are only kept in Batch.History.
+type BatchTransition struct {
+	Name           string
+	FromStatus     constants.BatchStatusConstant
+	ToStatus       constants.BatchStatusConstant
+	FromPostStatus constants.BatchPostStatusConstant
+	ToPostStatus   constants.BatchPostStatusConstant
+}
+
+// batchPostStatusNext - the post statuses a batch can move to from each post status
+var batchPostStatusNext = map[constants.BatchPostStatusConstant][]constants.BatchPostStatusConstant{
+	constants.BatchPostStatusOpen:              {constants.BatchPostStatusPrepStarted, constants.BatchPostStatusDeleted},
+	constants.BatchPostStatusPrepStarted:       {constants.BatchPostStatusPrepCompleted, constants.BatchPostStatusDeleted},
+	constants.BatchPostStatusPrepCompleted:     {constants.BatchPostStatusModStarted, constants.BatchPostStatusDeleted},
+	constants.BatchPostStatusModStarted:        {constants.BatchPostStatusModCompleted, constants.BatchPostStatusDeleted},
+	constants.BatchPostStatusModCompleted:      {constants.BatchPostStatusGLStarted, constants.BatchPostStatusModClnUpStarted, constants.BatchPostStatusCompleted},
+	constants.BatchPostStatusGLStarted:         {constants.BatchPostStatusGLCompleted},
+	constants.BatchPostStatusGLCompleted:       {constants.BatchPostStatusModClnUpStarted, constants.BatchPostStatusCompleted},
+	constants.BatchPostStatusModClnUpStarted:   {constants.BatchPostStatusModClnUpCompleted},
+	constants.BatchPostStatusModClnUpCompleted: {constants.BatchPostStatusCompleted},
+}
+
+// LoadBatch - reads a batch from tciBatchLog
+// ---------------------------------------------------------------------
+// Input Parameters:
+// @_iBatchKey         Key of the batch
+//
+// Output Parameters:
+// @_oRetVal           ReturnValue:
+// 0 - Did not make it through the procedure
+// 1 - batch read
+// 3 - batch does not exist
+// @_oRec              The batch
+// ---------------------------------------------------------------------
+func LoadBatch(bq *du.BatchQuery, iBatchKey int) (Result constants.BatchReturnConstant, Rec *Batch) {
+	bq.ScopeName("LoadBatch")
+
+	qr := bq.Get(`SELECT BatchKey, BatchID, BatchNo, BatchType, SourceCompanyID,
+						Status, PostStatus, COALESCE(UpdateCounter, 0) AS UpdateCounter
+				  FROM tciBatchLog WITH (NOLOCK)
+				  WHERE BatchKey=?;`, iBatchKey)
+	if !bq.OK() {
+		return constants.BatchReturnError, nil
+	}
+	if !qr.HasData {
+		return constants.BatchReturnNoRecord, nil
+	}
+
+	r := qr.First()
+	return constants.BatchReturnValid, &Batch{
+		BatchKey:      int(r.ValueInt64("BatchKey")),
+		BatchID:       r.ValueString("BatchID"),
+		BatchNo:       int(r.ValueInt64("BatchNo")),
+		BatchType:     int(r.ValueInt64("BatchType")),
+		CompanyID:     r.ValueString("SourceCompanyID"),
+		Status:        constants.BatchStatusConstant(r.ValueInt64("Status")),
+		PostStatus:    constants.BatchPostStatusConstant(r.ValueInt64("PostStatus")),
+		UpdateCounter: int(r.ValueInt64("UpdateCounter")),
+	}
+}
+
+// BeginPrep - starts the posting of a balanced open batch
+func (b *Batch) BeginPrep(bq *du.BatchQuery) constants.BatchReturnConstant {
+	if b.Status != constants.BatchStatusBalanced {
+		return constants.BatchReturnIllegal
+	}
+
+	return b.transition(bq, "BeginPrep", constants.BatchStatusPosting, constants.BatchPostStatusPrepStarted)
+}
+
+// EndPrep - marks the pre-posting (validation and register) as completed
+func (b *Batch) EndPrep(bq *du.BatchQuery) constants.BatchReturnConstant {
+	return b.posting(bq, "EndPrep", constants.BatchPostStatusPrepCompleted)
+}
+
+// BeginModulePost - marks the module posting as started
+func (b *Batch) BeginModulePost(bq *du.BatchQuery) constants.BatchReturnConstant {
+	return b.posting(bq, "BeginModulePost", constants.BatchPostStatusModStarted)
+}
+
+// MarkModulePosted - marks the module posting as completed
+func (b *Batch) MarkModulePosted(bq *du.BatchQuery) constants.BatchReturnConstant {
+	return b.posting(bq, "MarkModulePosted", constants.BatchPostStatusModCompleted)
+}
+
+// BeginGLPost - marks the GL posting as started
+func (b *Batch) BeginGLPost(bq *du.BatchQuery) constants.BatchReturnConstant {
+	return b.posting(bq, "BeginGLPost", constants.BatchPostStatusGLStarted)
+}
+
+// MarkGLPosted - marks the GL posting as completed
+func (b *Batch) MarkGLPosted(bq *du.BatchQuery) constants.BatchReturnConstant {
+	return b.posting(bq, "MarkGLPosted", constants.BatchPostStatusGLCompleted)
+}
+
+// BeginCleanup - marks the module clean up as started
+func (b *Batch) BeginCleanup(bq *du.BatchQuery) constants.BatchReturnConstant {
+	return b.posting(bq, "BeginCleanup", constants.BatchPostStatusModClnUpStarted)
+}
+
+// EndCleanup - marks the module clean up as completed
+func (b *Batch) EndCleanup(bq *du.BatchQuery) constants.BatchReturnConstant {
+	return b.posting(bq, "EndCleanup", constants.BatchPostStatusModClnUpCompleted)
+}
+
+// Complete - marks the batch as posted
+func (b *Batch) Complete(bq *du.BatchQuery) constants.BatchReturnConstant {
+	if b.Status != constants.BatchStatusPosting || !b.allowed(constants.BatchPostStatusCompleted) {
+		return constants.BatchReturnIllegal
+	}
+
+	return b.transition(bq, "Complete", constants.BatchStatusPosted, constants.BatchPostStatusCompleted)
+}
+
+// Delete - marks the batch as deleted.  A batch can only be deleted while its module posting
+// has not completed.
+func (b *Batch) Delete(bq *du.BatchQuery) constants.BatchReturnConstant {
+	if b.Status == constants.BatchStatusPosted || !b.allowed(constants.BatchPostStatusDeleted) {
+		return constants.BatchReturnIllegal
+	}
+
+	return b.transition(bq, "Delete", b.Status, constants.BatchPostStatusDeleted)
+}
+
+// Interrupt - marks a batch being posted as interrupted.  The PostStatus is kept as the
+// checkpoint where the posting stopped.
+func (b *Batch) Interrupt(bq *du.BatchQuery) constants.BatchReturnConstant {
+	if b.Status != constants.BatchStatusPosting ||
+		b.PostStatus <= constants.BatchPostStatusOpen ||
+		b.PostStatus >= constants.BatchPostStatusCompleted {
+		return constants.BatchReturnIllegal
+	}
+
+	return b.transition(bq, "Interrupt", constants.BatchStatusInterrupted, b.PostStatus)
+}
+
+// Resume - sets an interrupted batch back to posting.  The PostStatus is the checkpoint
+// where the posting continues.
+func (b *Batch) Resume(bq *du.BatchQuery) constants.BatchReturnConstant {
+	if b.Status != constants.BatchStatusInterrupted {
+		return constants.BatchReturnIllegal
+	}
+
+	return b.transition(bq, "Resume", constants.BatchStatusPosting, b.PostStatus)
+}
+
+// posting - moves a batch being posted to the next post status
+func (b *Batch) posting(bq *du.BatchQuery, iName string, iPostStatus constants.BatchPostStatusConstant) constants.BatchReturnConstant {
+	if b.Status != constants.BatchStatusPosting || !b.allowed(iPostStatus) {
+		return constants.BatchReturnIllegal
+	}
+
+	return b.transition(bq, iName, b.Status, iPostStatus)
+}
+
+// allowed - checks if the post status can be reached from the current post status
+func (b *Batch) allowed(iPostStatus constants.BatchPostStatusConstant) bool {
+	for _, ps := range batchPostStatusNext[b.PostStatus] {
+		if ps == iPostStatus {
+			return true
+		}
+	}
+
+	return false
+}
+
+// transition - writes the new status of the batch when the batch has not been changed
+// since it was read and records the transition
+func (b *Batch) transition(
+	bq *du.BatchQuery,
+	iName string,
+	iStatus constants.BatchStatusConstant,
+	iPostStatus constants.BatchPostStatusConstant) constants.BatchReturnConstant {
+
+	bq.ScopeName("Batch." + iName)
+
+	qr := bq.Set(`UPDATE tciBatchLog
+				  SET Status=?, PostStatus=?, UpdateCounter=COALESCE(UpdateCounter, 0) + 1
+				  WHERE BatchKey=? AND COALESCE(UpdateCounter, 0)=?
+					AND Status=? AND PostStatus=?;`,
+		iStatus, iPostStatus, b.BatchKey, b.UpdateCounter, b.Status, b.PostStatus)
+	if !bq.OK() {
+		return constants.BatchReturnError
+	}
+
+	if !qr.HasAffectedRows {
+		// Another process changed the batch. Refresh it so the caller can decide again.
+		res, nb := LoadBatch(bq, b.BatchKey)
+		if res != constants.BatchReturnValid {
+			return res
+		}
+
+		nb.History = b.History
+		*b = *nb
+		return constants.BatchReturnConflict
+	}
+
+	t := BatchTransition{
+		Name:           iName,
+		FromStatus:     b.Status,
+		ToStatus:       iStatus,
+		FromPostStatus: b.PostStatus,
+		ToPostStatus:   iPostStatus,
+	}
+
+	b.Status = iStatus
+	b.PostStatus = iPostStatus
+	b.UpdateCounter++
+	b.History = append(b.History, t)
+
+	// The history table is optional
+	bq.Set(`IF OBJECT_ID('tciBatchLogHist_HAI') IS NOT NULL
+				INSERT INTO tciBatchLogHist_HAI (
+					BatchKey, Transition, FromStatus, ToStatus,
+					FromPostStatus, ToPostStatus, UpdateCounter, TransitionDate)
+				VALUES (?, ?, ?, ?, ?, ?, ?, GETDATE());`,
+		b.BatchKey, t.Name, t.FromStatus, t.ToStatus, t.FromPostStatus, t.ToPostStatus, b.UpdateCounter)
+	if !bq.OK() {
+		return constants.BatchReturnError
+	}
+
+	return constants.BatchReturnValid
+}
@@ -0,0 +1,119 @@
+package bat
+
+import (
+	"gosqljobs/invtcommit/functions/constants"
+	"testing"
+)
+
+// TestBatchAllowed - the post statuses a batch can move to follow the posting life cycle
+func TestBatchAllowed(t *testing.T) {
+	tests := []struct {
+		from, to constants.BatchPostStatusConstant
+		want     bool
+	}{
+		{constants.BatchPostStatusOpen, constants.BatchPostStatusPrepStarted, true},
+		{constants.BatchPostStatusOpen, constants.BatchPostStatusModStarted, false},
+		{constants.BatchPostStatusOpen, constants.BatchPostStatusDeleted, true},
+		{constants.BatchPostStatusPrepStarted, constants.BatchPostStatusPrepCompleted, true},
+		{constants.BatchPostStatusPrepCompleted, constants.BatchPostStatusModStarted, true},
+		{constants.BatchPostStatusModStarted, constants.BatchPostStatusModCompleted, true},
+		{constants.BatchPostStatusModStarted, constants.BatchPostStatusGLStarted, false},
+		{constants.BatchPostStatusModStarted, constants.BatchPostStatusDeleted, true},
+		{constants.BatchPostStatusModCompleted, constants.BatchPostStatusGLStarted, true},
+		{constants.BatchPostStatusModCompleted, constants.BatchPostStatusModClnUpStarted, true},
+		{constants.BatchPostStatusModCompleted, constants.BatchPostStatusCompleted, true},
+		{constants.BatchPostStatusModCompleted, constants.BatchPostStatusDeleted, false},
+		{constants.BatchPostStatusGLStarted, constants.BatchPostStatusGLCompleted, true},
+		{constants.BatchPostStatusGLStarted, constants.BatchPostStatusCompleted, false},
+		{constants.BatchPostStatusGLCompleted, constants.BatchPostStatusModClnUpStarted, true},
+		{constants.BatchPostStatusGLCompleted, constants.BatchPostStatusCompleted, true},
+		{constants.BatchPostStatusModClnUpStarted, constants.BatchPostStatusModClnUpCompleted, true},
+		{constants.BatchPostStatusModClnUpCompleted, constants.BatchPostStatusCompleted, true},
+		{constants.BatchPostStatusCompleted, constants.BatchPostStatusDeleted, false},
+		{constants.BatchPostStatusDeleted, constants.BatchPostStatusOpen, false},
+	}
+
+	for _, tt := range tests {
+		b := &Batch{Status: constants.BatchStatusPosting, PostStatus: tt.from}
+		if got := b.allowed(tt.to); got != tt.want {
+			t.Errorf("allowed(%d -> %d) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+// TestBatchPostStatusNextReachesCompleted - every post status of the table, except the final
+// ones, can reach Completed
+func TestBatchPostStatusNextReachesCompleted(t *testing.T) {
+	for from := range batchPostStatusNext {
+		seen := map[constants.BatchPostStatusConstant]bool{from: true}
+		queue := []constants.BatchPostStatusConstant{from}
+		for len(queue) > 0 {
+			ps := queue[0]
+			queue = queue[1:]
+			for _, n := range batchPostStatusNext[ps] {
+				if !seen[n] {
+					seen[n] = true
+					queue = append(queue, n)
+				}
+			}
+		}
+
+		if !seen[constants.BatchPostStatusCompleted] {
+			t.Errorf("post status %d cannot reach Completed", from)
+		}
+	}
+
+	for _, ps := range []constants.BatchPostStatusConstant{constants.BatchPostStatusCompleted, constants.BatchPostStatusDeleted} {
+		if len(batchPostStatusNext[ps]) != 0 {
+			t.Errorf("post status %d is final but has next post statuses", ps)
+		}
+	}
+}
+
+// TestBatchIllegalTransitions - transitions refused by the guards return BatchReturnIllegal
+// before tciBatchLog is touched
+func TestBatchIllegalTransitions(t *testing.T) {
+	tests := []struct {
+		name string
+		b    Batch
+		call func(b *Batch) constants.BatchReturnConstant
+	}{
+		{"BeginPrep of an unbalanced batch",
+			Batch{Status: constants.BatchStatusOutOfBal, PostStatus: constants.BatchPostStatusOpen},
+			func(b *Batch) constants.BatchReturnConstant { return b.BeginPrep(nil) }},
+		{"EndPrep of a batch not being posted",
+			Batch{Status: constants.BatchStatusInterrupted, PostStatus: constants.BatchPostStatusPrepStarted},
+			func(b *Batch) constants.BatchReturnConstant { return b.EndPrep(nil) }},
+		{"BeginGLPost before the module posting",
+			Batch{Status: constants.BatchStatusPosting, PostStatus: constants.BatchPostStatusModStarted},
+			func(b *Batch) constants.BatchReturnConstant { return b.BeginGLPost(nil) }},
+		{"Complete during the GL posting",
+			Batch{Status: constants.BatchStatusPosting, PostStatus: constants.BatchPostStatusGLStarted},
+			func(b *Batch) constants.BatchReturnConstant { return b.Complete(nil) }},
+		{"Delete of a posted batch",
+			Batch{Status: constants.BatchStatusPosted, PostStatus: constants.BatchPostStatusCompleted},
+			func(b *Batch) constants.BatchReturnConstant { return b.Delete(nil) }},
+		{"Delete after the module posting",
+			Batch{Status: constants.BatchStatusPosting, PostStatus: constants.BatchPostStatusModCompleted},
+			func(b *Batch) constants.BatchReturnConstant { return b.Delete(nil) }},
+		{"Interrupt of an open batch",
+			Batch{Status: constants.BatchStatusPosting, PostStatus: constants.BatchPostStatusOpen},
+			func(b *Batch) constants.BatchReturnConstant { return b.Interrupt(nil) }},
+		{"Interrupt of a completed batch",
+			Batch{Status: constants.BatchStatusPosting, PostStatus: constants.BatchPostStatusCompleted},
+			func(b *Batch) constants.BatchReturnConstant { return b.Interrupt(nil) }},
+		{"Resume of a batch not interrupted",
+			Batch{Status: constants.BatchStatusPosting, PostStatus: constants.BatchPostStatusModStarted},
+			func(b *Batch) constants.BatchReturnConstant { return b.Resume(nil) }},
+	}
+
+	for _, tt := range tests {
+		b := tt.b
+		if res := tt.call(&b); res != constants.BatchReturnIllegal {
+			t.Errorf("%s: got %d, want BatchReturnIllegal", tt.name, res)
+		}
+		if b.Status != tt.b.Status || b.PostStatus != tt.b.PostStatus || len(b.History) != 0 {
+			t.Errorf("%s: batch changed by a refused transition", tt.name)
+		}
+	}
+}
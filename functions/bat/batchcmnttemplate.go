@@ -0,0 +1,92 @@
+package bat
+
+import (
+	"gosqljobs/invtcommit/functions/constants"
+	"strings"
+	"sync"
+)
+
+// batchCmntMaxLen - length of the BatchCmnt column of tciBatchLog and the module batch tables
+const batchCmntMaxLen = 50
+
+// Batch comment template placeholders
+//
+//    {Cmnt}      The default comment passed by the caller
+//    {Whse}      Warehouse ID of the transactions (blank when they are from several warehouses)
+//    {TranFrom}  First transaction ID of the batch
+//    {TranTo}    Last transaction ID of the batch
+//    {PostDate}  Post date of the batch (yyyy-mm-dd)
+//    {RunID}     ID of the run that created the batch
+//    {User}      User creating the batch
+//
+// The template is also applied to the hidden batches (the disposable batches of
+// the inventory commit).  A template without {Cmnt} drops the comment passed by
+// the caller.  Comments longer than the BatchCmnt column are cut, so {Cmnt} is
+// best put last.
+//
+// Example: "Run {RunID} {Whse} {TranFrom}-{TranTo} {Cmnt}"
+var (
+	batchCmntMu        sync.RWMutex
+	batchCmntDefault   string
+	batchCmntTemplates = make(map[constants.ModuleConstant]string)
+	batchCmntRunID     string
+)
+
+// SetBatchCmntTemplates - sets the batch comment templates.  iDefault is used for the
+// modules without a template of their own.  An empty template keeps the comment
+// passed by the caller.
+func SetBatchCmntTemplates(iDefault string, iTemplates map[constants.ModuleConstant]string) {
+	batchCmntMu.Lock()
+	defer batchCmntMu.Unlock()
+
+	batchCmntDefault = iDefault
+	batchCmntTemplates = make(map[constants.ModuleConstant]string)
+	for k, v := range iTemplates {
+		batchCmntTemplates[k] = v
+	}
+}
+
+// SetBatchCmntRunID - sets the ID of the current run used for the {RunID} placeholder
+func SetBatchCmntRunID(iRunID string) {
+	batchCmntMu.Lock()
+	defer batchCmntMu.Unlock()
+
+	batchCmntRunID = iRunID
+}
+
+// FormatBatchCmnt - returns the batch comment of a batch from the template of its module.
+// The comment is cut to the length of the BatchCmnt column.
+func FormatBatchCmnt(iModuleNo constants.ModuleConstant, iOpt BatchOptions) string {
+	batchCmntMu.RLock()
+	tpl, ok := batchCmntTemplates[iModuleNo]
+	if !ok || tpl == "" {
+		tpl = batchCmntDefault
+	}
+	runID := batchCmntRunID
+	batchCmntMu.RUnlock()
+
+	cmnt := iOpt.BatchCmnt
+	if tpl != "" {
+		postDate := ""
+		if !iOpt.PostDate.IsZero() {
+			postDate = iOpt.PostDate.Format("2006-01-02")
+		}
+
+		cmnt = strings.NewReplacer(
+			"{Cmnt}", iOpt.BatchCmnt,
+			"{Whse}", iOpt.WhseID,
+			"{TranFrom}", iOpt.FromTranID,
+			"{TranTo}", iOpt.ToTranID,
+			"{PostDate}", postDate,
+			"{RunID}", runID,
+			"{User}", iOpt.UserID,
+		).Replace(tpl)
+		cmnt = strings.Join(strings.Fields(cmnt), " ")
+	}
+
+	if len(cmnt) > batchCmntMaxLen {
+		cmnt = cmnt[:batchCmntMaxLen]
+	}
+
+	return cmnt
+}
@@ -0,0 +1,122 @@
+package bat
+
+import (
+	"gosqljobs/invtcommit/functions/constants"
+	"sync"
+	"time"
+
+	du "github.com/eaglebush/datautils"
+)
+
+// BatchOptions - options used to create the module batch record of a batch
+type BatchOptions struct {
+	CompanyID string     // Current Company ID
+	UserID    string     // Acuity User Id
+	BatchCmnt string     // Default batch comment
+	PostDate  time.Time  // Post date
+	BatchType int        // Type of batch (Numeric Code)
+	BatchKey  int        // Key of the batch to insert. Set by GetNextBatch.
+	InvcDate  *time.Time // Optional. Invoice date (SO)
+
+	// Optional. Used by the batch comment templates
+	WhseID     string // Warehouse ID of the transactions
+	FromTranID string // First transaction ID
+	ToTranID   string // Last transaction ID
+}
+
+// BatchCreator - creates the module batch record (txxBatch) of a batch logged in tciBatchLog
+type BatchCreator interface {
+	CreateBatch(bq *du.BatchQuery, opt BatchOptions) constants.BatchReturnConstant
+}
+
+// BatchTypeValidator - optionally implemented by a BatchCreator to refuse batch types
+// before a batch number is used
+type BatchTypeValidator interface {
+	ValidBatchType(iBatchType int) bool
+}
+
+// BatchTableNamer - implemented by the BatchCreator of a custom module to name its
+// batch table (txxBatch) so that UpdateBatchLogCmnt can copy the batch comment
+type BatchTableNamer interface {
+	BatchTable() string
+}
+
+// BatchCreatorFunc - adapts a function to a BatchCreator
+type BatchCreatorFunc func(bq *du.BatchQuery, opt BatchOptions) constants.BatchReturnConstant
+
+// CreateBatch - calls the function
+func (f BatchCreatorFunc) CreateBatch(bq *du.BatchQuery, opt BatchOptions) constants.BatchReturnConstant {
+	return f(bq, opt)
+}
+
+var (
+	batchCreatorsMu sync.RWMutex
+	batchCreators   = make(map[constants.ModuleConstant]BatchCreator)
+)
+
+// RegisterBatchCreator - registers the creator of the batches of a module.
+// A creator registered for a module replaces the previous one.
+func RegisterBatchCreator(iModuleNo constants.ModuleConstant, iCreator BatchCreator) {
+	batchCreatorsMu.Lock()
+	defer batchCreatorsMu.Unlock()
+
+	if iCreator == nil {
+		delete(batchCreators, iModuleNo)
+		return
+	}
+
+	batchCreators[iModuleNo] = iCreator
+}
+
+// GetBatchCreator - returns the creator registered for a module
+func GetBatchCreator(iModuleNo constants.ModuleConstant) (BatchCreator, bool) {
+	batchCreatorsMu.RLock()
+	defer batchCreatorsMu.RUnlock()
+
+	c, ok := batchCreators[iModuleNo]
+	return c, ok
+}
+
+// glBatchCreator - creates general journal batches
+type glBatchCreator struct{}
+
+func (glBatchCreator) CreateBatch(bq *du.BatchQuery, opt BatchOptions) constants.BatchReturnConstant {
+	return CreateGlBatch(bq, opt.CompanyID, opt.UserID, opt.BatchCmnt, opt.PostDate, opt.BatchType, opt.BatchKey)
+}
+
+func (glBatchCreator) ValidBatchType(iBatchType int) bool {
+	switch constants.BatchTranTypeConstant(iBatchType) {
+	case constants.BatchTranTypeGlGenJrnls, constants.BatchTranTypeGlAllocs,
+		constants.BatchTranTypeGlInterCompJrnls, constants.BatchTranTypeGlReversal:
+		return true
+	}
+	return false
+}
+
+func init() {
+	RegisterBatchCreator(constants.ModuleGL, glBatchCreator{})
+	RegisterBatchCreator(constants.ModuleAP, BatchCreatorFunc(func(bq *du.BatchQuery, opt BatchOptions) constants.BatchReturnConstant {
+		return CreateApBatch(bq, opt.CompanyID, opt.UserID, opt.BatchCmnt, opt.PostDate, opt.BatchKey)
+	}))
+	RegisterBatchCreator(constants.ModuleAR, BatchCreatorFunc(func(bq *du.BatchQuery, opt BatchOptions) constants.BatchReturnConstant {
+		return CreateArBatch(bq, opt.CompanyID, opt.UserID, opt.BatchCmnt, opt.PostDate, opt.BatchKey)
+	}))
+	RegisterBatchCreator(constants.ModuleIM, BatchCreatorFunc(func(bq *du.BatchQuery, opt BatchOptions) constants.BatchReturnConstant {
+		return CreateImBatch(bq, opt.CompanyID, opt.UserID, opt.BatchCmnt, opt.PostDate, opt.BatchKey)
+	}))
+	RegisterBatchCreator(constants.ModuleSO, BatchCreatorFunc(func(bq *du.BatchQuery, opt BatchOptions) constants.BatchReturnConstant {
+		return CreateSoBatch(bq, opt.CompanyID, opt.UserID, opt.BatchCmnt, opt.PostDate, opt.BatchKey, opt.InvcDate)
+	}))
+	RegisterBatchCreator(constants.ModuleCM, BatchCreatorFunc(func(bq *du.BatchQuery, opt BatchOptions) constants.BatchReturnConstant {
+		return CreateCmBatch(bq, opt.CompanyID, opt.UserID, opt.BatchCmnt, opt.PostDate, opt.BatchKey)
+	}))
+	RegisterBatchCreator(constants.ModuleMC, BatchCreatorFunc(func(bq *du.BatchQuery, opt BatchOptions) constants.BatchReturnConstant {
+		return CreateMcBatch(bq, opt.CompanyID, opt.UserID, opt.BatchCmnt, opt.BatchType, opt.BatchKey)
+	}))
+	RegisterBatchCreator(constants.ModulePO, BatchCreatorFunc(func(bq *du.BatchQuery, opt BatchOptions) constants.BatchReturnConstant {
+		return CreatePoBatch(bq, opt.CompanyID, opt.UserID, opt.BatchCmnt, opt.PostDate, opt.BatchKey)
+	}))
+	RegisterBatchCreator(constants.ModuleMF, BatchCreatorFunc(func(bq *du.BatchQuery, opt BatchOptions) constants.BatchReturnConstant {
+		return CreateMfBatch(bq, opt.CompanyID, opt.UserID, opt.BatchCmnt, opt.PostDate, opt.BatchKey)
+	}))
+}
@@ -0,0 +1,264 @@
+package bat
+
+import (
+	"gosqljobs/invtcommit/functions/constants"
+	"gosqljobs/invtcommit/functions/dec"
+
+	du "github.com/eaglebush/datautils"
+)
+
+// BatchTotals - totals of a batch computed from its detail rows
+//
+// The transactions of a shipment batch are its shipments (tsoPendShipment and
+// tsoShipment).  BatchTotal is the total of their amounts (TranAmtHC), as shown in
+// the batch list.  The transactions of the other batches are the distinct TranKeys
+// of their GL posting rows, pending (tglPosting) and posted (tglTransaction), and
+// BatchTotal is the total of the debits of these rows.  NextSeqNo is the number of
+// transactions of the batch plus one.
+type BatchTotals struct {
+	BatchKey   int         `json:"batchkey"`
+	BatchTotal dec.Decimal `json:"batchtotal"`
+	NextSeqNo  int         `json:"nextseqno"`
+}
+
+// BatchFlags - flags of the module batch record (txxBatch)
+type BatchFlags struct {
+	Hold     bool
+	Private  bool
+	TranCtrl bool
+}
+
+// BatchTotalDiff - a batch whose stored totals do not agree with its detail rows
+type BatchTotalDiff struct {
+	BatchKey          int         `json:"batchkey"`
+	BatchID           string      `json:"batchid"`
+	StoredTotal       dec.Decimal `json:"storedtotal"`
+	ComputedTotal     dec.Decimal `json:"computedtotal"`
+	StoredNextSeqNo   int         `json:"storednextseqno"`
+	ComputedNextSeqNo int         `json:"computednextseqno"`
+}
+
+// ComputeBatchTotals - Computes the BatchTotal and NextSeqNo of a batch from its detail rows
+// ---------------------------------------------------------------------
+// Input Parameters:
+//    @_iBatchKey       Key of the batch
+// Output Parameters:
+//    oRetVal      ReturnValue:
+// 	  0 - Did not make it through the procedure
+// 	  1 - Successful
+//    oTotals      Computed totals
+// ---------------------------------------------------------------------
+func ComputeBatchTotals(bq *du.BatchQuery, iBatchKey int) (Result constants.ResultConstant, Totals BatchTotals) {
+	bq.ScopeName("ComputeBatchTotals")
+
+	Totals.BatchKey = iBatchKey
+
+	qr := bq.Get(`SELECT COALESCE(SUM(d.Amt), 0), COUNT(DISTINCT d.TranKey)
+				  FROM (SELECT CASE WHEN PostAmtHC > 0 THEN PostAmtHC ELSE 0 END AS Amt, TranKey
+						FROM tglPosting WITH (NOLOCK) WHERE BatchKey=?
+						UNION ALL
+						SELECT CASE WHEN PostAmtHC > 0 THEN PostAmtHC ELSE 0 END, TranKey
+						FROM tglTransaction WITH (NOLOCK) WHERE BatchKey=?) d;`, iBatchKey, iBatchKey)
+	if !bq.OK() {
+		return constants.ResultError, Totals
+	}
+	lTotal, err := dec.Ord(qr.First(), 0)
+	if err != nil {
+		return constants.ResultError, Totals
+	}
+	Totals.BatchTotal = lTotal.RoundAmt()
+	lTranCount := int(qr.First().ValueInt64Ord(1))
+
+	qr = bq.Get(`SELECT bt.ModuleNo
+				 FROM tciBatchLog bl WITH (NOLOCK)
+					JOIN tciBatchType bt WITH (NOLOCK) ON bl.BatchType = bt.BatchType
+				 WHERE bl.BatchKey=?;`, iBatchKey)
+	if !bq.OK() {
+		return constants.ResultError, Totals
+	}
+
+	if qr.HasData && constants.ModuleConstant(qr.First().ValueInt64Ord(0)) == constants.ModuleSO {
+		qr = bq.Get(`SELECT COALESCE(SUM(s.TranAmtHC), 0), COUNT(*)
+					 FROM (SELECT TranAmtHC FROM tsoPendShipment WITH (NOLOCK) WHERE BatchKey=?
+						   UNION ALL
+						   SELECT TranAmtHC FROM tsoShipment WITH (NOLOCK) WHERE BatchKey=?) s;`, iBatchKey, iBatchKey)
+		if !bq.OK() {
+			return constants.ResultError, Totals
+		}
+		if lTotal, err = dec.Ord(qr.First(), 0); err != nil {
+			return constants.ResultError, Totals
+		}
+		Totals.BatchTotal = lTotal.RoundAmt()
+		lTranCount = int(qr.First().ValueInt64Ord(1))
+	}
+
+	Totals.NextSeqNo = lTranCount + 1
+
+	return constants.ResultSuccess, Totals
+}
+
+// UpdateBatchTotals - Writes the BatchTotal and NextSeqNo computed from the detail rows to tciBatchLog
+// ---------------------------------------------------------------------
+// Call this after transactions are assigned to or removed from a batch.  The
+// UpdateCounter is not changed as the totals are not part of the batch status.
+//
+// Input Parameters:
+//    @_iBatchKey       Key of the batch
+// Output Parameters:
+//    oRetVal      ReturnValue:
+// 	  0 - Did not make it through the procedure
+// 	  1 - Successful
+// ---------------------------------------------------------------------
+func UpdateBatchTotals(bq *du.BatchQuery, iBatchKey int) constants.ResultConstant {
+	res, t := ComputeBatchTotals(bq, iBatchKey)
+	if res != constants.ResultSuccess {
+		return res
+	}
+
+	bq.ScopeName("UpdateBatchTotals")
+
+	bq.Set(`UPDATE tciBatchLog
+			SET BatchTotal=?, NextSeqNo=?
+			WHERE BatchKey=? AND (COALESCE(BatchTotal, 0)<>? OR COALESCE(NextSeqNo, 0)<>?);`,
+		t.BatchTotal, t.NextSeqNo, iBatchKey, t.BatchTotal, t.NextSeqNo)
+	if !bq.OK() {
+		return constants.ResultError
+	}
+
+	return constants.ResultSuccess
+}
+
+// SetBatchFlags - Sets the Hold, Private and TranCtrl flags of the module batch record of a batch
+// ---------------------------------------------------------------------
+// Only the flags that are columns of the module batch table are set.  The flags
+// are also copied to tciBatchLog when it has the columns.
+//
+// Input Parameters:
+//    @_iBatchKey       Key of the batch
+//    @_iModuleNo       Module No
+//    @_iFlags          Flags
+// Output Parameters:
+//    oRetVal      ReturnValue:
+// 	  0 - Did not make it through the procedure
+// 	  1 - Successful
+// 	  2 - The module has no batch table
+// ---------------------------------------------------------------------
+func SetBatchFlags(
+	bq *du.BatchQuery,
+	iBatchKey int,
+	iModuleNo constants.ModuleConstant,
+	iFlags BatchFlags) constants.ResultConstant {
+
+	bq.ScopeName("SetBatchFlags")
+
+	tbl := ModuleBatchTable(iModuleNo)
+	if tbl == "" {
+		return constants.ResultFail
+	}
+
+	flag := func(b bool) int {
+		if b {
+			return 1
+		}
+		return 0
+	}
+
+	for _, t := range []string{tbl, "tciBatchLog"} {
+		for col, v := range map[string]int{
+			"Hold":     flag(iFlags.Hold),
+			"Private":  flag(iFlags.Private),
+			"TranCtrl": flag(iFlags.TranCtrl),
+		} {
+			qr := bq.Get(`SELECT 1 WHERE COL_LENGTH(?, ?) IS NOT NULL;`, t, col)
+			if !bq.OK() {
+				return constants.ResultError
+			}
+			if !qr.HasData {
+				continue
+			}
+
+			bq.Set(`UPDATE `+t+` SET `+col+`=? WHERE BatchKey=?;`, v, iBatchKey)
+			if !bq.OK() {
+				return constants.ResultError
+			}
+		}
+	}
+
+	return constants.ResultSuccess
+}
+
+// VerifyBatchTotals - Recomputes the totals of batches from their detail rows and reports the differences
+// ---------------------------------------------------------------------
+// Input Parameters:
+//    @_iCompanyId      Company ID
+//    @_iBatchKey       Key of a batch or 0 for all the batches of the company that are not deleted
+//    @_iApply          Write the computed totals to tciBatchLog
+// Output Parameters:
+//    oRetVal      ReturnValue:
+// 	  0 - Did not make it through the procedure
+// 	  1 - Totals agree (or were corrected)
+// 	  2 - Differences found and not applied
+//    oDiffs       Batches whose totals do not agree
+// ---------------------------------------------------------------------
+func VerifyBatchTotals(
+	bq *du.BatchQuery,
+	iCompanyID string,
+	iBatchKey int,
+	iApply bool) (Result constants.ResultConstant, Diffs []BatchTotalDiff) {
+
+	bq.ScopeName("VerifyBatchTotals")
+
+	qr := bq.Get(`SELECT BatchKey, BatchID, COALESCE(BatchTotal, 0) AS BatchTotal, COALESCE(NextSeqNo, 0) AS NextSeqNo
+				  FROM tciBatchLog WITH (NOLOCK)
+				  WHERE SourceCompanyID=? AND (BatchKey=? OR ?=0) AND PostStatus<>?
+				  ORDER BY BatchKey;`, iCompanyID, iBatchKey, iBatchKey, constants.BatchPostStatusDeleted)
+	if !bq.OK() {
+		return constants.ResultError, nil
+	}
+
+	Diffs = make([]BatchTotalDiff, 0)
+	for _, v := range qr.Data {
+		lStoredTotal, err := dec.Col(v, "BatchTotal")
+		if err != nil {
+			return constants.ResultError, nil
+		}
+
+		d := BatchTotalDiff{
+			BatchKey:        int(v.ValueInt64("BatchKey")),
+			BatchID:         v.ValueString("BatchID"),
+			StoredTotal:     lStoredTotal,
+			StoredNextSeqNo: int(v.ValueInt64("NextSeqNo")),
+		}
+
+		res, t := ComputeBatchTotals(bq, d.BatchKey)
+		if res != constants.ResultSuccess {
+			return constants.ResultError, nil
+		}
+
+		if t.BatchTotal.Equal(d.StoredTotal) && t.NextSeqNo == d.StoredNextSeqNo {
+			continue
+		}
+
+		d.ComputedTotal = t.BatchTotal
+		d.ComputedNextSeqNo = t.NextSeqNo
+		Diffs = append(Diffs, d)
+	}
+
+	bq.ScopeName("VerifyBatchTotals")
+
+	if len(Diffs) == 0 {
+		return constants.ResultSuccess, Diffs
+	}
+
+	if !iApply {
+		return constants.ResultFail, Diffs
+	}
+
+	for _, d := range Diffs {
+		if UpdateBatchTotals(bq, d.BatchKey) != constants.ResultSuccess {
+			return constants.ResultError, Diffs
+		}
+	}
+
+	return constants.ResultSuccess, Diffs
+}
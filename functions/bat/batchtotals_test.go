@@ -0,0 +1,96 @@
+package bat
+
+import (
+	"gosqljobs/invtcommit/functions/constants"
+	"gosqljobs/invtcommit/functions/dec"
+	"gosqljobs/invtcommit/functions/testdb"
+	"testing"
+	"time"
+
+	du "github.com/eaglebush/datautils"
+)
+
+// newTotalsBatch - creates a GL batch for the totals tests
+func newTotalsBatch(t *testing.T, bq *du.BatchQuery, iCompanyID string) int {
+	t.Helper()
+
+	res, key, _ := GetNextBatch(bq, iCompanyID, constants.ModuleGL, int(constants.BatchTranTypeGlGenJrnls), "test", "Batch totals test", time.Now(), 0, nil)
+	if res != constants.BatchReturnValid {
+		t.Fatalf("GetNextBatch returned %d: %s", res, bq.LastErrorText())
+	}
+
+	return key
+}
+
+// addPosting - inserts a pending GL posting row of a transaction of the batch
+func addPosting(t *testing.T, bq *du.BatchQuery, iCompanyID string, iBatchKey, iTranKey int, iAmt string) {
+	t.Helper()
+
+	bq.Set(`INSERT INTO tglPosting (
+				AcctRefKey, BatchKey, CurrID, ExtCmnt, GLAcctKey, JrnlKey, JrnlNo,
+				PostAmt, PostAmtHC, PostCmnt, PostDate, PostQty, SourceModuleNo, Summarize,
+				TranDate, TranKey, TranNo, TranType)
+			SELECT NULL, ?, c.CurrID, '', ?, NULL, 0,
+				?, ?, 'Batch totals test', ?, 0, ?, 0,
+				?, ?, 'TEST', ?
+			FROM tsmCompany c WITH (NOLOCK)
+			WHERE c.CompanyID=?;`,
+		iBatchKey, testdb.Int(t, "INVTCOMMIT_TEST_GLACCTKEY"), iAmt, iAmt, time.Now().Format("2006-01-02"),
+		constants.ModuleGL, time.Now().Format("2006-01-02"), iTranKey, constants.BatchTranTypeGlGenJrnls, iCompanyID)
+	if !bq.OK() {
+		t.Fatalf("Posting row not inserted: %s", bq.LastErrorText())
+	}
+}
+
+// TestComputeBatchTotalsEmpty - a batch without rows has no total and starts at sequence 1
+func TestComputeBatchTotalsEmpty(t *testing.T) {
+	bq := testdb.Open(t)
+	cid := testdb.CompanyID(t)
+	testdb.Begin(t, bq)
+
+	key := newTotalsBatch(t, bq, cid)
+
+	res, tot := ComputeBatchTotals(bq, key)
+	if res != constants.ResultSuccess {
+		t.Fatalf("ComputeBatchTotals returned %d: %s", res, bq.LastErrorText())
+	}
+	if !tot.BatchTotal.IsZero() || tot.NextSeqNo != 1 {
+		t.Errorf("got total %s and next sequence %d, want 0 and 1", tot.BatchTotal, tot.NextSeqNo)
+	}
+}
+
+// TestComputeBatchTotalsPosting - the total is the sum of the debits and the transactions
+// are the distinct TranKeys of the posting rows
+func TestComputeBatchTotalsPosting(t *testing.T) {
+	bq := testdb.Open(t)
+	cid := testdb.CompanyID(t)
+	testdb.Begin(t, bq)
+
+	key := newTotalsBatch(t, bq, cid)
+
+	addPosting(t, bq, cid, key, 1, "100.00")
+	addPosting(t, bq, cid, key, 1, "-100.00")
+	addPosting(t, bq, cid, key, 2, "25.50")
+	addPosting(t, bq, cid, key, 2, "-20.00")
+	addPosting(t, bq, cid, key, 2, "-5.50")
+
+	res, tot := ComputeBatchTotals(bq, key)
+	if res != constants.ResultSuccess {
+		t.Fatalf("ComputeBatchTotals returned %d: %s", res, bq.LastErrorText())
+	}
+
+	want, _ := dec.Parse("125.50")
+	if !tot.BatchTotal.Equal(want) || tot.NextSeqNo != 3 {
+		t.Errorf("got total %s and next sequence %d, want %s and 3", tot.BatchTotal, tot.NextSeqNo, want)
+	}
+
+	// The computed totals are stored and then agree with the rows
+	if res := UpdateBatchTotals(bq, key); res != constants.ResultSuccess {
+		t.Fatalf("UpdateBatchTotals returned %d: %s", res, bq.LastErrorText())
+	}
+
+	res, diffs := VerifyBatchTotals(bq, cid, key, false)
+	if res != constants.ResultSuccess || len(diffs) != 0 {
+		t.Errorf("VerifyBatchTotals returned %d with %d differences after UpdateBatchTotals", res, len(diffs))
+	}
+}
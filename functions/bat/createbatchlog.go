@@ -1,7 +1,6 @@
 package bat
 
 import (
-	"fmt"
 	"gosqljobs/invtcommit/functions/constants"
 	"gosqljobs/invtcommit/functions/sm"
 
@@ -9,6 +8,7 @@ import (
 )
 
 // CreateBatchLog - Creates a batch log record
+// The BatchID is formatted with the numbering policy of the company and batch type.
 // ---------------------------------------------------------------------
 // Input Parameters:
 // @_iCompanyId        current Company ID
@@ -48,7 +48,7 @@ func CreateBatchLog(
 		return constants.BatchReturnError, 0
 	}
 
-	batchID := qr.Get(0).ValueStringOrd(0) + fmt.Sprintf("%0d", iBatchType) + `-` + fmt.Sprintf("%0d", iBatchNo)
+	batchID := GetNumberingPolicy(bq, iCompanyID, iBatchType).FormatBatchID(qr.Get(0).ValueStringOrd(0), iBatchType, iBatchNo)
 	batchKey := sm.GetNextSurrogateKey(bq, `tciBatchLog`)
 
 	var rev interface{}
@@ -0,0 +1,68 @@
+package bat
+
+import (
+	"gosqljobs/invtcommit/functions/constants"
+	"time"
+
+	du "github.com/eaglebush/datautils"
+)
+
+// CreateGlBatch - Create the GL batch record
+// ---------------------------------------------------------------------
+// Input Parameters:
+//    @_iCompanyId       Current Company ID
+//    @_iUserId          Acuity User Id
+//    @_iDefBatchCmnt    Default batch comment (for tglBatch)
+//    @_dPostDate        Post date
+//    @_iBatchType       Type of batch (301, 304, 305 or 325)
+//    @_iBatchKey        Key of the batch to insert
+// Output Parameters:
+//    oRetVal      ReturnValue:
+// 	  0 - Did not make it through the procedure
+// 	  1 - Successfully created the tglBatch record
+// 	  5 - Failed to create tglBatch record
+// 	  6 - tglBatch record already exists for this batchkey
+// Notes:
+//    Intercompany journal batches (305) are flagged as InterCompany.
+func CreateGlBatch(
+	bq *du.BatchQuery,
+	iCompanyID string,
+	iUserID string,
+	iDefBatchCmnt string,
+	dPostDate time.Time,
+	iBatchType int,
+	iBatchKey int) constants.BatchReturnConstant {
+
+	bq.ScopeName("CreateGlBatch")
+
+	qr := bq.Get(`SELECT BatchKey FROM tglBatch WITH (NOLOCK) WHERE BatchKey=?;`, iBatchKey)
+	if qr.HasData {
+		return constants.BatchReturnExists
+	}
+
+	lInterCompany := 0
+	if constants.BatchTranTypeConstant(iBatchType) == constants.BatchTranTypeGlInterCompJrnls {
+		lInterCompany = 1
+	}
+
+	qr = bq.Set(`INSERT INTO tglBatch
+					(BatchKey,
+					BatchCmnt,
+					BatchOvrdSegValue,
+					Hold,
+					InterCompany,
+					OrigUserID,
+					PostDate,
+					Private,
+					TranCtrl,
+					UpdateCounter,
+					CreateDate)
+				VALUES (?,?,NULL,0,?,?,?,0,0,0,GETDATE());`, iBatchKey, iDefBatchCmnt, lInterCompany, iUserID, dPostDate)
+	if qr.HasData {
+		if qr.Get(0).ValueInt64("Affected") == 0 {
+			return constants.BatchReturnFailed
+		}
+	}
+
+	return constants.BatchReturnValid
+}
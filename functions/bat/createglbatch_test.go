@@ -0,0 +1,64 @@
+package bat
+
+import (
+	"gosqljobs/invtcommit/functions/constants"
+	"gosqljobs/invtcommit/functions/testdb"
+	"testing"
+	"time"
+)
+
+// TestGetNextBatchGL - GetNextBatch creates the tglBatch record of the GL batch types
+func TestGetNextBatchGL(t *testing.T) {
+	bq := testdb.Open(t)
+	cid := testdb.CompanyID(t)
+	testdb.Begin(t, bq)
+
+	tests := []struct {
+		BatchType    constants.BatchTranTypeConstant
+		InterCompany int64
+	}{
+		{constants.BatchTranTypeGlGenJrnls, 0},
+		{constants.BatchTranTypeGlAllocs, 0},
+		{constants.BatchTranTypeGlInterCompJrnls, 1},
+		{constants.BatchTranTypeGlReversal, 0},
+	}
+
+	for _, tt := range tests {
+		res, key, no := GetNextBatch(bq, cid, constants.ModuleGL, int(tt.BatchType), "test", "GL batch test", time.Now(), 0, nil)
+		if res != constants.BatchReturnValid {
+			t.Errorf("batch type %d: GetNextBatch returned %d", tt.BatchType, res)
+			continue
+		}
+
+		if no <= 0 {
+			t.Errorf("batch type %d: batch number %d", tt.BatchType, no)
+		}
+
+		qr := bq.Get(`SELECT InterCompany, BatchCmnt FROM tglBatch WITH (NOLOCK) WHERE BatchKey=?;`, key)
+		if !qr.HasData {
+			t.Errorf("batch type %d: tglBatch record of batch %d not created", tt.BatchType, key)
+			continue
+		}
+		if ic := qr.First().ValueInt64("InterCompany"); ic != tt.InterCompany {
+			t.Errorf("batch type %d: InterCompany is %d, want %d", tt.BatchType, ic, tt.InterCompany)
+		}
+
+		qr = bq.Get(`SELECT BatchType FROM tciBatchLog WITH (NOLOCK) WHERE BatchKey=?;`, key)
+		if !qr.HasData || constants.BatchTranTypeConstant(qr.First().ValueInt64Ord(0)) != tt.BatchType {
+			t.Errorf("batch type %d: tciBatchLog record of batch %d not created", tt.BatchType, key)
+		}
+	}
+}
+
+// TestGetNextBatchGLInvalidType - a batch type of another module is refused before a
+// batch number is used
+func TestGetNextBatchGLInvalidType(t *testing.T) {
+	bq := testdb.Open(t)
+	cid := testdb.CompanyID(t)
+	testdb.Begin(t, bq)
+
+	res, key, _ := GetNextBatch(bq, cid, constants.ModuleGL, int(constants.BatchTranTypeSOProcShip), "test", "GL batch test", time.Now(), 0, nil)
+	if res != constants.BatchReturnError || key != 0 {
+		t.Errorf("GetNextBatch returned %d and batch %d, want %d and no batch", res, key, constants.BatchReturnError)
+	}
+}
@@ -0,0 +1,195 @@
+package bat
+
+import (
+	"gosqljobs/invtcommit/functions/constants"
+
+	du "github.com/eaglebush/datautils"
+)
+
+// BatchDependent - rows of a table still attached to a batch
+type BatchDependent struct {
+	Table string `json:"table"`
+	Rows  int    `json:"rows"`
+}
+
+// BatchDependentTabler - implemented by the BatchCreator of a custom module to name the
+// tables whose rows (by BatchKey) prevent its batches from being deleted
+type BatchDependentTabler interface {
+	DependentTables() []string
+}
+
+// batchDependentTables - tables linked by BatchKey that prevent a batch of the module from being deleted.
+// The GL posting tables are checked for all modules.  Tables that do not exist are skipped.
+var batchDependentTables = map[constants.ModuleConstant][]string{
+	constants.ModuleGL: {"tglPendJrnl"},
+	constants.ModuleAP: {"tapPendVoucher", "tapVoucher", "tapPendVendPmt", "tapVendPmt"},
+	constants.ModuleAR: {"tarPendInvoice", "tarInvoice", "tarPendCustPmt", "tarCustPmt"},
+	constants.ModuleIM: {"timPendInvtTran", "timInvtTran", "timPosting"},
+	constants.ModuleSO: {"tsoPendShipment", "tsoShipment", "tarPendInvoice", "timInvtTran", "timPosting"},
+	constants.ModuleCM: {"tcmPendCashTran", "tcmCashTran"},
+	constants.ModuleMC: {"tmcRevalDetl"},
+	constants.ModulePO: {"tpoPendReceiver", "tpoReceiver", "timInvtTran", "timPosting"},
+	constants.ModuleMF: {"timInvtTran", "timPosting"},
+}
+
+// batchLockCleanupProcs - cleanup procedures (tsmLogicalLockType.LockCleanupProcedure) of the
+// logical locks held for a batch
+var batchLockCleanupProcs = [2]string{"spsoPermanentHiddenBatchRecovery", "spsoDisposableBatchRemover"}
+
+// DeleteBatch - Deletes a batch that has nothing attached to it
+// ---------------------------------------------------------------------
+// The batch is marked as deleted (PostStatus 999) in tciBatchLog, its module batch
+// record (txxBatch) is removed and the logical locks held for it are released.
+// Nothing is changed when rows of the module tables or of the GL posting tables
+// are still attached to the batch.  Posted batches are not deleted.
+//
+// Input Parameters:
+//    @_iBatchKey       Key of the batch
+//    @_iCompanyId      Company ID of the batch
+// Output Parameters:
+//    oRetVal      ReturnValue:
+// 	  0 - Did not make it through the procedure
+// 	  1 - Batch deleted
+// 	  2 - Rows are still attached to the batch.  See oBlocking.
+// 	  3 - Batch not found for the company
+// 	  4 - Batch is posted or being posted past the module posting
+//    oBlocking    Tables with rows still attached to the batch
+// ---------------------------------------------------------------------
+func DeleteBatch(
+	bq *du.BatchQuery,
+	iBatchKey int,
+	iCompanyID string) (Result constants.ResultConstant, Blocking []BatchDependent) {
+
+	res, b := LoadBatch(bq, iBatchKey)
+
+	bq.ScopeName("DeleteBatch")
+
+	if res == constants.BatchReturnError {
+		return constants.ResultError, nil
+	}
+	if res != constants.BatchReturnValid || b.CompanyID != iCompanyID {
+		return constants.ResultConstant(3), nil
+	}
+
+	if b.PostStatus == constants.BatchPostStatusDeleted {
+		return constants.ResultSuccess, nil
+	}
+
+	if b.Status == constants.BatchStatusPosted || b.PostStatus > constants.BatchPostStatusModStarted {
+		return constants.ResultConstant(4), nil
+	}
+
+	lModuleNo := constants.ModuleConstant(0)
+	qr := bq.Get(`SELECT ModuleNo FROM tciBatchType WITH (NOLOCK) WHERE BatchType=?;`, b.BatchType)
+	if !bq.OK() {
+		return constants.ResultError, nil
+	}
+	if qr.HasData {
+		lModuleNo = constants.ModuleConstant(qr.First().ValueInt64Ord(0))
+	}
+
+	tables := append([]string{"tglPosting", "tglTransaction"}, batchDependentTables[lModuleNo]...)
+	if c, ok := GetBatchCreator(lModuleNo); ok {
+		if d, isd := c.(BatchDependentTabler); isd {
+			tables = append(tables, d.DependentTables()...)
+		}
+	}
+
+	// Tables that do not exist are skipped
+	existing := make([]string, 0, len(tables))
+	checked := make(map[string]bool)
+	for _, t := range tables {
+		if checked[t] {
+			continue
+		}
+		checked[t] = true
+
+		qr = bq.Get(`SELECT 1 WHERE OBJECT_ID(?) IS NOT NULL;`, t)
+		if !bq.OK() {
+			return constants.ResultError, nil
+		}
+		if qr.HasData {
+			existing = append(existing, t)
+		}
+	}
+
+	var ok bool
+	if ok, Blocking = batchDependents(bq, existing, iBatchKey, "NOLOCK"); !ok {
+		return constants.ResultError, nil
+	}
+	if len(Blocking) > 0 {
+		return constants.ResultFail, Blocking
+	}
+
+	rollback := func() {
+		bq.Waive()
+		bq.Set(`IF @@TRANCOUNT > 0 ROLLBACK;`)
+	}
+
+	bq.Set(`BEGIN TRAN;`)
+	if !bq.OK() {
+		return constants.ResultError, nil
+	}
+
+	// Rows may have been attached since the first count.  Count again and keep the
+	// ranges locked so that no row can be attached until the batch is deleted.
+	if ok, Blocking = batchDependents(bq, existing, iBatchKey, "UPDLOCK, HOLDLOCK"); !ok {
+		rollback()
+		return constants.ResultError, nil
+	}
+	if len(Blocking) > 0 {
+		rollback()
+		return constants.ResultFail, Blocking
+	}
+
+	if b.Delete(bq) != constants.BatchReturnValid {
+		rollback()
+		return constants.ResultError, nil
+	}
+
+	if tbl := ModuleBatchTable(lModuleNo); tbl != "" {
+		bq.Set(`DELETE `+tbl+` WHERE BatchKey=?;`, iBatchKey)
+	}
+
+	// Release the logical locks held for the batch (cleanup parameters: BatchKey, -, CompanyID).
+	// Only the lock types of the batch recovery procedures hold a BatchKey in LockCleanupParam1.
+	bq.Set(`DELETE ll
+			FROM tsmLogicalLock ll
+				INNER JOIN tsmLogicalLockType lt WITH (NOLOCK) ON ll.LogicalLockType=lt.LogicalLockType
+			WHERE ll.LockCleanupParam1=? AND COALESCE(ll.LockCleanupParam3, ?)=?
+				AND lt.LockCleanupProcedure IN (?,?);`,
+		iBatchKey, iCompanyID, iCompanyID, batchLockCleanupProcs[0], batchLockCleanupProcs[1])
+
+	if !bq.OK() {
+		rollback()
+		return constants.ResultError, nil
+	}
+
+	bq.Set(`COMMIT;`)
+	if !bq.OK() {
+		return constants.ResultError, nil
+	}
+
+	return constants.ResultSuccess, Blocking
+}
+
+// batchDependents - counts the rows of the tables attached to a batch, read with the table hints
+func batchDependents(
+	bq *du.BatchQuery,
+	iTables []string,
+	iBatchKey int,
+	iHints string) (bool, []BatchDependent) {
+
+	deps := make([]BatchDependent, 0)
+	for _, t := range iTables {
+		qr := bq.Get(`SELECT COUNT(*) FROM `+t+` WITH (`+iHints+`) WHERE BatchKey=?;`, iBatchKey)
+		if !bq.OK() {
+			return false, nil
+		}
+		if n := int(qr.First().ValueInt64Ord(0)); n > 0 {
+			deps = append(deps, BatchDependent{Table: t, Rows: n})
+		}
+	}
+
+	return true, deps
+}
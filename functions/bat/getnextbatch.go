@@ -25,8 +25,10 @@ import (
 // 2 - No numbers could be found to use  (0000001-9999999)
 // 3 - No tciBatchTypCompany Record for the batch/Co specified
 // 4 - Unable to create tciBatchLog record
-// 5 - Unable to create tmcBatch record
-// 6 - tmcBatch record already exists for that key
+// 5 - Unable to create tmcBatch record (or the module batch record)
+// 6 - tmcBatch record already exists for that key (or the module batch record)
+// 7 - An unfinished MC batch exists.  oBatchKey and oNextBatch are of that batch.
+//     The batch is resumed with so.ResumeBatchPosting.
 func GetNextBatch(
 	bq *du.BatchQuery,
 	iCompanyID string,
@@ -38,6 +40,26 @@ func GetNextBatch(
 	optHiddenBatch int,
 	optInvcDate *time.Time) (Result constants.BatchReturnConstant, BatchKey int, NextBatchNo int) {
 
+	return GetNextBatchOpt(bq, iModuleNo, BatchOptions{
+		CompanyID: iCompanyID,
+		UserID:    iUserID,
+		BatchCmnt: iDefBatchCmnt,
+		PostDate:  iPostDate,
+		BatchType: iBatchType,
+		InvcDate:  optInvcDate,
+	}, optHiddenBatch)
+}
+
+// GetNextBatchOpt - Create the next batch Number with the batch options.
+// The module batch record is created by the BatchCreator registered for the module.
+// When no BatchCreator is registered, only the tciBatchLog record is created.
+// The batch comment is formatted with FormatBatchCmnt.
+func GetNextBatchOpt(
+	bq *du.BatchQuery,
+	iModuleNo constants.ModuleConstant,
+	iOpt BatchOptions,
+	optHiddenBatch int) (Result constants.BatchReturnConstant, BatchKey int, NextBatchNo int) {
+
 	bq.ScopeName("GetNextBatch")
 
 	nextBatchNo := 0
@@ -50,7 +72,7 @@ func GetNextBatch(
 					  WHERE  batchtype=?
 							AND sourcecompanyid=?
 							AND status <> 6
-							AND poststatus <> 999;`, iBatchType, iCompanyID)
+							AND poststatus <> 999;`, iOpt.BatchType, iOpt.CompanyID)
 		if qr.HasData {
 			nextBatchNo = int(qr.Get(0).ValueInt64Ord(0))
 			batchKey = int(qr.Get(0).ValueInt64Ord(1))
@@ -58,34 +80,36 @@ func GetNextBatch(
 		}
 	}
 
+	creator, ok := GetBatchCreator(iModuleNo)
+	if ok {
+		if v, isv := creator.(BatchTypeValidator); isv && !v.ValidBatchType(iOpt.BatchType) {
+			return constants.BatchReturnError, 0, 0
+		}
+	}
+
 	// GetNextBatch will get a good batch Number,
 	// create the tciBatchLog Record, increment the nextno
 	// and write it back to tciBatchTypCompany.
 	// (or it will return something other than 1).
-	res, batchKey, nextBatchNo = GetNextBatchNo(bq, iCompanyID, iBatchType, iUserID, iModuleNo, optHiddenBatch)
+	res, batchKey, nextBatchNo = GetNextBatchNo(bq, iOpt.CompanyID, iOpt.BatchType, iOpt.UserID, iModuleNo, optHiddenBatch)
 	if res != constants.BatchReturnValid {
 		return res, 0, 0
 	}
 
-	switch iModuleNo {
-	case constants.ModuleAP: // AP
-		res = CreateApBatch(bq, iCompanyID, iUserID, iDefBatchCmnt, iPostDate, batchKey)
-	case constants.ModuleAR: // AR
-		res = CreateArBatch(bq, iCompanyID, iUserID, iDefBatchCmnt, iPostDate, batchKey)
-	case constants.ModuleIM: // IM
-		res = CreateImBatch(bq, iCompanyID, iUserID, iDefBatchCmnt, iPostDate, batchKey)
-	case constants.ModuleSO: // SO
-		res = CreateSoBatch(bq, iCompanyID, iUserID, iDefBatchCmnt, iPostDate, batchKey, optInvcDate)
-	case constants.ModuleCM: // CM
-		res = CreateCmBatch(bq, iCompanyID, iUserID, iDefBatchCmnt, iPostDate, batchKey)
-	case constants.ModuleMC: // MC
-		res = CreateMcBatch(bq, iCompanyID, iUserID, iDefBatchCmnt, iBatchType, batchKey)
-	case constants.ModulePO: // PO
-		res = CreatePoBatch(bq, iCompanyID, iUserID, iDefBatchCmnt, iPostDate, batchKey)
-	case constants.ModuleMF: // MF
-		res = CreateMfBatch(bq, iCompanyID, iUserID, iDefBatchCmnt, iPostDate, batchKey)
+	// The comment is formatted with the batch comment template of the module
+	iOpt.BatchCmnt = FormatBatchCmnt(iModuleNo, iOpt)
+
+	if !ok {
+		bq.Set(`UPDATE tciBatchLog SET BatchCmnt=? WHERE BatchKey=?;`, iOpt.BatchCmnt, batchKey)
+		if !bq.OK() {
+			return constants.BatchReturnError, batchKey, nextBatchNo
+		}
+		return res, batchKey, nextBatchNo
 	}
 
+	iOpt.BatchKey = batchKey
+	res = creator.CreateBatch(bq, iOpt)
+
 	ures := UpdateBatchLogCmnt(bq, batchKey, iModuleNo)
 	if ures != constants.BatchReturnValid {
 		return ures, batchKey, nextBatchNo
@@ -1,6 +1,7 @@
 package bat
 
 import (
+	"fmt"
 	"gosqljobs/invtcommit/functions/constants"
 
 	du "github.com/eaglebush/datautils"
@@ -15,15 +16,20 @@ import (
 //     @_iModuleNo         Module No
 //     @_optHiddenBatch    Optional parameter. Tells if batch being created is hidden,
 //                         which results in a BatchNo = 0.
+// The numbers are assigned with the NumberingPolicy of the company and batch type.
+// The allocation is serialized per company and batch type with an application lock
+// (sp_getapplock) and the next number is reserved with a single UPDATE so that
+// concurrent jobs do not get the same batch number.
 // Output Parameters:
 //     @_oBatchKey         Batch Key
 //     @_oNextBatch        Batch number
 //     @_oRetVal           ReturnValue:
 //                             0 - Did not make it through the procedure
 //                             1 - Valid number found
-//                             2 - No numbers could be found to use  (00001-99999)
+//                             2 - No numbers could be found to use  (1-MaxBatchNo of the numbering policy)
 //                             3 - No tciBatchTypCompany Record for the Batch/Co specified
 //                             4 - Unable to create tciBatchLog record
+//                            10 - The allocation lock could not be taken
 // ---------------------------------------------------------------------
 func GetNextBatchNo(
 	bq *du.BatchQuery,
@@ -37,70 +43,168 @@ func GetNextBatchNo(
 
 	var qr du.QueryResult
 
-	lNextBatchNo := 0
 	lBatchTypeID := ""
 	lBatchKey := 0
+	valid := constants.BatchReturnError
 
-	if optHiddenBatch == 0 {
-		/* Override batch number for hidden batches.  Set them to zero. */
-		qr = bq.Get(`SELECT nextbatchno, batchtypeid
-					  FROM tciBatchTypCompany WITH (NOLOCK)
-					  WHERE companyid=? AND batchtype=?;`, iCompanyID, iBatchType)
-		if !qr.HasData {
-			return constants.BatchReturnNoRecord, 0, 0
+	/* Override batch number for hidden batches.  Set them to zero. */
+	if optHiddenBatch != 0 {
+		valid, lBatchKey = CreateBatchLog(bq, iCompanyID, iModuleNo, iBatchType, lBatchTypeID, 0, iUserID, 0)
+		if valid != constants.BatchReturnValid {
+			return constants.BatchReturnError, 0, 0
 		}
-		lNextBatchNo = int(qr.Get(0).ValueInt64Ord(0))
-		lBatchTypeID = qr.Get(0).ValueStringOrd(1)
+		return constants.BatchReturnValid, lBatchKey, 0
+	}
 
-		/* Lock the table for updates. Notice the UPDLOCK table hint */
-		bq.Get(`SELECT nextbatchno
-				FROM tciBatchTypCompany WITH (UPDLOCK)
-				WHERE companyid=? AND batchtype=?;`, iCompanyID, iBatchType)
+	qr = bq.Get(`SELECT batchtypeid
+				  FROM tciBatchTypCompany WITH (NOLOCK)
+				  WHERE companyid=? AND batchtype=?;`, iCompanyID, iBatchType)
+	if !qr.HasData {
+		return constants.BatchReturnNoRecord, 0, 0
 	}
+	lBatchTypeID = qr.Get(0).ValueStringOrd(0)
 
-	if lNextBatchNo > 999999 {
-		lNextBatchNo = 1
+	// Only one session at a time allocates the numbers of a company and batch type
+	lLockName := fmt.Sprintf("tciBatchTypCompany|%s|%d", iCompanyID, iBatchType)
+	if !getBatchNoLock(bq, lLockName) {
+		return constants.BatchReturnLocked, 0, 0
+	}
+	defer releaseBatchNoLock(bq, lLockName)
+
+	policy := GetNumberingPolicy(bq, iCompanyID, iBatchType)
+
+	for lTry := 0; lTry < batchNoMaxRetries; lTry++ {
+		res, lBatchNo := reserveBatchNo(bq, iCompanyID, iBatchType, policy)
+		if res != constants.BatchReturnValid {
+			return res, 0, 0
+		}
+
+		valid, lBatchKey = CreateBatchLog(bq, iCompanyID, iModuleNo, iBatchType, lBatchTypeID, lBatchNo, iUserID, 0)
+		if valid != constants.BatchReturnValid {
+			return constants.BatchReturnError, 0, 0
+		}
+
+		// A process that does not take the lock may have used the same number. Use another one.
+		qr = bq.Get(`SELECT COUNT(*) FROM tcibatchlog WITH (NOLOCK) WHERE sourcecompanyid=? AND batchno=? AND batchtype=?;`, iCompanyID, lBatchNo, iBatchType)
+		if !bq.OK() {
+			return constants.BatchReturnError, 0, 0
+		}
+		if qr.First().ValueInt64Ord(0) <= 1 {
+			return constants.BatchReturnValid, lBatchKey, lBatchNo
+		}
+
+		bq.Set(`DELETE tciBatchLog WHERE BatchKey=?;`, lBatchKey)
+		if !bq.OK() {
+			return constants.BatchReturnError, 0, 0
+		}
+	}
+
+	return constants.BatchReturnNoNum, 0, 0
+}
+
+// Batch number allocation limits
+const (
+	batchNoMaxRetries    = 3    // Attempts to get the allocation lock or an unused number
+	batchNoMaxSkips      = 10   // Used numbers skipped by reserveBatchNo before it gives up
+	batchNoLockTimeoutMs = 5000 // Wait for the allocation lock on each attempt
+)
+
+// getBatchNoLock - takes the application lock that serializes the batch number allocation
+func getBatchNoLock(bq *du.BatchQuery, iLockName string) bool {
+	for lTry := 0; lTry < batchNoMaxRetries; lTry++ {
+		qr := bq.Get(`DECLARE @r INT;
+					  EXEC @r = sp_getapplock @Resource=?, @LockMode='Exclusive', @LockOwner='Session', @LockTimeout=?;
+					  SELECT @r;`, iLockName, batchNoLockTimeoutMs)
+		if !bq.OK() {
+			return false
+		}
+
+		// 0 = granted, 1 = granted after waiting, negative = timeout, deadlock or error
+		if qr.HasData && qr.First().ValueInt64Ord(0) >= 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// releaseBatchNoLock - releases the application lock taken by getBatchNoLock
+func releaseBatchNoLock(bq *du.BatchQuery, iLockName string) {
+	bq.Set(`EXEC sp_releaseapplock @Resource=?, @LockOwner='Session';`, iLockName)
+}
+
+// reserveBatchNo - returns an unused batch number.  The next number of tciBatchTypCompany is
+// reserved by advancing it with NumberingPolicy.Next, only if it is unchanged since it was
+// read.  Numbers already used by tciBatchLog are skipped, up to batchNoMaxSkips of them.  With gap reuse, the first unused number below the next
+// number is returned and the next number is kept.
+func reserveBatchNo(
+	bq *du.BatchQuery,
+	iCompanyID string,
+	iBatchType int,
+	iPolicy NumberingPolicy) (Result constants.BatchReturnConstant, BatchNo int) {
+
+	/* Use the first unused number below the next number */
+	if iPolicy.ReuseGaps {
+		qr := bq.Get(`SELECT TOP 1 g.n
+					  FROM (SELECT 1 AS n
+							UNION ALL
+							SELECT batchno + 1 FROM tcibatchlog WITH (NOLOCK)
+							WHERE sourcecompanyid=? AND batchtype=? AND batchno > 0) g
+					  WHERE g.n < (SELECT nextbatchno FROM tciBatchTypCompany WITH (NOLOCK) WHERE companyid=? AND batchtype=?)
+						AND g.n <= ?
+						AND NOT EXISTS (SELECT 1 FROM tcibatchlog b WITH (NOLOCK)
+										WHERE b.sourcecompanyid=? AND b.batchtype=? AND b.batchno=g.n)
+					  ORDER BY g.n;`, iCompanyID, iBatchType, iCompanyID, iBatchType, iPolicy.MaxBatchNo, iCompanyID, iBatchType)
+		if !bq.OK() {
+			return constants.BatchReturnError, 0
+		}
+		if qr.HasData {
+			return constants.BatchReturnValid, int(qr.First().ValueInt64Ord(0))
+		}
 	}
 
 	/* Initialize loop starting number */
-	lStartingNumber := lNextBatchNo
-	lNoRecFound := false
+	lStartingNumber := 0
 
-	for {
-		valid := constants.BatchReturnError
-		// Check if batch number exists
-		qr = bq.Get(`SELECT TOP 1 batchno FROM tcibatchlog WITH (nolock) WHERE sourcecompanyid=? AND batchno=? AND batchtype=?;`, iCompanyID, lNextBatchNo, iBatchType)
-		if !qr.HasData || optHiddenBatch != 0 {
-			// If not found, create
-			valid, lBatchKey = CreateBatchLog(bq, iCompanyID, iModuleNo, iBatchType, lBatchTypeID, lNextBatchNo, iUserID, 0)
-			if valid != constants.BatchReturnValid {
-				return constants.BatchReturnError, 0, 0
-			}
-			lNoRecFound = true
+	for i := 0; i < batchNoMaxSkips; i++ {
+		qr := bq.Get(`SELECT nextbatchno FROM tciBatchTypCompany WITH (NOLOCK) WHERE companyid=? AND batchtype=?;`, iCompanyID, iBatchType)
+		if !bq.OK() || !qr.HasData {
+			return constants.BatchReturnError, 0
 		}
+		lBatchNo := int(qr.First().ValueInt64Ord(0))
 
-		if optHiddenBatch == 0 {
-			if lNextBatchNo > 999999 {
-				lNextBatchNo = 1
-			} else {
-				lNextBatchNo++
-			}
+		// Zero is left by Next when the numbers ran out
+		lOutOfRange := lBatchNo < 1 || lBatchNo > iPolicy.MaxBatchNo
+		if lOutOfRange && !iPolicy.Rollover {
+			return constants.BatchReturnNoNum, 0
+		}
 
-			if lNoRecFound {
-				qr = bq.Set(`UPDATE tcibatchtypcompany SET nextbatchno=?
-							 WHERE  companyid=?	AND batchtype=?;`, lNextBatchNo, iCompanyID, iBatchType)
-				if qr.HasData {
-					return constants.BatchReturnValid, lBatchKey, lNextBatchNo
-				}
-			}
+		// The number is reserved only when nobody advanced the next number in between
+		qr = bq.Set(`UPDATE tciBatchTypCompany
+					  SET nextbatchno=?
+					  WHERE companyid=? AND batchtype=? AND nextbatchno=?;`, iPolicy.Next(lBatchNo), iCompanyID, iBatchType, lBatchNo)
+		if !bq.OK() {
+			return constants.BatchReturnError, 0
+		}
+		if !qr.HasAffectedRows || lOutOfRange {
+			continue
 		}
 
-		if optHiddenBatch != 0 {
-			return constants.BatchReturnValid, lBatchKey, lNextBatchNo
+		if lStartingNumber == 0 {
+			lStartingNumber = lBatchNo
+		} else if lBatchNo == lStartingNumber {
+			return constants.BatchReturnNoNum, 0
 		}
 
-		if lStartingNumber == lStartingNumber {
-			return constants.BatchReturnNoNum, lBatchKey, lNextBatchNo
+		// Check if batch number exists
+		qr = bq.Get(`SELECT TOP 1 batchno FROM tcibatchlog WITH (nolock) WHERE sourcecompanyid=? AND batchno=? AND batchtype=?;`, iCompanyID, lBatchNo, iBatchType)
+		if !bq.OK() {
+			return constants.BatchReturnError, 0
+		}
+		if !qr.HasData {
+			return constants.BatchReturnValid, lBatchNo
 		}
 	}
+
+	return constants.BatchReturnNoNum, 0
 }
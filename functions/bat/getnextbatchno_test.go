@@ -0,0 +1,61 @@
+package bat
+
+import (
+	"gosqljobs/invtcommit/functions/constants"
+	"gosqljobs/invtcommit/functions/testdb"
+	"sync"
+	"testing"
+	"time"
+
+	du "github.com/eaglebush/datautils"
+)
+
+// TestGetNextBatchParallel - batches requested at the same time from separate
+// sessions get distinct batch numbers
+func TestGetNextBatchParallel(t *testing.T) {
+	const workers = 8
+
+	cid := testdb.CompanyID(t)
+
+	bqs := make([]*du.BatchQuery, workers)
+	for i := range bqs {
+		bqs[i] = testdb.Open(t)
+	}
+
+	type batch struct {
+		Result  constants.BatchReturnConstant
+		Key, No int
+	}
+	batches := make([]batch, workers)
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := range bqs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			res, key, no := GetNextBatch(bqs[i], cid, constants.ModuleGL, int(constants.BatchTranTypeGlGenJrnls),
+				"test", "GetNextBatch parallel test", time.Now(), 0, nil)
+			batches[i] = batch{res, key, no}
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	seen := make(map[int]int)
+	for i, b := range batches {
+		if b.Result != constants.BatchReturnValid {
+			t.Errorf("worker %d: GetNextBatch returned %d", i, b.Result)
+			continue
+		}
+
+		key := b.Key
+		t.Cleanup(func() { DeleteBatch(bqs[0], key, cid) })
+
+		if j, dup := seen[b.No]; dup {
+			t.Errorf("workers %d and %d got batch number %d", j, i, b.No)
+		}
+		seen[b.No] = i
+	}
+}
@@ -0,0 +1,212 @@
+package bat
+
+import (
+	"gosqljobs/invtcommit/functions/constants"
+	"strings"
+	"time"
+
+	du "github.com/eaglebush/datautils"
+)
+
+// BatchFilter - filters of ListBatches.  Zero values do not filter.
+type BatchFilter struct {
+	BatchKey   int
+	CompanyID  string
+	ModuleNo   constants.ModuleConstant
+	BatchType  int
+	Status     constants.BatchStatusConstant
+	PostStatus *constants.BatchPostStatusConstant
+	Hidden     *bool // true = hidden batches only (BatchNo = 0), false = numbered batches only
+	UserID     string
+	FromDate   time.Time // CreateDate from
+	ToDate     time.Time // CreateDate to, inclusive
+}
+
+// BatchInfo - a tciBatchLog record
+type BatchInfo struct {
+	BatchKey   int                               `json:"batchkey"`
+	BatchID    string                            `json:"batchid"`
+	BatchNo    int                               `json:"batchno"`
+	BatchType  int                               `json:"batchtype"`
+	ModuleNo   constants.ModuleConstant          `json:"moduleno"`
+	CompanyID  string                            `json:"companyid"`
+	Status     constants.BatchStatusConstant     `json:"status"`
+	PostStatus constants.BatchPostStatusConstant `json:"poststatus"`
+	UserID     string                            `json:"userid"`
+	BatchCmnt  string                            `json:"batchcmnt"`
+	PostDate   time.Time                         `json:"postdate"`
+	CreateDate time.Time                         `json:"createdate"`
+}
+
+// ModuleBatchInfo - the module batch record (txxBatch) of a batch
+type ModuleBatchInfo struct {
+	Table      string    `json:"table"`
+	BatchCmnt  string    `json:"batchcmnt"`
+	Hold       bool      `json:"hold"`
+	OrigUserID string    `json:"origuserid"`
+	PostDate   time.Time `json:"postdate"`
+}
+
+// BatchDetail - a batch with its module batch record and the number of linked rows
+type BatchDetail struct {
+	BatchInfo
+	ModuleBatch    *ModuleBatchInfo `json:"modulebatch"`
+	PendShipments  int              `json:"pendshipments"`
+	InvtTrans      int              `json:"invttrans"`
+	GLPostings     int              `json:"glpostings"`
+	GLTransactions int              `json:"gltransactions"`
+}
+
+// ListBatches - Returns the batches of tciBatchLog that match a filter
+// ---------------------------------------------------------------------
+// Input Parameters:
+//    @_iFilter         Filters.  Zero values do not filter.
+// Output Parameters:
+//    oRetVal      ReturnValue:
+// 	  0 - Did not make it through the procedure
+// 	  1 - Successful
+//    oBatches     Batches ordered by BatchKey
+// ---------------------------------------------------------------------
+func ListBatches(bq *du.BatchQuery, iFilter BatchFilter) (Result constants.ResultConstant, Batches []BatchInfo) {
+	bq.ScopeName("ListBatches")
+
+	where := make([]string, 0)
+	args := make([]interface{}, 0)
+	add := func(cond string, arg interface{}) {
+		where = append(where, cond)
+		args = append(args, arg)
+	}
+
+	if iFilter.BatchKey != 0 {
+		add("bl.BatchKey=?", iFilter.BatchKey)
+	}
+	if iFilter.CompanyID != "" {
+		add("bl.SourceCompanyID=?", iFilter.CompanyID)
+	}
+	if iFilter.ModuleNo != 0 {
+		add("bt.ModuleNo=?", iFilter.ModuleNo)
+	}
+	if iFilter.BatchType != 0 {
+		add("bl.BatchType=?", iFilter.BatchType)
+	}
+	if iFilter.Status != constants.BatchStatusUndefined {
+		add("bl.Status=?", iFilter.Status)
+	}
+	if iFilter.PostStatus != nil {
+		add("bl.PostStatus=?", *iFilter.PostStatus)
+	}
+	if iFilter.Hidden != nil {
+		if *iFilter.Hidden {
+			where = append(where, "bl.BatchNo=0")
+		} else {
+			where = append(where, "bl.BatchNo<>0")
+		}
+	}
+	if iFilter.UserID != "" {
+		add("bl.OrigUserID=?", iFilter.UserID)
+	}
+	if !iFilter.FromDate.IsZero() {
+		add("bl.CreateDate>=?", iFilter.FromDate)
+	}
+	if !iFilter.ToDate.IsZero() {
+		add("bl.CreateDate<?", iFilter.ToDate.AddDate(0, 0, 1))
+	}
+
+	sql := `SELECT bl.BatchKey, bl.BatchID, bl.BatchNo, bl.BatchType, COALESCE(bt.ModuleNo, 0) AS ModuleNo,
+				bl.SourceCompanyID, bl.Status, bl.PostStatus, COALESCE(bl.OrigUserID, '') AS OrigUserID,
+				COALESCE(bl.BatchCmnt, '') AS BatchCmnt, bl.PostDate, bl.CreateDate
+			FROM tciBatchLog bl WITH (NOLOCK)
+				LEFT JOIN tciBatchType bt WITH (NOLOCK) ON bl.BatchType = bt.BatchType`
+	if len(where) > 0 {
+		sql += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+
+	qr := bq.Get(sql+` ORDER BY bl.BatchKey;`, args...)
+	if !bq.OK() {
+		return constants.ResultError, nil
+	}
+
+	Batches = make([]BatchInfo, 0, len(qr.Data))
+	for _, v := range qr.Data {
+		Batches = append(Batches, BatchInfo{
+			BatchKey:   int(v.ValueInt64("BatchKey")),
+			BatchID:    v.ValueString("BatchID"),
+			BatchNo:    int(v.ValueInt64("BatchNo")),
+			BatchType:  int(v.ValueInt64("BatchType")),
+			ModuleNo:   constants.ModuleConstant(v.ValueInt64("ModuleNo")),
+			CompanyID:  v.ValueString("SourceCompanyID"),
+			Status:     constants.BatchStatusConstant(v.ValueInt64("Status")),
+			PostStatus: constants.BatchPostStatusConstant(v.ValueInt64("PostStatus")),
+			UserID:     v.ValueString("OrigUserID"),
+			BatchCmnt:  v.ValueString("BatchCmnt"),
+			PostDate:   v.ValueTime("PostDate"),
+			CreateDate: v.ValueTime("CreateDate"),
+		})
+	}
+
+	return constants.ResultSuccess, Batches
+}
+
+// ShowBatch - Returns a batch with its module batch record and the number of rows linked to it
+// ---------------------------------------------------------------------
+// Input Parameters:
+//    @_iBatchKey       Key of the batch
+// Output Parameters:
+//    oRetVal      ReturnValue:
+// 	  0 - Did not make it through the procedure
+// 	  1 - Successful
+// 	  2 - Batch not found
+//    oDetail      The batch
+// ---------------------------------------------------------------------
+func ShowBatch(bq *du.BatchQuery, iBatchKey int) (Result constants.ResultConstant, Detail *BatchDetail) {
+	bq.ScopeName("ShowBatch")
+
+	res, batches := ListBatches(bq, BatchFilter{BatchKey: iBatchKey})
+	if res != constants.ResultSuccess {
+		return res, nil
+	}
+	if len(batches) == 0 {
+		return constants.ResultFail, nil
+	}
+
+	bq.ScopeName("ShowBatch")
+
+	Detail = &BatchDetail{BatchInfo: batches[0]}
+
+	if tbl := ModuleBatchTable(Detail.ModuleNo); tbl != "" {
+		qr := bq.Get(`SELECT COALESCE(BatchCmnt, '') AS BatchCmnt, Hold, COALESCE(OrigUserID, '') AS OrigUserID, PostDate
+					 FROM `+tbl+` WITH (NOLOCK)
+					 WHERE BatchKey=?;`, iBatchKey)
+		if !bq.OK() {
+			return constants.ResultError, nil
+		}
+		if qr.HasData {
+			r := qr.First()
+			Detail.ModuleBatch = &ModuleBatchInfo{
+				Table:      tbl,
+				BatchCmnt:  r.ValueString("BatchCmnt"),
+				Hold:       r.ValueInt64("Hold") == 1,
+				OrigUserID: r.ValueString("OrigUserID"),
+				PostDate:   r.ValueTime("PostDate"),
+			}
+		}
+	}
+
+	qr := bq.Get(`SELECT (SELECT COUNT(*) FROM tsoPendShipment WITH (NOLOCK) WHERE BatchKey=?),
+						(SELECT COUNT(*) FROM timInvtTran WITH (NOLOCK) WHERE BatchKey=?),
+						(SELECT COUNT(*) FROM tglPosting WITH (NOLOCK) WHERE BatchKey=?),
+						(SELECT COUNT(*) FROM tglTransaction WITH (NOLOCK) WHERE BatchKey=?);`,
+		iBatchKey, iBatchKey, iBatchKey, iBatchKey)
+	if !bq.OK() {
+		return constants.ResultError, nil
+	}
+	if qr.HasData {
+		r := qr.First()
+		Detail.PendShipments = int(r.ValueInt64Ord(0))
+		Detail.InvtTrans = int(r.ValueInt64Ord(1))
+		Detail.GLPostings = int(r.ValueInt64Ord(2))
+		Detail.GLTransactions = int(r.ValueInt64Ord(3))
+	}
+
+	return constants.ResultSuccess, Detail
+}
@@ -0,0 +1,93 @@
+package bat
+
+import (
+	"fmt"
+	"strconv"
+
+	du "github.com/eaglebush/datautils"
+)
+
+// NumberingPolicy - how the batch numbers of a company and batch type are assigned and formatted
+//
+// Policies are read from the optional table tciBatchNumPolicy_HAI:
+//
+//    CREATE TABLE tciBatchNumPolicy_HAI (
+//       CompanyID   VARCHAR(3) NOT NULL,
+//       BatchType   INTEGER NOT NULL,      -- 0 = all the batch types of the company
+//       PadWidth    SMALLINT NOT NULL,     -- Zero-padding width of the batch number in the BatchID
+//       Prefix      VARCHAR(10) NULL,      -- Replaces ModuleID + BatchType in the BatchID
+//       MaxBatchNo  INTEGER NOT NULL,      -- Highest batch number
+//       Rollover    SMALLINT NOT NULL,     -- 1 = continue from 1 after MaxBatchNo
+//       ReuseGaps   SMALLINT NOT NULL)     -- 1 = use unused numbers below NextBatchNo first
+//
+// The row of the batch type overrides the row of the company.  Without any row
+// DefaultNumberingPolicy is used.
+type NumberingPolicy struct {
+	PadWidth   int
+	Prefix     string
+	MaxBatchNo int
+	Rollover   bool
+	ReuseGaps  bool
+}
+
+// DefaultNumberingPolicy - batch numbering when no policy is defined
+var DefaultNumberingPolicy = NumberingPolicy{
+	PadWidth:   0,
+	Prefix:     "",
+	MaxBatchNo: 999999,
+	Rollover:   true,
+	ReuseGaps:  false,
+}
+
+// GetNumberingPolicy - returns the numbering policy of a company and batch type
+func GetNumberingPolicy(bq *du.BatchQuery, iCompanyID string, iBatchType int) NumberingPolicy {
+	p := DefaultNumberingPolicy
+
+	qr := bq.Get(`IF OBJECT_ID('tciBatchNumPolicy_HAI') IS NOT NULL
+					SELECT TOP 1 PadWidth, COALESCE(Prefix, '') AS Prefix, MaxBatchNo, Rollover, ReuseGaps
+					FROM tciBatchNumPolicy_HAI WITH (NOLOCK)
+					WHERE CompanyID=? AND BatchType IN (0, ?)
+					ORDER BY BatchType DESC;`, iCompanyID, iBatchType)
+	if !bq.OK() {
+		bq.Waive()
+		return p
+	}
+
+	if qr.HasData {
+		r := qr.First()
+		p.PadWidth = int(r.ValueInt64("PadWidth"))
+		p.Prefix = r.ValueString("Prefix")
+		p.Rollover = r.ValueInt64("Rollover") == 1
+		p.ReuseGaps = r.ValueInt64("ReuseGaps") == 1
+		if m := int(r.ValueInt64("MaxBatchNo")); m > 0 {
+			p.MaxBatchNo = m
+		}
+	}
+
+	return p
+}
+
+// FormatBatchID - formats the BatchID of a batch number.
+// The BatchID is the prefix (ModuleID + BatchType by default), a dash and the batch
+// number padded with zeroes to PadWidth.
+func (p NumberingPolicy) FormatBatchID(iModuleID string, iBatchType int, iBatchNo int) string {
+	prefix := p.Prefix
+	if prefix == "" {
+		prefix = iModuleID + strconv.Itoa(iBatchType)
+	}
+
+	return prefix + `-` + fmt.Sprintf("%0*d", p.PadWidth, iBatchNo)
+}
+
+// Next - returns the number following a batch number.  Zero is returned when the
+// numbers ran out and the policy does not roll over.
+func (p NumberingPolicy) Next(iBatchNo int) int {
+	if iBatchNo >= p.MaxBatchNo {
+		if !p.Rollover {
+			return 0
+		}
+		return 1
+	}
+
+	return iBatchNo + 1
+}
@@ -0,0 +1,28 @@
+package bat
+
+import "testing"
+
+// TestNumberingPolicyNext - the number following a batch number rolls over only when the
+// policy allows it
+func TestNumberingPolicyNext(t *testing.T) {
+	tests := []struct {
+		policy NumberingPolicy
+		no     int
+		want   int
+	}{
+		{NumberingPolicy{MaxBatchNo: 999, Rollover: true}, 1, 2},
+		{NumberingPolicy{MaxBatchNo: 999, Rollover: true}, 998, 999},
+		{NumberingPolicy{MaxBatchNo: 999, Rollover: true}, 999, 1},
+		{NumberingPolicy{MaxBatchNo: 999, Rollover: true}, 1005, 1},
+		{NumberingPolicy{MaxBatchNo: 999, Rollover: true}, 0, 1},
+		{NumberingPolicy{MaxBatchNo: 999, Rollover: false}, 998, 999},
+		{NumberingPolicy{MaxBatchNo: 999, Rollover: false}, 999, 0},
+	}
+
+	for _, tt := range tests {
+		if got := tt.policy.Next(tt.no); got != tt.want {
+			t.Errorf("Next(%d) with MaxBatchNo %d, Rollover %v = %d, want %d",
+				tt.no, tt.policy.MaxBatchNo, tt.policy.Rollover, got, tt.want)
+		}
+	}
+}
@@ -41,30 +41,7 @@ func UpdateBatchLogCmnt(bq *du.BatchQuery, iBatchKey int, iModuleNo constants.Mo
 		}
 	}
 
-	tbl := ""
-	switch lModuleNo {
-	case 3: // GL
-		tbl = "tglBatch"
-	case 4: // AP
-		tbl = "tapBatch"
-	case 5: // AR
-		tbl = "tarBatch"
-	case 7: // IM
-		tbl = "timBatch"
-	case 8: // SO
-		tbl = "tsoBatch"
-	case 9: // CM
-		tbl = "tcmBatch"
-	case 10: // MC
-		tbl = "tmcBatch"
-	case 11: // PO
-		tbl = "tpoBatch"
-	case 12: // MF
-		tbl = "tmfBatch_HAI"
-	case 19: // PA
-		tbl = "tpaBatch"
-	}
-
+	tbl := ModuleBatchTable(lModuleNo)
 	if tbl == "" {
 		return constants.BatchReturnFailed
 	}
@@ -73,8 +50,8 @@ func UpdateBatchLogCmnt(bq *du.BatchQuery, iBatchKey int, iModuleNo constants.Mo
 				SET	a.BatchCmnt = b.BatchCmnt,
 					a.PostDate = b.PostDate
 				FROM tciBatchLog a
-					JOIN ` + tbl + ` b WITH (NOLOCK) ON a.BatchKey=b.BatchKey
-				WHERE b.BatchKey=?;`)
+					JOIN `+tbl+` b WITH (NOLOCK) ON a.BatchKey=b.BatchKey
+				WHERE b.BatchKey=?;`, iBatchKey)
 	if qr.HasData {
 		if qr.Get(0).ValueInt64("Affected") == 0 {
 			return constants.BatchReturnFailed
@@ -83,3 +60,38 @@ func UpdateBatchLogCmnt(bq *du.BatchQuery, iBatchKey int, iModuleNo constants.Mo
 
 	return constants.BatchReturnValid
 }
+
+// ModuleBatchTable - returns the batch table (txxBatch) of a module.
+// Registered modules name their own batch table with BatchTableNamer.
+func ModuleBatchTable(iModuleNo constants.ModuleConstant) string {
+	switch iModuleNo {
+	case 3: // GL
+		return "tglBatch"
+	case 4: // AP
+		return "tapBatch"
+	case 5: // AR
+		return "tarBatch"
+	case 7: // IM
+		return "timBatch"
+	case 8: // SO
+		return "tsoBatch"
+	case 9: // CM
+		return "tcmBatch"
+	case 10: // MC
+		return "tmcBatch"
+	case 11: // PO
+		return "tpoBatch"
+	case 12: // MF
+		return "tmfBatch_HAI"
+	case 19: // PA
+		return "tpaBatch"
+	}
+
+	if c, ok := GetBatchCreator(iModuleNo); ok {
+		if t, ist := c.(BatchTableNamer); ist {
+			return t.BatchTable()
+		}
+	}
+
+	return ""
+}
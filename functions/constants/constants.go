@@ -11,6 +11,9 @@ type ModuleConstant int16
 // LogicalLockResultConstant - Logical Lock Result
 type LogicalLockResultConstant int16
 
+// LockTypeConstant - strength of a logical lock
+type LockTypeConstant int16
+
 //  Result Constants
 const (
 	ResultUnknown ResultConstant = -1
@@ -21,6 +24,7 @@ const (
 
 // Module constants
 const (
+	ModuleGL ModuleConstant = 3
 	ModuleAP ModuleConstant = 4
 	ModuleAR ModuleConstant = 5
 	ModuleIM ModuleConstant = 7
@@ -41,6 +45,17 @@ const (
 	LogLockResultExclLockReqFailed   LogicalLockResultConstant = 102 // Exclusive lock request failed, a lock of some type exists.
 )
 
+// Lock type constants
+const (
+	LockTypeShared    LockTypeConstant = 1 // Allows other shared locks
+	LockTypeExclusive LockTypeConstant = 2 // Only one lock for the LogicalLockType / LogicalLockID
+)
+
+// Logical lock types (tsmLogicalLockType) registered by this program
+const (
+	LogicalLockTypeItemWhse = 9901 // Warehouse and item locks of the inventory commit.  See sql/imItemWhseLockType.sql.
+)
+
 // ======================================================================== BATCHING ===================================================================== //
 
 // BatchReturnConstant - batch processing results
@@ -68,6 +83,9 @@ const (
 	BatchReturnFailed      BatchReturnConstant = 5
 	BatchReturnExists      BatchReturnConstant = 6
 	BatchReturnInterrupted BatchReturnConstant = 7
+	BatchReturnConflict    BatchReturnConstant = 8  // added only
+	BatchReturnIllegal     BatchReturnConstant = 9  // added only
+	BatchReturnLocked      BatchReturnConstant = 10 // added only
 )
 
 // Constant values of batch status
@@ -221,6 +239,12 @@ type InventoryStatusConstant int8
 // InventoryTranTypeConstant - tran types
 type InventoryTranTypeConstant int16
 
+// ValuationMethConstant - item valuation method (timItem.ValuationMeth)
+type ValuationMethConstant int8
+
+// NegInvtPolicyConstant - what a commit does when it takes the quantity on hand below zero (timNegInvtPolicy_HAI.NegInvtPolicy)
+type NegInvtPolicyConstant int8
+
 // Inventory action constants
 const (
 	InventoryIncrease InventoryActionConstant = 1
@@ -234,6 +258,22 @@ const (
 	InventoryStatusClosed  InventoryStatusConstant = 3
 )
 
+// Valuation methods
+const (
+	ValuationMethStandard ValuationMethConstant = 1 // Standard Cost
+	ValuationMethAverage  ValuationMethConstant = 2 // Average Cost
+	ValuationMethFIFO     ValuationMethConstant = 3 // First In, First Out
+	ValuationMethLIFO     ValuationMethConstant = 4 // Last In, First Out
+	ValuationMethActual   ValuationMethConstant = 5 // Actual Cost
+)
+
+// Negative inventory policies
+const (
+	NegInvtPolicyAllow NegInvtPolicyConstant = 0 // Commit without a message
+	NegInvtPolicyWarn  NegInvtPolicyConstant = 1 // Commit and log a warning
+	NegInvtPolicyBlock NegInvtPolicyConstant = 2 // Do not commit and log an error
+)
+
 // Inventory tran types
 const (
 	IMTranTypeSale           InventoryTranTypeConstant = 701 // IM Sale
@@ -255,6 +295,29 @@ const (
 	IMTranTypeBegBal         InventoryTranTypeConstant = 749 // IM Beginning Balance
 )
 
+// IMCommitTranTypes - IM tran types committed through a disposable batch and posted to GL
+// without a batch.  The values are InventoryTranTypeConstant, typed for use as query arguments.
+var IMCommitTranTypes = []interface{}{
+	IMTranTypeIssue,
+	IMTranTypeTransfIn,
+	IMTranTypeTransfOut,
+	IMTranTypeAdjustment,
+	IMTranTypeKitAssembly,
+	IMTranTypeKitAssComp,
+	IMTranTypeKitDisassembly,
+	IMTranTypeKitDisComp,
+}
+
+// CommitStatusConstant - progress of a transaction in #tciTransToCommit
+type CommitStatusConstant int16
+
+// CommitStatusConstant - members of the constant
+const (
+	CommitStatusDefault      CommitStatusConstant = 0  // New transaction, have not been processed (Default Value).
+	CommitStatusLockedByUser CommitStatusConstant = -6 // The warehouse and item are locked by another commit.  Deferred to the next run.
+	CommitStatusNegInventory CommitStatusConstant = -8 // Not enough quantity on hand in a bin or lot and negative inventory is blocked.
+)
+
 // ======================================================================== GENERAL LEDGER ===================================================================== //
 
 // GLPostStatusConstant - General ledger post status constant
@@ -263,6 +326,9 @@ type GLPostStatusConstant int8
 // GLErrorLevelConstant - error levels
 type GLErrorLevelConstant int8
 
+// AcctRefUsageConstant - account reference code usage (tglOptions.AcctRefUsage)
+type AcctRefUsageConstant int8
+
 // GLPostStatusConstant - members of the constant
 const (
 	GLPostStatusDefault               GLPostStatusConstant = 0  // New Transaction, have not been processed (Default Value).
@@ -283,6 +349,13 @@ const (
 	GLErrorFatal   GLErrorLevelConstant = 2
 )
 
+// AcctRefUsageConstant - members of the constant
+const (
+	AcctRefUsageNone      AcctRefUsageConstant = 0 // Account reference codes are not used.
+	AcctRefUsageValidated AcctRefUsageConstant = 1 // Codes must exist, be active, effective and valid for all account segments.
+	AcctRefUsageFreeForm  AcctRefUsageConstant = 2 // Codes are created when they do not exist.
+)
+
 // various constants
 const (
 	InterfaceError int = 3
@@ -307,3 +380,11 @@ const (
 	POTranTypeRequisition  PurchaseOrderTranTypeConstants = 1130 // Requisition
 	POTranTypeChangeOrder  PurchaseOrderTranTypeConstants = 1150 // PO Change Order
 )
+
+// POCommitTranTypes - PO receiver tran types committed through a disposable batch and posted to GL
+// without a batch.  The transit warehouse transfer out (1113) is posted with its receipt from warehouse.
+var POCommitTranTypes = []interface{}{
+	POTranTypeRcptVendor,
+	POTranTypeReturn,
+	POTranTypeWarehouse,
+}
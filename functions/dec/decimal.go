@@ -0,0 +1,359 @@
+package dec
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Decimal - an exact decimal number for quantities, costs and amounts.
+//
+// A Decimal is the unscaled integer divided by 10^scale, the same as the SQL Server
+// DECIMAL type.  The zero value is 0.  Decimals are immutable; the operations return
+// new values.
+//
+// Read decimal columns with Col, Cols and Ord.  They use the string value of the column
+// so that the number does not go through float64, and report values that are not numbers.
+type Decimal struct {
+	i     *big.Int
+	scale int32
+}
+
+// Decimal places used by Sage
+const (
+	AmtPlaces  int32 = 3 // Home currency amounts (PostAmtHC DECIMAL(15,3))
+	CostPlaces int32 = 5 // Unit costs (DECIMAL(15,5))
+	QtyPlaces  int32 = 8 // Quantities (DECIMAL(16,8))
+)
+
+var (
+	// Zero - the number 0
+	Zero = Decimal{}
+
+	bigTen = big.NewInt(10)
+)
+
+// ErrSyntax - the string is not a decimal number
+var ErrSyntax = errors.New("dec: invalid decimal number")
+
+// New - returns unscaled / 10^scale
+func New(unscaled int64, scale int32) Decimal {
+	if scale < 0 {
+		return Decimal{i: new(big.Int).Mul(big.NewInt(unscaled), pow10(-scale))}
+	}
+
+	return Decimal{i: big.NewInt(unscaled), scale: scale}
+}
+
+// FromInt - returns the integer as a Decimal
+func FromInt(v int64) Decimal {
+	return New(v, 0)
+}
+
+// FromFloat - returns the shortest decimal representation of a float.  Use only for
+// values that are floats to begin with.
+func FromFloat(v float64) Decimal {
+	d, err := Parse(strconv.FormatFloat(v, 'f', -1, 64))
+	if err != nil {
+		return Zero
+	}
+
+	return d
+}
+
+// Parse - parses a decimal number such as -123.4500
+func Parse(s string) (Decimal, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Zero, ErrSyntax
+	}
+
+	neg := false
+	switch s[0] {
+	case '-':
+		neg = true
+		s = s[1:]
+	case '+':
+		s = s[1:]
+	}
+
+	whole, frac := s, ""
+	if p := strings.IndexByte(s, '.'); p != -1 {
+		whole, frac = s[:p], s[p+1:]
+	}
+	if whole == "" && frac == "" {
+		return Zero, ErrSyntax
+	}
+
+	i, ok := new(big.Int).SetString("0"+whole+frac, 10)
+	if !ok || strings.ContainsAny(whole+frac, "+-") {
+		return Zero, ErrSyntax
+	}
+	if neg {
+		i.Neg(i)
+	}
+
+	return Decimal{i: i, scale: int32(len(frac))}, nil
+}
+
+// Col - reads a decimal column of a row by name.  A NULL column is zero.
+func Col(r interface{ ValueString(string) string }, iName string) (Decimal, error) {
+	return column(r.ValueString(iName), iName)
+}
+
+// Cols - reads decimal columns of a row by name, in the order of the names
+func Cols(r interface{ ValueString(string) string }, iNames ...string) ([]Decimal, error) {
+	ds := make([]Decimal, len(iNames))
+	for i, n := range iNames {
+		d, err := Col(r, n)
+		if err != nil {
+			return nil, err
+		}
+		ds[i] = d
+	}
+
+	return ds, nil
+}
+
+// Ord - reads a decimal column of a row by ordinal.  A NULL column is zero.
+func Ord(r interface{ ValueStringOrd(int) string }, iOrd int) (Decimal, error) {
+	return column(r.ValueStringOrd(iOrd), strconv.Itoa(iOrd))
+}
+
+// column - parses the value of a column.  The row returns NULL as a blank string.
+func column(s string, iName string) (Decimal, error) {
+	if strings.TrimSpace(s) == "" {
+		return Zero, nil
+	}
+
+	d, err := Parse(s)
+	if err != nil {
+		return Zero, fmt.Errorf("%w: column %s value %q", err, iName, s)
+	}
+
+	return d, nil
+}
+
+// Scale - returns the number of decimal places
+func (d Decimal) Scale() int32 {
+	return d.scale
+}
+
+// Add - returns d + d2
+func (d Decimal) Add(d2 Decimal) Decimal {
+	a, b, s := align(d, d2)
+	return Decimal{i: new(big.Int).Add(a, b), scale: s}
+}
+
+// Sub - returns d - d2
+func (d Decimal) Sub(d2 Decimal) Decimal {
+	a, b, s := align(d, d2)
+	return Decimal{i: new(big.Int).Sub(a, b), scale: s}
+}
+
+// Mul - returns d * d2.  The scale of the result is the sum of the scales.
+func (d Decimal) Mul(d2 Decimal) Decimal {
+	return Decimal{i: new(big.Int).Mul(d.int(), d2.int()), scale: d.scale + d2.scale}
+}
+
+// Div - returns d / d2 rounded to the decimal places.  Division by zero returns zero.
+func (d Decimal) Div(d2 Decimal, iPlaces int32) Decimal {
+	if d2.IsZero() {
+		return Zero
+	}
+
+	// d / d2 = (di * 10^(places + 1 + d2.scale - d.scale)) / d2i, with one extra place to round
+	num := new(big.Int).Set(d.int())
+	den := new(big.Int).Set(d2.int())
+	if e := iPlaces + 1 + d2.scale - d.scale; e >= 0 {
+		num.Mul(num, pow10(e))
+	} else {
+		den.Mul(den, pow10(-e))
+	}
+
+	return Decimal{i: num.Quo(num, den), scale: iPlaces + 1}.Round(iPlaces)
+}
+
+// Neg - returns -d
+func (d Decimal) Neg() Decimal {
+	return Decimal{i: new(big.Int).Neg(d.int()), scale: d.scale}
+}
+
+// Abs - returns |d|
+func (d Decimal) Abs() Decimal {
+	return Decimal{i: new(big.Int).Abs(d.int()), scale: d.scale}
+}
+
+// Sign - returns -1, 0 or 1
+func (d Decimal) Sign() int {
+	return d.int().Sign()
+}
+
+// IsZero - checks if d is 0
+func (d Decimal) IsZero() bool {
+	return d.Sign() == 0
+}
+
+// Cmp - returns -1 when d < d2, 0 when d = d2 and 1 when d > d2
+func (d Decimal) Cmp(d2 Decimal) int {
+	a, b, _ := align(d, d2)
+	return a.Cmp(b)
+}
+
+// Equal - checks if d = d2 regardless of the scales
+func (d Decimal) Equal(d2 Decimal) bool {
+	return d.Cmp(d2) == 0
+}
+
+// Round - rounds to the decimal places, half away from zero like ROUND in SQL Server
+func (d Decimal) Round(iPlaces int32) Decimal {
+	if d.scale <= iPlaces {
+		return d.rescale(iPlaces)
+	}
+
+	f := pow10(d.scale - iPlaces)
+	q, r := new(big.Int).QuoRem(d.int(), f, new(big.Int))
+
+	// |r| * 2 >= f rounds away from zero
+	r.Abs(r).Lsh(r, 1)
+	if r.Cmp(f) >= 0 {
+		if d.Sign() < 0 {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+
+	return Decimal{i: q, scale: iPlaces}
+}
+
+// RoundAmt - rounds an amount to the home currency decimal places
+func (d Decimal) RoundAmt() Decimal {
+	return d.Round(AmtPlaces)
+}
+
+// RoundQty - rounds a quantity to the quantity decimal places
+func (d Decimal) RoundQty() Decimal {
+	return d.Round(QtyPlaces)
+}
+
+// Float64 - returns the nearest float.  Use only for output.
+func (d Decimal) Float64() float64 {
+	f, _ := strconv.ParseFloat(d.String(), 64)
+	return f
+}
+
+// String - returns the number with all of its decimal places
+func (d Decimal) String() string {
+	if d.scale <= 0 {
+		return d.rescale(0).int().String()
+	}
+
+	s := new(big.Int).Abs(d.int()).String()
+	if len(s) <= int(d.scale) {
+		s = strings.Repeat("0", int(d.scale)-len(s)+1) + s
+	}
+
+	p := len(s) - int(d.scale)
+	s = s[:p] + "." + s[p:]
+	if d.Sign() < 0 {
+		s = "-" + s
+	}
+
+	return s
+}
+
+// StringFixed - returns the number rounded to the decimal places
+func (d Decimal) StringFixed(iPlaces int32) string {
+	return d.Round(iPlaces).String()
+}
+
+// Scan - implements sql.Scanner.  The driver returns DECIMAL columns as text.
+func (d *Decimal) Scan(src interface{}) error {
+	var err error
+
+	switch v := src.(type) {
+	case nil:
+		*d = Zero
+	case []byte:
+		*d, err = Parse(string(v))
+	case string:
+		*d, err = Parse(v)
+	case int64:
+		*d = FromInt(v)
+	case float64:
+		*d = FromFloat(v)
+	default:
+		err = errors.New("dec: cannot scan the value into a Decimal")
+	}
+
+	return err
+}
+
+// Value - implements driver.Valuer.  The number is passed as text.
+func (d Decimal) Value() (driver.Value, error) {
+	return d.String(), nil
+}
+
+// MarshalJSON - writes the number as a JSON number
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalJSON - reads a JSON number or string
+func (d *Decimal) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	if s == "null" {
+		*d = Zero
+		return nil
+	}
+
+	v, err := Parse(s)
+	if err != nil {
+		return err
+	}
+
+	*d = v
+	return nil
+}
+
+// int - returns the unscaled value; nil is zero
+func (d Decimal) int() *big.Int {
+	if d.i == nil {
+		return new(big.Int)
+	}
+
+	return d.i
+}
+
+// rescale - returns the number with more decimal places
+func (d Decimal) rescale(iScale int32) Decimal {
+	if iScale == d.scale {
+		return Decimal{i: d.int(), scale: d.scale}
+	}
+	if iScale < d.scale {
+		return d.Round(iScale)
+	}
+
+	return Decimal{i: new(big.Int).Mul(d.int(), pow10(iScale-d.scale)), scale: iScale}
+}
+
+// align - returns the unscaled values of two numbers at the same scale
+func align(d, d2 Decimal) (*big.Int, *big.Int, int32) {
+	if d.scale == d2.scale {
+		return d.int(), d2.int(), d.scale
+	}
+	if d.scale > d2.scale {
+		return d.int(), d2.rescale(d.scale).int(), d.scale
+	}
+
+	return d.rescale(d2.scale).int(), d2.int(), d2.scale
+}
+
+// pow10 - returns 10^n
+func pow10(n int32) *big.Int {
+	return new(big.Int).Exp(bigTen, big.NewInt(int64(n)), nil)
+}
@@ -0,0 +1,179 @@
+package dec
+
+import (
+	"errors"
+	"testing"
+)
+
+// mustParse - parses a number of a test table
+func mustParse(t *testing.T, s string) Decimal {
+	t.Helper()
+
+	d, err := Parse(s)
+	if err != nil {
+		t.Fatalf("Parse(%q): %s", s, err)
+	}
+
+	return d
+}
+
+// TestParse - numbers are parsed with their scale and invalid strings are refused
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in    string
+		want  string
+		scale int32
+		err   bool
+	}{
+		{"0", "0", 0, false},
+		{"123", "123", 0, false},
+		{"-123.4500", "-123.4500", 4, false},
+		{"+1.5", "1.5", 1, false},
+		{" 2.25 ", "2.25", 2, false},
+		{".5", "0.5", 1, false},
+		{"-.5", "-0.5", 1, false},
+		{"7.", "7", 0, false},
+		{"0.00000001", "0.00000001", 8, false},
+		{"123456789012345678901234567890.123", "123456789012345678901234567890.123", 3, false},
+		{"", "", 0, true},
+		{"   ", "", 0, true},
+		{"-", "", 0, true},
+		{".", "", 0, true},
+		{"1.2.3", "", 0, true},
+		{"1-2", "", 0, true},
+		{"--1", "", 0, true},
+		{"1e5", "", 0, true},
+		{"abc", "", 0, true},
+	}
+
+	for _, tt := range tests {
+		d, err := Parse(tt.in)
+		if tt.err {
+			if !errors.Is(err, ErrSyntax) {
+				t.Errorf("Parse(%q) = %s, %v, want ErrSyntax", tt.in, d, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Parse(%q): %s", tt.in, err)
+			continue
+		}
+		if d.String() != tt.want || d.Scale() != tt.scale {
+			t.Errorf("Parse(%q) = %s with scale %d, want %s with scale %d", tt.in, d, d.Scale(), tt.want, tt.scale)
+		}
+	}
+}
+
+// TestRound - halves are rounded away from zero like ROUND in SQL Server
+func TestRound(t *testing.T) {
+	tests := []struct {
+		in     string
+		places int32
+		want   string
+	}{
+		{"1.2345", 3, "1.235"},
+		{"1.2344", 3, "1.234"},
+		{"-1.2345", 3, "-1.235"},
+		{"-1.2344", 3, "-1.234"},
+		{"0.5", 0, "1"},
+		{"-0.5", 0, "-1"},
+		{"0.49999", 0, "0"},
+		{"-0.49999", 0, "0"},
+		{"2.5", 0, "3"},
+		{"-2.5", 0, "-3"},
+		{"9.9995", 3, "10.000"},
+		{"-9.9995", 3, "-10.000"},
+		{"1.5", 3, "1.500"},
+		{"-0.0004", 3, "0.000"},
+		{"123", 2, "123.00"},
+	}
+
+	for _, tt := range tests {
+		if got := mustParse(t, tt.in).Round(tt.places).String(); got != tt.want {
+			t.Errorf("Round(%s, %d) = %s, want %s", tt.in, tt.places, got, tt.want)
+		}
+	}
+}
+
+// TestDiv - quotients are rounded to the decimal places and division by zero is zero
+func TestDiv(t *testing.T) {
+	tests := []struct {
+		a, b   string
+		places int32
+		want   string
+	}{
+		{"10", "4", 2, "2.50"},
+		{"1", "3", 5, "0.33333"},
+		{"2", "3", 5, "0.66667"},
+		{"-2", "3", 5, "-0.66667"},
+		{"2", "-3", 5, "-0.66667"},
+		{"-2", "-3", 5, "0.66667"},
+		{"100.00", "0.03", 2, "3333.33"},
+		{"0.125", "1", 2, "0.13"},
+		{"-0.125", "1", 2, "-0.13"},
+		{"123.45678", "10.5", 5, "11.75779"},
+		{"5", "0", 2, "0"},
+		{"0", "7", 2, "0.00"},
+	}
+
+	for _, tt := range tests {
+		if got := mustParse(t, tt.a).Div(mustParse(t, tt.b), tt.places).String(); got != tt.want {
+			t.Errorf("%s / %s to %d places = %s, want %s", tt.a, tt.b, tt.places, got, tt.want)
+		}
+	}
+}
+
+// TestString - numbers keep all of their decimal places
+func TestString(t *testing.T) {
+	tests := []struct {
+		d    Decimal
+		want string
+	}{
+		{Zero, "0"},
+		{FromInt(42), "42"},
+		{FromInt(-42), "-42"},
+		{New(12345, 2), "123.45"},
+		{New(-12345, 2), "-123.45"},
+		{New(5, 3), "0.005"},
+		{New(-5, 3), "-0.005"},
+		{New(100, 2), "1.00"},
+		{New(12, -2), "1200"},
+		{FromFloat(0.1), "0.1"},
+		{FromInt(1).Add(New(5, 1)), "1.5"},
+		{New(15, 1).Mul(New(15, 1)), "2.25"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.d.String(); got != tt.want {
+			t.Errorf("String() = %s, want %s", got, tt.want)
+		}
+	}
+}
+
+// testRow - a row with the string values of its columns
+type testRow map[string]string
+
+func (r testRow) ValueString(iName string) string { return r[iName] }
+
+// TestCol - NULL columns are zero and values that are not numbers are reported
+func TestCol(t *testing.T) {
+	r := testRow{"Qty": "12.50000000", "Cost": "", "Bad": "12,5"}
+
+	if d, err := Col(r, "Qty"); err != nil || !d.Equal(New(125, 1)) {
+		t.Errorf("Col(Qty) = %s, %v, want 12.5", d, err)
+	}
+	if d, err := Col(r, "Cost"); err != nil || !d.IsZero() {
+		t.Errorf("Col(Cost) = %s, %v, want 0", d, err)
+	}
+	if _, err := Col(r, "Bad"); !errors.Is(err, ErrSyntax) {
+		t.Errorf("Col(Bad) error = %v, want ErrSyntax", err)
+	}
+
+	if ds, err := Cols(r, "Qty", "Cost"); err != nil || len(ds) != 2 || !ds[0].Equal(New(125, 1)) || !ds[1].IsZero() {
+		t.Errorf("Cols(Qty, Cost) = %v, %v", ds, err)
+	}
+	if _, err := Cols(r, "Qty", "Bad"); !errors.Is(err, ErrSyntax) {
+		t.Errorf("Cols(Qty, Bad) error = %v, want ErrSyntax", err)
+	}
+}
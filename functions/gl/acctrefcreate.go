@@ -0,0 +1,106 @@
+package gl
+
+import (
+	"gosqljobs/invtcommit/functions/constants"
+	"gosqljobs/invtcommit/functions/sm"
+	"time"
+
+	du "github.com/eaglebush/datautils"
+)
+
+// AcctRefCreate - Returns the key of an Account Reference Code, creating the code when it does not exist.
+//
+// This is used when tglOptions.AcctRefUsage is set to '2' (free-form codes).
+// An existing code is only returned when it is active, effective on iEffectiveDate
+// and its group is allowed for all the segments of the GL account.  A new code is
+// created under the first Account Reference Group allowed for all the segments of the
+// GL account (tglAcctRefUsage) and is effective starting iEffectiveDate.
+//
+// Input Parameters:
+//    iCompanyID     = [IN: Valid Acuity Company; No Default]
+//    iGLAcctKey     = [IN: GL Account the code is posted with]
+//    iAcctRefCode   = [IN: Account Reference Code]
+//    iEffectiveDate = [IN: Effective Date or NULL]
+//
+// Output Parameters:
+//    Result      = [OUT: return flag indicating outcome of the procedure]
+//           0 = Failure.  General SP Failure.
+//           1 = Successful.  Code created or already exists.
+//          31 = Failure.  No Account Reference Group is valid for all Account Segments.
+//          32 = Failure.  Existing code fails the Effective Dates Restriction.
+//          37 = Failure.  Existing code is not active.
+//    AcctRefKey  = [OUT: Key of the new or existing code]
+func AcctRefCreate(
+	bq *du.BatchQuery,
+	iCompanyID string,
+	iGLAcctKey int,
+	iAcctRefCode string,
+	iEffectiveDate *time.Time) (Result constants.ResultConstant, AcctRefKey int) {
+
+	bq.ScopeName("AcctRefCreate")
+
+	if iAcctRefCode == "" {
+		return constants.ResultError, 0
+	}
+
+	// Account Reference Groups valid for all the segments of the account
+	lGroupKeys := make([]int64, 0)
+	qr := bq.Get(`SELECT b.AcctRefGroupKey
+				  FROM tglAcctSegment a WITH (NOLOCK)
+					JOIN tglAcctRefUsage b WITH (NOLOCK) ON a.SegmentKey = b.SegmentKey AND a.AcctSegValue = b.AcctSegValue
+				  WHERE a.GLAcctKey=?
+				  GROUP BY b.AcctRefGroupKey
+				  HAVING COUNT(b.AcctRefGroupKey) = (SELECT COUNT(SegmentKey) FROM tglSegment WITH (NOLOCK) WHERE CompanyID=?)
+				  ORDER BY b.AcctRefGroupKey;`, iGLAcctKey, iCompanyID)
+	if !bq.OK() {
+		return constants.ResultError, 0
+	}
+	for _, v := range qr.Data {
+		lGroupKeys = append(lGroupKeys, v.ValueInt64Ord(0))
+	}
+
+	qr = bq.Get(`SELECT AcctRefKey, AcctRefGroupKey, Status,
+						CASE WHEN EffStartDate IS NOT NULL AND EffStartDate > ? THEN 1
+							 WHEN EffEndDate IS NOT NULL AND EffEndDate < ? THEN 1
+							 ELSE 0 END AS OutOfDate
+				 FROM tglAcctRef WITH (NOLOCK)
+				 WHERE CompanyID=? AND AcctRefCode=?;`, iEffectiveDate, iEffectiveDate, iCompanyID, iAcctRefCode)
+	if qr.HasData {
+		r := qr.First()
+
+		if r.ValueInt64("Status") != 1 {
+			return constants.ResultConstant(37), 0
+		}
+
+		if iEffectiveDate != nil && r.ValueInt64("OutOfDate") == 1 {
+			return constants.ResultConstant(32), 0
+		}
+
+		if !sm.InInt64Array(&lGroupKeys, r.ValueInt64("AcctRefGroupKey")) {
+			return constants.ResultConstant(31), 0
+		}
+
+		return constants.ResultSuccess, int(r.ValueInt64("AcctRefKey"))
+	}
+
+	if len(lGroupKeys) == 0 {
+		return constants.ResultConstant(31), 0
+	}
+
+	AcctRefKey = sm.GetNextSurrogateKey(bq, "tglAcctRef")
+	if AcctRefKey == 0 {
+		return constants.ResultError, 0
+	}
+
+	bq.Set(`INSERT INTO tglAcctRef (
+				AcctRefKey, CompanyID, AcctRefCode, AcctRefGroupKey,
+				Description, Status, EffStartDate, EffEndDate)
+			VALUES (?, ?, ?, ?,
+					?, 1, ?, NULL);`, AcctRefKey, iCompanyID, iAcctRefCode, lGroupKeys[0],
+		iAcctRefCode, iEffectiveDate)
+	if !bq.OK() {
+		return constants.ResultError, 0
+	}
+
+	return constants.ResultSuccess, AcctRefKey
+}
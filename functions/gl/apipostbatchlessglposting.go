@@ -1,8 +1,10 @@
 package gl
 
 import (
+	"gosqljobs/invtcommit/functions/bat"
 	"gosqljobs/invtcommit/functions/constants"
 	"gosqljobs/invtcommit/functions/sm"
+	"strings"
 
 	du "github.com/eaglebush/datautils"
 )
@@ -28,7 +30,7 @@ import (
 //                     CompanyID         VARCHAR(3) NOT NULL,
 //                     TranID            VARCHAR(13) NOT NULL, -- TranID used for reporting.
 //                     TranType          INTEGER NOT NULL,  -- Supported transaction types.
-//                     TranKey           INTEGER NOT NULL,  -- Represents the TranKey of the transactions to post. (ie. ShipKey for SO)
+//                     TranKey           INTEGER NOT NULL,  -- Represents the TranKey of the transactions to post. (ie. ShipKey for SO, InvtTranKey for IM, RcvrKey for PO)
 //                     GLBatchKey        INTEGER NOT NULL,  -- Represents the GL batch to post the transactions.
 //                     PostStatus        INTEGER DEFAULT 0) -- Status use to determine progress of each transaction.
 //
@@ -56,6 +58,9 @@ import (
 //             this option when the user decides to preview the GL register instead of actually proceeding with the posting.
 //                Note: Each time this routine is called, a GL Batch number is used even if this option is set
 //                to false.  This way the final GL Batch number is seen during preview or after posting.
+//            @optCreateAccts = Defaults to false.  When set to true and AutoAcctAdd is on in tglOptions, the GL
+//             accounts of the posting rows that do not exist are created by SetAPIValidateAccount (AutoAcctCreate)
+//             instead of being replaced with the suspense account.  The account numbers are those of the posting rows.
 //
 //   RETURN Codes
 //    0 - Unexpected Error (SP Failure)
@@ -66,7 +71,8 @@ func APIPostBatchlessGLPosting(
 	iSessionID int,
 	loginID string,
 	optReplcInvalidAcctWithSuspense bool,
-	optPostToGL bool) (Result constants.ResultConstant, SessionID int) {
+	optPostToGL bool,
+	optCreateAccts bool) (Result constants.ResultConstant, SessionID int) {
 
 	bq.ScopeName("APIPostBatchlessGLPosting")
 
@@ -169,7 +175,9 @@ func APIPostBatchlessGLPosting(
 				CREATE TABLE #tglValidateAcct
 				(
 					glacctkey        INT NOT NULL,
+					glacctno         VARCHAR(100) NULL,
 					acctrefkey       INT NULL,
+					acctrefcode      VARCHAR(20) NULL,
 					currid           VARCHAR(3) NOT NULL,
 					validationretval INT NOT NULL,
 					errormsgno       INT NULL
@@ -194,7 +202,9 @@ func APIPostBatchlessGLPosting(
 					postingkey     INTEGER NOT NULL,
 					sourcemoduleno SMALLINT NOT NULL,
 					glacctkey      INTEGER NOT NULL,
+					glacctno       VARCHAR(100) NULL,
 					acctrefkey     INTEGER NULL,
+					acctrefcode    VARCHAR(20) NULL,
 					currid         VARCHAR(3) NOT NULL,
 					postdate       DATETIME NOT NULL,
 					postamthc      DECIMAL(15, 3) NOT NULL,
@@ -230,9 +240,11 @@ func APIPostBatchlessGLPosting(
 	bq.Set(`INSERT #tciTransToPost (CompanyID, TranID, TranType, TranKey, GLBatchKey, PostStatus)
 			SELECT CompanyID, TranID, TranType, TranKey, GLBatchKey, PostStatus FROM #UniqueTransToPost;`)
 
+	oSessionID := iSessionID
+
 	// These will be executed before the function exits
 	defer sm.LogicalLockRemoveMultiple(bq)
-	defer sm.LogErrors(bq, iSessionID, iSessionID)
+	defer func() { sm.LogErrors(bq, oSessionID, oSessionID) }()
 
 	res := CreateBatchlessGLPostingBatch(bq, loginID, iBatchCmnt)
 	if res != constants.ResultSuccess {
@@ -240,11 +252,6 @@ func APIPostBatchlessGLPosting(
 		return constants.ResultError, iSessionID
 	}
 
-	oSessionID := 0
-	if iSessionID != 0 {
-		oSessionID = iSessionID
-	}
-
 	var qr du.QueryResult
 	if oSessionID == 0 {
 		// Use a one SessionID for all batches we are about to post.  This will help in reporting.
@@ -278,13 +285,16 @@ func APIPostBatchlessGLPosting(
 	}
 
 	// Make sure only supported TranTypes are in #tciTransToPost.
+	imtt := `?` + strings.Repeat(`,?`, len(constants.IMCommitTranTypes)-1)
+	pott := `?` + strings.Repeat(`,?`, len(constants.POCommitTranTypes)-1)
 	qr = bq.Set(`UPDATE #tciTransToPost
 				SET PostStatus=?
-				WHERE TranType NOT IN (?,?,?,?)
+				WHERE TranType NOT IN (?,?,?,?,`+imtt+`,`+pott+`)
 					AND PostStatus IN (?,?);`,
-		constants.GLPostStatusTTypeNotSupported,
-		constants.SOTranTypeCustShip, constants.SOTranTypeDropShip, constants.SOTranTypeTransShip, constants.SOTranTypeCustRtrn,
-		constants.GLPostStatusDefault, constants.GLPostStatusInvalid)
+		append(append(append([]interface{}{constants.GLPostStatusTTypeNotSupported,
+			constants.SOTranTypeCustShip, constants.SOTranTypeDropShip, constants.SOTranTypeTransShip, constants.SOTranTypeCustRtrn},
+			constants.IMCommitTranTypes...), constants.POCommitTranTypes...),
+			constants.GLPostStatusDefault, constants.GLPostStatusInvalid)...)
 	if qr.HasAffectedRows {
 		// Batch {0}, Transaction {1}: Invalid transation type
 		bq.Set(`INSERT INTO #tciError (EntryNo, BatchKey, StringNo, StringData1, StringData2, ErrorType, Severity, TranType, TranKey)
@@ -344,6 +354,93 @@ func APIPostBatchlessGLPosting(
 			constants.SOTranTypeCustShip, constants.SOTranTypeDropShip, constants.SOTranTypeTransShip, constants.SOTranTypeCustRtrn)
 	}
 
+	// IM Tran Types
+	qr = bq.Get(`SELECT 1 FROM #tciTransToPost WHERE TranType IN (`+imtt+`) AND PostStatus IN (?,?);`,
+		append(append([]interface{}{}, constants.IMCommitTranTypes...),
+			constants.GLPostStatusDefault, constants.GLPostStatusInvalid)...)
+	if qr.HasData {
+		// -- For IM transactions, mark those transactions that are still pending or were not found.
+		qr = bq.Set(`UPDATE tmp
+					SET tmp.PostStatus=?
+					FROM #tciTransToPost tmp
+						LEFT JOIN timInvtTran it WITH (NOLOCK) ON tmp.TranKey = it.InvtTranKey
+					WHERE tmp.TranType IN (`+imtt+`)
+						AND tmp.PostStatus IN (?,?)
+						AND (it.InvtTranKey IS NULL
+							OR EXISTS (SELECT 1 FROM timPendInvtTran p WITH (NOLOCK) WHERE p.InvtTranKey = tmp.TranKey));`,
+			append(append([]interface{}{constants.GLPostStatusTranNotCommitted}, constants.IMCommitTranTypes...),
+				constants.GLPostStatusDefault, constants.GLPostStatusInvalid)...)
+		if qr.HasAffectedRows {
+			// -- {0} transactions have not been successfully Pre-Committed.
+			bq.Set(`INSERT INTO #tciError (EntryNo,BatchKey,StringNo,StringData1,ErrorType,Severity,TranType,TranKey)
+					SELECT NULL, tmp.GLBatchKey, 250893, tmp.TranID, 2, ?, tmp.TranType, tmp.TranKey
+					FROM   #tciTransToPost tmp
+					WHERE  tmp.PostStatus=? AND tmp.TranType IN (`+imtt+`);`,
+				append([]interface{}{constants.GLErrorFatal, constants.GLPostStatusTranNotCommitted}, constants.IMCommitTranTypes...)...)
+		}
+
+		// -- Inventory TranTypes: the InvtTranKey is the TranKey of the posting rows.
+		bq.Set(`INSERT INTO #tciTransToPostDetl (
+					CompanyID,TranID,TranType,TranKey,InvtTranKey,GLBatchKey,
+					PostStatus,PostingKey,SourceModuleNo,GLAcctKey,AcctRefKey,
+					CurrID,PostDate,PostAmtHC )
+				SELECT DISTINCT it.CompanyID, tmp.TranID, tmp.TranType, tmp.TranKey, it.InvtTranKey, tmp.GLBatchKey,
+					tmp.PostStatus, gl.PostingKey, gl.SourceModuleNo, gl.GLAcctKey, gl.AcctRefKey,
+					gl.CurrID, gl.PostDate, gl.PostAmtHC
+				FROM #tciTransToPost tmp
+					JOIN timInvtTran it WITH (NOLOCK) ON tmp.TranKey = it.InvtTranKey
+					JOIN tglPosting gl WITH (NOLOCK) ON tmp.TranType = gl.TranType AND tmp.TranKey = gl.TranKey
+				WHERE tmp.PostStatus IN (?,?) AND tmp.TranType IN (`+imtt+`);`,
+			append([]interface{}{constants.GLPostStatusDefault, constants.GLPostStatusInvalid}, constants.IMCommitTranTypes...)...)
+	}
+
+	// PO Tran Types
+	qr = bq.Get(`SELECT 1 FROM #tciTransToPost WHERE TranType IN (`+pott+`) AND PostStatus IN (?,?);`,
+		append(append([]interface{}{}, constants.POCommitTranTypes...),
+			constants.GLPostStatusDefault, constants.GLPostStatusInvalid)...)
+	if qr.HasData {
+		// -- For PO receivers, mark those that are still pending or were not found.
+		qr = bq.Set(`UPDATE tmp
+					SET tmp.PostStatus=?
+					FROM #tciTransToPost tmp
+						LEFT JOIN tpoReceiver r WITH (NOLOCK) ON tmp.TranKey = r.RcvrKey
+					WHERE tmp.TranType IN (`+pott+`)
+						AND tmp.PostStatus IN (?,?)
+						AND (r.RcvrKey IS NULL
+							OR EXISTS (SELECT 1 FROM tpoPendReceiver p WITH (NOLOCK) WHERE p.RcvrKey = tmp.TranKey));`,
+			append(append([]interface{}{constants.GLPostStatusTranNotCommitted}, constants.POCommitTranTypes...),
+				constants.GLPostStatusDefault, constants.GLPostStatusInvalid)...)
+		if qr.HasAffectedRows {
+			// -- {0} transactions have not been successfully Pre-Committed.
+			bq.Set(`INSERT INTO #tciError (EntryNo,BatchKey,StringNo,StringData1,ErrorType,Severity,TranType,TranKey)
+					SELECT NULL, tmp.GLBatchKey, 250893, tmp.TranID, 2, ?, tmp.TranType, tmp.TranKey
+					FROM   #tciTransToPost tmp
+					WHERE  tmp.PostStatus=? AND tmp.TranType IN (`+pott+`);`,
+				append([]interface{}{constants.GLErrorFatal, constants.GLPostStatusTranNotCommitted}, constants.POCommitTranTypes...)...)
+		}
+
+		// -- Purchase Order TranTypes: the posting rows are tied to the InvtTranKey of the receiver lines.
+		// -- A receipt from warehouse also posts the transfer out of the transit warehouse.
+		bq.Set(`INSERT INTO #tciTransToPostDetl (
+					CompanyID,TranID,TranType,TranKey,InvtTranKey,GLBatchKey,
+					PostStatus,PostingKey,SourceModuleNo,GLAcctKey,AcctRefKey,
+					CurrID,PostDate,PostAmtHC )
+				SELECT DISTINCT r.CompanyID, r.TranID, r.TranType, r.RcvrKey, rl.InvtTranKey, tmp.GLBatchKey,
+					tmp.PostStatus, gl.PostingKey, gl.SourceModuleNo, gl.GLAcctKey, gl.AcctRefKey,
+					gl.CurrID, gl.PostDate, gl.PostAmtHC
+				FROM #tciTransToPost tmp
+					JOIN tpoReceiver r WITH (NOLOCK) ON tmp.TranKey = r.RcvrKey
+					JOIN tpoRcvrLine rl WITH (NOLOCK) ON r.RcvrKey = rl.RcvrKey
+					JOIN tglPosting gl WITH (NOLOCK) ON ( tmp.TranType = gl.TranType AND rl.InvtTranKey = gl.TranKey )
+														OR
+														( gl.TranType=? AND tmp.TranType=?
+														  AND gl.TranKey IN (SELECT p.InvtTranKey FROM timPosting p WITH (NOLOCK)
+																			 WHERE p.SourceInvtTranKey = rl.InvtTranKey AND p.TranType = gl.TranType) )
+				WHERE tmp.PostStatus IN (?,?) AND tmp.TranType IN (`+pott+`);`,
+			append([]interface{}{constants.POTranTypeTransOut, constants.POTranTypeWarehouse,
+				constants.GLPostStatusDefault, constants.GLPostStatusInvalid}, constants.POCommitTranTypes...)...)
+	}
+
 	// -- Check if there is anything to process.  If any of the above validations failed,
 	// -- then we will not post any transactions in the set.  This is the all or nothing approach.
 	qr = bq.Get(`SELECT 1 FROM #tciTransToPostDetl;`)
@@ -362,7 +459,7 @@ func APIPostBatchlessGLPosting(
 			FROM #tciTransToPostDetl tmp WHERE tmp.PostStatus IN (?,?);`,
 		constants.GLPostStatusDefault, constants.GLPostStatusInvalid)
 
-	res, _, _ = LogicalLockAddMultiple(bq, true, loginID)
+	res, _, _ = sm.LogicalLockAddMultiple(bq, true, loginID)
 	if res == constants.ResultUnknown {
 		return constants.ResultError, oSessionID
 	}
@@ -462,6 +559,15 @@ func APIPostBatchlessGLPosting(
 	// -- ----------------------------
 	// -- Start GL Account Validation:
 	// -- ----------------------------
+	// -- The account numbers and reference codes of the posting rows are used by SetAPIValidateAccount
+	// -- to create missing accounts and by SetAPIValidateAcctRef to create missing reference codes.
+	bq.Set(`UPDATE tmp
+			SET tmp.GLAcctNo = a.GLAcctNo,
+				tmp.AcctRefCode = r.AcctRefCode
+			FROM #tciTransToPostDetl tmp
+				LEFT JOIN tglAccount a WITH (NOLOCK) ON tmp.GLAcctKey = a.GLAcctKey
+				LEFT JOIN tglAcctRef r WITH (NOLOCK) ON tmp.AcctRefKey = r.AcctRefKey;`)
+
 	// -- Validate the GL Accounts
 	lInvalidAcctExist := false
 	lGLSuspenseAcctKey := 0
@@ -469,9 +575,7 @@ func APIPostBatchlessGLPosting(
 	for _, v := range qr.Data {
 		lCompanyID := v.ValueStringOrd(0)
 		lGLBatchKey := int(v.ValueInt64Ord(1))
-		lModuleNo := v.ValueInt64Ord(2)
 		lPostDate := v.ValueTimeOrd(3)
-		lIntegrateWithGL := 1
 
 		lHomeCurrID := ""
 		qr2 := bq.Get(`SELECT CurrID FROM tsmCompany WITH (NOLOCK) WHERE CompanyID=?;`, lCompanyID)
@@ -495,12 +599,12 @@ func APIPostBatchlessGLPosting(
 		lAutoAcctAdd = qr2.First().ValueInt64Ord(0) == 1
 		lUseMultCurr = qr2.First().ValueInt64Ord(1) == 1
 		lGLAcctMask = qr2.First().ValueStringOrd(2)
-		lAcctRefUsage = int(qr2.First().ValueInt64Ord(0))
+		lAcctRefUsage = int(qr2.First().ValueInt64Ord(3))
 
 		lLanguageID := sm.GetLanguage(bq)
 
-		bq.Set(`INSERT #tglValidateAcct (GLAcctKey, AcctRefKey, CurrID, ValidationRetVal)
-						SELECT DISTINCT tmp.GLAcctKey, tmp.AcctRefKey, tmp.CurrID, 0
+		bq.Set(`INSERT #tglValidateAcct (GLAcctKey, GLAcctNo, AcctRefKey, AcctRefCode, CurrID, ValidationRetVal)
+						SELECT DISTINCT tmp.GLAcctKey, tmp.GLAcctNo, tmp.AcctRefKey, tmp.AcctRefCode, tmp.CurrID, 0
 						FROM #tciTransToPostDetl tmp
 						WHERE tmp.GLBatchKey=?
 							AND NOT EXISTS (SELECT 1 FROM #tglValidateAcct v
@@ -533,13 +637,44 @@ func APIPostBatchlessGLPosting(
 		// Call the routine to validate the accounts.
 		rv, _, _ := SetAPIValidateAccount(bq, lCompanyID, lGLBatchKey, iSessionID, loginID, lLanguageID,
 			lHomeCurrID, lIsCurrIDUsed, lAutoAcctAdd, lUseMultCurr,
-			lGLAcctMask, lAcctRefUsage, false, true, -1, 1, 3, &lPostDate, true, true, true, true)
+			lGLAcctMask, lAcctRefUsage, false, true, -1, 1, 3, &lPostDate, true, true, true, true, optCreateAccts)
 
 		if rv == constants.ResultError {
+			res = constants.ResultError
 			goto Exit
 		}
 	}
 
+	// Post to the accounts created by SetAPIValidateAccount.
+	if optCreateAccts {
+		bq.Set(`UPDATE gl
+				SET gl.GLAcctKey = v.GLAcctKey
+				FROM tglPosting gl
+					JOIN #tciTransToPostDetl tmp ON gl.PostingKey = tmp.PostingKey
+					JOIN #tglValidateAcct v ON tmp.GLAcctNo = v.GLAcctNo AND tmp.CurrID = v.CurrID
+				WHERE v.ValidationRetVal = 0 AND gl.GLAcctKey <> v.GLAcctKey;`)
+
+		bq.Set(`UPDATE tmp
+				SET tmp.GLAcctKey = v.GLAcctKey
+				FROM #tciTransToPostDetl tmp
+					JOIN #tglValidateAcct v ON tmp.GLAcctNo = v.GLAcctNo AND tmp.CurrID = v.CurrID
+				WHERE v.ValidationRetVal = 0 AND tmp.GLAcctKey <> v.GLAcctKey;`)
+	}
+
+	// Post to the reference codes created by SetAPIValidateAcctRef.
+	bq.Set(`UPDATE gl
+			SET gl.AcctRefKey = v.AcctRefKey
+			FROM tglPosting gl
+				JOIN #tciTransToPostDetl tmp ON gl.PostingKey = tmp.PostingKey
+				JOIN #tglValidateAcct v ON tmp.GLAcctKey = v.GLAcctKey AND tmp.AcctRefCode = v.AcctRefCode AND tmp.CurrID = v.CurrID
+			WHERE v.ValidationRetVal = 0 AND COALESCE(gl.AcctRefKey, 0) <> COALESCE(v.AcctRefKey, 0);`)
+
+	bq.Set(`UPDATE tmp
+			SET tmp.AcctRefKey = v.AcctRefKey
+			FROM #tciTransToPostDetl tmp
+				JOIN #tglValidateAcct v ON tmp.GLAcctKey = v.GLAcctKey AND tmp.AcctRefCode = v.AcctRefCode AND tmp.CurrID = v.CurrID
+			WHERE v.ValidationRetVal = 0 AND COALESCE(tmp.AcctRefKey, 0) <> COALESCE(v.AcctRefKey, 0);`)
+
 	// Check if an account number failed validation
 
 	qr = bq.Get(`SELECT 1 FROM #tglValidateAcct WHERE ValidationRetVal <> 0;`)
@@ -578,13 +713,12 @@ func APIPostBatchlessGLPosting(
 	// -- -------------------------
 	// -- Start GL Posting Routine:
 	// -- -------------------------
-	qr = bq.Get(`SELECT DISTINCT CompanyID, GLBatchKey, ModuleNo FROM #tciTransToPostDetl WHERE PostStatus IN (?, ?);`, constants.GLPostStatusDefault, constants.GLPostStatusInvalid)
+	qr = bq.Get(`SELECT DISTINCT CompanyID, GLBatchKey, SourceModuleNo FROM #tciTransToPostDetl WHERE PostStatus IN (?, ?);`, constants.GLPostStatusDefault, constants.GLPostStatusInvalid)
 	for _, v := range qr.Data {
 
 		lCompanyID := v.ValueStringOrd(0)
 		lGLBatchKey := int(v.ValueInt64Ord(1))
 		lModuleNo := int(v.ValueInt64Ord(2))
-		lPostDate := v.ValueTimeOrd(3)
 		lIntegrateWithGL := true
 
 		// Update tglPosting with the GLBatchKey we will be posting to.
@@ -599,10 +733,11 @@ func APIPostBatchlessGLPosting(
 			lGLSuspenseAcctKey = 0
 			qr2 := bq.Get(`SELECT SuspenseAcctKey FROM tglOptions WITH (NOLOCK) WHERE CompanyID=?;`, lCompanyID)
 			if qr2.HasData {
-				lGLSuspenseAcctKey = int(qr.First().ValueInt64Ord(0))
+				lGLSuspenseAcctKey = int(qr2.First().ValueInt64Ord(0))
 			}
 
 			if lGLSuspenseAcctKey == 0 {
+				res = constants.ResultError
 				goto Exit
 			}
 
@@ -618,7 +753,7 @@ func APIPostBatchlessGLPosting(
 		}
 
 		// Summarize the GL Posting records based on the current posting settings.
-		qr = bq.Set(`INSERT #tglPostingDetlTran (PostingDetlTranKey, TranType)
+		bq.Set(`INSERT #tglPostingDetlTran (PostingDetlTranKey, TranType)
 					 SELECT DISTINCT InvtTranKey, TranType
 					 FROM #tciTransToPostDetl
 					 WHERE PostStatus IN (?,?)
@@ -629,6 +764,12 @@ func APIPostBatchlessGLPosting(
 			goto Exit
 		}
 
+		// Keep the BatchTotal and NextSeqNo of the GL batch in line with its posting rows
+		if bat.UpdateBatchTotals(bq, lGLBatchKey) != constants.ResultSuccess {
+			res = constants.ResultError
+			goto Exit
+		}
+
 		// Write out the GL Posting records to the report table.
 		bq.Set(`INSERT #tglPostingRpt (
 					AcctRefKey, BatchKey, CurrID, ExtCmnt, GLAcctKey, JrnlKey, JrnlNo, NatCurrBegBal, PostAmt, PostAmtHC,
@@ -642,12 +783,13 @@ func APIPostBatchlessGLPosting(
 		// -- GL Posting
 		// -- ------------------------
 		if optPostToGL {
-			PostAPIGLPosting(bq, lGLBatchKey, lCompanyID, lModuleNo, lIntegrateWithGL)
+			if PostAPIGLPosting(bq, lGLBatchKey, lCompanyID, lModuleNo, lIntegrateWithGL, loginID) != constants.ResultSuccess {
+				res = constants.ResultError
+				goto Exit
+			}
 		}
 	}
 
 Exit:
-	sm.LogErrors(bq, oSessionID, oSessionID)
-
-	sm.LogicalLockRemoveMultiple(bq)
+	return res, oSessionID
 }
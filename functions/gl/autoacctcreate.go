@@ -0,0 +1,127 @@
+package gl
+
+import (
+	"gosqljobs/invtcommit/functions/constants"
+	"gosqljobs/invtcommit/functions/sm"
+	"strings"
+
+	du "github.com/eaglebush/datautils"
+)
+
+// AutoAcctCreate - Creates a GL account from a valid natural account and valid segment values.
+//
+// The account number is unformatted (no separators) and is split by the lengths of the
+// company's segments in tglSegment.  The first segment is the natural account which must
+// exist in tglNaturalAcct.  All other segment values must exist in tglSegmentCode.
+// The description, posting type and currency restriction of the new account are copied
+// from the natural account and from the first unmasked account of the same natural account.
+// Each account created is logged to tciErrorLog as a warning so it can be reviewed.
+//
+// Input Parameters:
+//    iCompanyID  = [IN: Valid Acuity Company; No Default]
+//    iBatchKey   = [IN: Batch Key used to log the creation or 0]
+//    iSessionID  = [IN: Session used to log the creation]
+//    iUserID     = [IN: User ID]
+//    iGLAcctNo   = [IN: Unformatted GL account number to create]
+//
+// Output Parameters:
+//    Result      = [OUT: return flag indicating outcome of the procedure]
+//           0 = Failure.  General SP Failure.
+//           1 = Successful.  Account created or already exists.
+//           2 = Failure.  Account number contains mask characters or does not match the segment lengths.
+//           3 = Failure.  Natural account does not exist.
+//           4 = Failure.  A segment value does not exist.
+//    GLAcctKey   = [OUT: Key of the new or existing account]
+func AutoAcctCreate(
+	bq *du.BatchQuery,
+	iCompanyID string,
+	iBatchKey int,
+	iSessionID int,
+	iUserID string,
+	iGLAcctNo string) (Result constants.ResultConstant, GLAcctKey int) {
+
+	bq.ScopeName("AutoAcctCreate")
+
+	// -- GL Account {0} was created from natural account {1}.
+	const lGLAcctCreated int = 19250
+
+	qr := bq.Get(`SELECT GLAcctKey FROM tglAccount WITH (NOLOCK) WHERE CompanyID=? AND GLAcctNo=?;`, iCompanyID, iGLAcctNo)
+	if qr.HasData {
+		return constants.ResultSuccess, int(qr.First().ValueInt64Ord(0))
+	}
+
+	if iGLAcctNo == "" || strings.Contains(iGLAcctNo, "*") {
+		return constants.ResultConstant(2), 0
+	}
+
+	// Split the account number into its segments
+	type segment struct {
+		SegmentKey int64
+		Value      string
+	}
+
+	segs := make([]segment, 0)
+	pos := 0
+	qr = bq.Get(`SELECT SegmentKey, Length FROM tglSegment WITH (NOLOCK) WHERE CompanyID=? ORDER BY SegmentNo;`, iCompanyID)
+	if !bq.OK() {
+		return constants.ResultError, 0
+	}
+	for _, v := range qr.Data {
+		l := int(v.ValueInt64("Length"))
+		if pos+l > len(iGLAcctNo) {
+			return constants.ResultConstant(2), 0
+		}
+
+		segs = append(segs, segment{SegmentKey: v.ValueInt64("SegmentKey"), Value: iGLAcctNo[pos : pos+l]})
+		pos += l
+	}
+	if len(segs) == 0 || pos != len(iGLAcctNo) {
+		return constants.ResultConstant(2), 0
+	}
+
+	// The natural account must be valid
+	qr = bq.Get(`SELECT NaturalAcctKey FROM tglNaturalAcct WITH (NOLOCK) WHERE CompanyID=? AND NaturalAcctNo=?;`, iCompanyID, segs[0].Value)
+	if !qr.HasData {
+		return constants.ResultConstant(3), 0
+	}
+	lNaturalAcctKey := qr.First().ValueInt64Ord(0)
+
+	// The other segment values must be valid
+	for _, s := range segs[1:] {
+		qr = bq.Get(`SELECT 1 FROM tglSegmentCode WITH (NOLOCK) WHERE SegmentKey=? AND SegCode=?;`, s.SegmentKey, s.Value)
+		if !qr.HasData {
+			return constants.ResultConstant(4), 0
+		}
+	}
+
+	GLAcctKey = sm.GetNextSurrogateKey(bq, "tglAccount")
+	if GLAcctKey == 0 {
+		return constants.ResultError, 0
+	}
+
+	bq.Set(`INSERT INTO tglAccount (
+				GLAcctKey, CompanyID, GLAcctNo, NaturalAcctKey, Description,
+				Status, PostingType, CurrRestriction, RestrictedCurrID,
+				CreateDate, CreateUserID)
+			SELECT ?, ?, ?, n.NaturalAcctKey, n.Description,
+				1, COALESCE(t.PostingType, 3), COALESCE(t.CurrRestriction, 0), t.RestrictedCurrID,
+				GETDATE(), ?
+			FROM tglNaturalAcct n WITH (NOLOCK)
+				LEFT JOIN (SELECT TOP 1 NaturalAcctKey, PostingType, CurrRestriction, RestrictedCurrID
+						   FROM tglAccount WITH (NOLOCK)
+						   WHERE NaturalAcctKey=? AND CHARINDEX('*', GLAcctNo) = 0 AND Status = 1
+						   ORDER BY GLAcctKey) t ON n.NaturalAcctKey = t.NaturalAcctKey
+			WHERE n.NaturalAcctKey=?;`, GLAcctKey, iCompanyID, iGLAcctNo, iUserID, lNaturalAcctKey, lNaturalAcctKey)
+
+	for _, s := range segs {
+		bq.Set(`INSERT INTO tglAcctSegment (GLAcctKey, SegmentKey, AcctSegValue) VALUES (?, ?, ?);`, GLAcctKey, s.SegmentKey, s.Value)
+	}
+
+	if !bq.OK() {
+		return constants.ResultError, 0
+	}
+
+	sm.LogError(bq, iBatchKey, 0, lGLAcctCreated, iGLAcctNo, segs[0].Value, ``, ``, ``, constants.InterfaceError, constants.Warning, iSessionID, 0, 0, 0, 0)
+
+	return constants.ResultSuccess, GLAcctKey
+}
@@ -1,6 +1,7 @@
 package gl
 
 import (
+	"gosqljobs/invtcommit/functions/dec"
 	"gosqljobs/invtcommit/functions/sm"
 
 	du "github.com/eaglebush/datautils"
@@ -74,8 +75,8 @@ func CalcBeginBalance(
 		lGLAcctKey := v.ValueInt64("GLAcctKey")
 		lAcctCatID := v.ValueInt64("AcctCatID")
 
-		lTotalBal := 0.0
-		lStatQtyBal := 0.0
+		lTotalBal := dec.Zero
+		lStatQtyBal := dec.Zero
 		lTempGLAcctKey := int64(0)
 		lRetainedEarnAcctExists := true
 
@@ -86,8 +87,12 @@ func CalcBeginBalance(
 						WHERE GLAcctKey=?
 							AND FiscYear=?;`, lGLAcctKey, lPriorFiscYear)
 		if qr2.HasData {
-			lTotalBal = qr2.First().ValueFloat64Ord(0)
-			lStatQtyBal = qr2.First().ValueFloat64Ord(1)
+			var err, err2 error
+			lTotalBal, err = dec.Ord(qr2.First(), 0)
+			lStatQtyBal, err2 = dec.Ord(qr2.First(), 1)
+			if err != nil || err2 != nil {
+				return -1
+			}
 		}
 
 		if sm.InInt64Array(&[]int64{1, 2, 3}, lAcctCatID) || (lAcctCatID == 9 && lClearNonFin == false) {
@@ -155,13 +160,12 @@ func CalcBeginBalance(
 
 		for _, v := range qr.Data {
 
-			lGLAcctNo := v.ValueString("GLAcctNo")
 			lGLAcctKey := v.ValueInt64("GLAcctKey")
 			lAcctCatID := v.ValueInt64("AcctCatID")
 			lCurrID := v.ValueString("CurrID")
 
-			lTotalBalHC := 0.0
-			lTotalBalNC := 0.0
+			lTotalBalHC := dec.Zero
+			lTotalBalNC := dec.Zero
 
 			qr2 := bq.Get(`SELECT 	COALESCE(SUM(BegBalHC),0) + COALESCE(SUM(DebitAmtHC),0) - COALESCE(SUM(CreditAmtHC),0),
 									COALESCE(SUM(BegBalNC),0) + COALESCE(SUM(DebitAmtNC),0) - COALESCE(SUM(CreditAmtNC),0)
@@ -170,8 +174,12 @@ func CalcBeginBalance(
 								AND FiscYear=?
 								AND CurrID=?;`, lGLAcctKey, lPriorFiscYear, lCurrID)
 			if qr2.HasData {
-				lTotalBalHC = qr2.First().ValueFloat64Ord(0)
-				lTotalBalNC = qr2.First().ValueFloat64Ord(1)
+				var err, err2 error
+				lTotalBalHC, err = dec.Ord(qr2.First(), 0)
+				lTotalBalNC, err2 = dec.Ord(qr2.First(), 1)
+				if err != nil || err2 != nil {
+					return -1
+				}
 			}
 
 			if sm.InInt64Array(&[]int64{1, 2, 3}, lAcctCatID) || (lAcctCatID == 9 && lClearNonFin == false) {
@@ -3,6 +3,7 @@ package gl
 import (
 	"gosqljobs/invtcommit/functions/bat"
 	"gosqljobs/invtcommit/functions/constants"
+	"strings"
 
 	du "github.com/eaglebush/datautils"
 )
@@ -17,7 +18,7 @@ import (
 //                     	CompanyID         VARCHAR(3) NOT NULL,
 //                     	TranID            VARCHAR(13) NOT NULL, -- TranID used for reporting.
 //                     	TranType          INTEGER NOT NULL,  -- Supported transaction types.
-//                     	TranKey           INTEGER NOT NULL,  -- Represents the TranKey of the transactions to post. (ie. ShipKey for SO)
+//                     	TranKey           INTEGER NOT NULL,  -- Represents the TranKey of the transactions to post. (ie. ShipKey for SO, InvtTranKey for IM, RcvrKey for PO)
 //                     	GLBatchKey        INTEGER NOT NULL,  -- Represents the GL batch to post the transactions.
 //                     	PostStatus        INTEGER DEFAULT 0) -- Status use to determine progress of each transaction.
 //
@@ -57,6 +58,9 @@ func CreateBatchlessGLPostingBatch(
 			idt := v.ValueTime("InvcDate")
 
 			res, batchKey, _ := bat.GetNextBatch(bq, cid, mod, bt, iUserID, iBatchCmnt, pdt, 0, &idt)
+			if res != constants.BatchReturnValid {
+				return constants.ResultError
+			}
 
 			bq.Set(`UPDATE tmp
 					SET tmp.GLBatchKey=?
@@ -72,6 +76,75 @@ func CreateBatchlessGLPostingBatch(
 		}
 	}
 
+	// IM transactions are posted as of their transaction date
+	qr = bq.Get(`SELECT DISTINCT tmp.CompanyID, btt.BatchType, it.TranDate AS PostDate, bt.ModuleNo
+				  FROM #tciTransToPost tmp
+					JOIN tciBatchTranType btt WITH (NOLOCK) ON tmp.TranType = btt.TranType
+					JOIN tciBatchType bt WITH (NOLOCK) ON btt.BatchType = bt.BatchType
+					JOIN timInvtTran it WITH (NOLOCK) ON tmp.TranKey = it.InvtTranKey
+				WHERE COALESCE(tmp.GLBatchKey, 0) = 0
+					AND tmp.PostStatus=0
+					AND btt.BatchType IN (?,?)
+					AND tmp.TranType IN (?`+strings.Repeat(`,?`, len(constants.IMCommitTranTypes)-1)+`);`,
+		append([]interface{}{constants.BatchTranTypeIMProcInvTran, constants.BatchTranTypeIMProcKitAss}, constants.IMCommitTranTypes...)...)
+	for _, v := range qr.Data {
+		cid := v.ValueString("CompanyID")
+		mod := constants.ModuleConstant(v.ValueInt64("ModuleNo"))
+		bt := int(v.ValueInt64("BatchType"))
+		pdt := v.ValueTime("PostDate")
+
+		res, batchKey, _ := bat.GetNextBatch(bq, cid, mod, bt, iUserID, iBatchCmnt, pdt, 0, &pdt)
+		if res != constants.BatchReturnValid {
+			return constants.ResultError
+		}
+
+		bq.ScopeName("CreateBatchlessGLPostingBatch")
+
+		bq.Set(`UPDATE tmp
+				SET tmp.GLBatchKey=?
+				FROM #tciTransToPost tmp
+					JOIN tciBatchTranType btt WITH (NOLOCK) ON tmp.TranType = btt.TranType
+					JOIN timInvtTran it WITH (NOLOCK) ON tmp.TranKey = it.InvtTranKey AND it.CompanyID=? AND it.TranDate=?
+				WHERE btt.BatchType=? AND COALESCE(tmp.GLBatchKey,0)=0
+					AND tmp.TranType IN (?`+strings.Repeat(`,?`, len(constants.IMCommitTranTypes)-1)+`);`,
+			append([]interface{}{batchKey, cid, pdt, bt}, constants.IMCommitTranTypes...)...)
+	}
+
+	// PO receivers are posted as of their post date
+	pott := `?` + strings.Repeat(`,?`, len(constants.POCommitTranTypes)-1)
+	qr = bq.Get(`SELECT DISTINCT tmp.CompanyID, btt.BatchType, r.PostDate, bt.ModuleNo
+				  FROM #tciTransToPost tmp
+					JOIN tciBatchTranType btt WITH (NOLOCK) ON tmp.TranType = btt.TranType
+					JOIN tciBatchType bt WITH (NOLOCK) ON btt.BatchType = bt.BatchType
+					JOIN tpoReceiver r WITH (NOLOCK) ON tmp.TranKey = r.RcvrKey
+				WHERE COALESCE(tmp.GLBatchKey, 0) = 0
+					AND tmp.PostStatus=0
+					AND btt.BatchType IN (?,?)
+					AND tmp.TranType IN (`+pott+`);`,
+		append([]interface{}{constants.BatchTranTypePORcptGoods, constants.BatchTranTypePORtrnGoods}, constants.POCommitTranTypes...)...)
+	for _, v := range qr.Data {
+		cid := v.ValueString("CompanyID")
+		mod := constants.ModuleConstant(v.ValueInt64("ModuleNo"))
+		bt := int(v.ValueInt64("BatchType"))
+		pdt := v.ValueTime("PostDate")
+
+		res, batchKey, _ := bat.GetNextBatch(bq, cid, mod, bt, iUserID, iBatchCmnt, pdt, 0, &pdt)
+		if res != constants.BatchReturnValid {
+			return constants.ResultError
+		}
+
+		bq.ScopeName("CreateBatchlessGLPostingBatch")
+
+		bq.Set(`UPDATE tmp
+				SET tmp.GLBatchKey=?
+				FROM #tciTransToPost tmp
+					JOIN tciBatchTranType btt WITH (NOLOCK) ON tmp.TranType = btt.TranType
+					JOIN tpoReceiver r WITH (NOLOCK) ON tmp.TranKey = r.RcvrKey AND r.CompanyID=? AND r.PostDate=?
+				WHERE btt.BatchType=? AND COALESCE(tmp.GLBatchKey,0)=0
+					AND tmp.TranType IN (`+pott+`);`,
+			append([]interface{}{batchKey, cid, pdt, bt}, constants.POCommitTranTypes...)...)
+	}
+
 	if !bq.OK() {
 		return constants.ResultError
 	}
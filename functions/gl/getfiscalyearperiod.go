@@ -194,7 +194,7 @@ func GetFiscalYearPeriod(
 			lyr = lyr - 1
 
 			diff := lEndYearDate.Sub(lStartYearDate).Hours()
-			lNoOfDaysinFiscYear := diff * 24.0
+			lNoOfDaysinFiscYear = diff * 24.0
 		}
 
 		if lIntFiscalYear != 0 {
@@ -207,7 +207,6 @@ func GetFiscalYearPeriod(
 
 		var lNextEndDate time.Time
 		lOnceCreated := false
-		lLastPerEndDate := lEndYearDate
 
 		qr = bq.Get(`SELECT FiscPer, StartDate, EndDate, 
 								DATEDIFF(day,StartDate,EndDate) NoOfDays
@@ -242,8 +241,6 @@ func GetFiscalYearPeriod(
 
 			lNextStartDate, lNextEndDate := sm.GetNextYearPeriod(lStartDate, int(lNoOfDays), lEndDate, lMethod)
 
-			lLastPerEndDate = lNextEndDate
-
 			if lPriorYearCreation && lFiscPer == lPeriods {
 				lNextEndDate = lStartYearDate.Add(time.Hour * -1)
 			}
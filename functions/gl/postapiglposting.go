@@ -12,7 +12,8 @@ func PostAPIGLPosting(
 	iBatchKey int,
 	iCompanyID string,
 	iModuleNo int,
-	iIntegrateWithGL bool) constants.ResultConstant {
+	iIntegrateWithGL bool,
+	iUserID string) constants.ResultConstant {
 
 	bq.ScopeName("PostAPIGLPosting")
 
@@ -23,7 +24,7 @@ func PostAPIGLPosting(
 		return constants.ResultSuccess
 	}
 
-	res := SetAPIGLPosting(bq, iCompanyID, iBatchKey, iIntegrateWithGL)
+	res := SetAPIGLPosting(bq, iCompanyID, iBatchKey, iIntegrateWithGL, iUserID)
 	if res != constants.ResultSuccess {
 		return constants.ResultError
 	}
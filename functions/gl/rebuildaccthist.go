@@ -0,0 +1,516 @@
+package gl
+
+import (
+	"gosqljobs/invtcommit/functions/constants"
+	"gosqljobs/invtcommit/functions/dec"
+
+	du "github.com/eaglebush/datautils"
+)
+
+// AcctHistDiff - a history amount that does not agree with tglTransaction
+type AcctHistDiff struct {
+	Table      string      `json:"table"`
+	GLAcctNo   string      `json:"glacctno"`
+	FiscYear   string      `json:"fiscyear"`
+	FiscPer    int         `json:"fiscper"`
+	CurrID     string      `json:"currid,omitempty"`
+	AcctRefKey int         `json:"acctrefkey,omitempty"`
+	Column     string      `json:"column"`
+	StoredAmt  dec.Decimal `json:"storedamt"`
+	RebuiltAmt dec.Decimal `json:"rebuiltamt"`
+}
+
+// RebuildAcctHist - Recomputes the period activity and beginning balances of tglAcctHist,
+//                   tglAcctHistCurr and tglAcctHistAcctRef from tglTransaction for a range
+//                   of fiscal years and compares them with the stored history.  This is used
+//                   when the history tables drifted from tglTransaction, e.g. batches posted
+//                   before SetAPIGLPosting wrote the transactions and the history in a
+//                   single transaction, or history edited outside of the posting.
+//
+//                   The beginning balances of the first fiscal year of the range are taken
+//                   as stored.  Those of the following years are rolled from the rebuilt
+//                   ending balances of the prior year the same way CalcBeginBalance does.
+//                   Account reference history has no beginning balances.
+//
+//                   Corrections are only written when iApply is true.  All corrections
+//                   are written in a single transaction.
+// ---------------------------------------------------------------------
+// Input Parameters:
+//    iCompanyID      Company ID
+//    iFromFiscYear   Starting fiscal year
+//    iToFiscYear     Ending fiscal year
+//    iApply          Write the corrections to the history tables
+// Output Parameters:
+//    Result          ReturnValue:
+//                       0 - Unexpected error
+//                       1 - History agrees with tglTransaction (or was corrected)
+//                       2 - Differences found and not applied
+//                       3 - Fiscal years not found
+//    Diffs           Differences between the stored and rebuilt history
+// ---------------------------------------------------------------------
+func RebuildAcctHist(
+	bq *du.BatchQuery,
+	iCompanyID string,
+	iFromFiscYear string,
+	iToFiscYear string,
+	iApply bool) (Result constants.ResultConstant, Diffs []AcctHistDiff) {
+
+	bq.ScopeName("RebuildAcctHist")
+
+	lFiscYears := make([]string, 0)
+	qr := bq.Get(`SELECT FiscYear FROM tglFiscalYear WITH (NOLOCK)
+				  WHERE CompanyID=? AND FiscYear BETWEEN ? AND ?
+				  ORDER BY FiscYear;`, iCompanyID, iFromFiscYear, iToFiscYear)
+	for _, v := range qr.Data {
+		lFiscYears = append(lFiscYears, v.ValueStringOrd(0))
+	}
+	if !bq.OK() {
+		return constants.ResultError, nil
+	}
+	if len(lFiscYears) == 0 {
+		return constants.ResultConstant(3), nil
+	}
+
+	lUseMultCurr := false
+	lClearNonFin := false
+	qr = bq.Get(`SELECT UseMultCurr, ClearNonFin FROM tglOptions WITH (NOLOCK) WHERE CompanyID=?;`, iCompanyID)
+	if qr.HasData {
+		lUseMultCurr = qr.First().ValueBool("UseMultCurr")
+		lClearNonFin = qr.First().ValueBool("ClearNonFin")
+	}
+
+	accts, ok := getGLAcctInfo(bq, iCompanyID)
+	if !ok {
+		return constants.ResultError, nil
+	}
+
+	bq.Set(`IF OBJECT_ID('tempdb..#tglAcctHistWrk') IS NOT NULL
+				TRUNCATE TABLE #tglAcctHistWrk
+			ELSE
+				CREATE TABLE #tglAcctHistWrk (
+					GLAcctKey  INTEGER NOT NULL,
+					FiscYear   VARCHAR(5) NOT NULL,
+					FiscPer    SMALLINT NOT NULL,
+					BegBal     DECIMAL(15,3) NOT NULL DEFAULT 0,
+					DebitAmt   DECIMAL(15,3) NOT NULL DEFAULT 0,
+					CreditAmt  DECIMAL(15,3) NOT NULL DEFAULT 0,
+					StatBegBal DECIMAL(16,8) NOT NULL DEFAULT 0,
+					StatQty    DECIMAL(16,8) NOT NULL DEFAULT 0,
+					PRIMARY KEY (GLAcctKey, FiscYear, FiscPer)
+				);`)
+
+	bq.Set(`IF OBJECT_ID('tempdb..#tglAcctHistCurrWrk') IS NOT NULL
+				TRUNCATE TABLE #tglAcctHistCurrWrk
+			ELSE
+				CREATE TABLE #tglAcctHistCurrWrk (
+					GLAcctKey   INTEGER NOT NULL,
+					CurrID      VARCHAR(3) NOT NULL,
+					FiscYear    VARCHAR(5) NOT NULL,
+					FiscPer     SMALLINT NOT NULL,
+					BegBalHC    DECIMAL(15,3) NOT NULL DEFAULT 0,
+					BegBalNC    DECIMAL(15,3) NOT NULL DEFAULT 0,
+					DebitAmtHC  DECIMAL(15,3) NOT NULL DEFAULT 0,
+					CreditAmtHC DECIMAL(15,3) NOT NULL DEFAULT 0,
+					DebitAmtNC  DECIMAL(15,3) NOT NULL DEFAULT 0,
+					CreditAmtNC DECIMAL(15,3) NOT NULL DEFAULT 0,
+					PRIMARY KEY (GLAcctKey, CurrID, FiscYear, FiscPer)
+				);`)
+
+	bq.Set(`IF OBJECT_ID('tempdb..#tglAcctHistAcctRefWrk') IS NOT NULL
+				TRUNCATE TABLE #tglAcctHistAcctRefWrk
+			ELSE
+				CREATE TABLE #tglAcctHistAcctRefWrk (
+					GLAcctKey  INTEGER NOT NULL,
+					AcctRefKey INTEGER NOT NULL,
+					FiscYear   VARCHAR(5) NOT NULL,
+					FiscPer    SMALLINT NOT NULL,
+					DebitAmt   DECIMAL(15,3) NOT NULL DEFAULT 0,
+					CreditAmt  DECIMAL(15,3) NOT NULL DEFAULT 0,
+					PRIMARY KEY (GLAcctKey, AcctRefKey, FiscYear, FiscPer)
+				);`)
+	if !bq.OK() {
+		return constants.ResultError, nil
+	}
+
+	// -- Period activity from tglTransaction
+	bq.Set(`INSERT INTO #tglAcctHistWrk (GLAcctKey, FiscYear, FiscPer, DebitAmt, CreditAmt, StatQty)
+			SELECT t.GLAcctKey, t.FiscYear, t.FiscPer,
+				SUM(CASE WHEN t.PostAmtHC > 0 THEN t.PostAmtHC ELSE 0 END),
+				SUM(CASE WHEN t.PostAmtHC < 0 THEN -t.PostAmtHC ELSE 0 END),
+				SUM(COALESCE(t.PostQty, 0))
+			FROM tglTransaction t WITH (NOLOCK)
+				INNER JOIN tglAccount a WITH (NOLOCK) ON t.GLAcctKey = a.GLAcctKey
+			WHERE a.CompanyID=? AND t.FiscYear BETWEEN ? AND ?
+			GROUP BY t.GLAcctKey, t.FiscYear, t.FiscPer;`, iCompanyID, iFromFiscYear, iToFiscYear)
+
+	if lUseMultCurr {
+		bq.Set(`INSERT INTO #tglAcctHistCurrWrk (GLAcctKey, CurrID, FiscYear, FiscPer, DebitAmtHC, CreditAmtHC, DebitAmtNC, CreditAmtNC)
+				SELECT t.GLAcctKey, t.CurrID, t.FiscYear, t.FiscPer,
+					SUM(CASE WHEN t.PostAmtHC > 0 THEN t.PostAmtHC ELSE 0 END),
+					SUM(CASE WHEN t.PostAmtHC < 0 THEN -t.PostAmtHC ELSE 0 END),
+					SUM(CASE WHEN t.PostAmt > 0 THEN t.PostAmt ELSE 0 END),
+					SUM(CASE WHEN t.PostAmt < 0 THEN -t.PostAmt ELSE 0 END)
+				FROM tglTransaction t WITH (NOLOCK)
+					INNER JOIN tglAccount a WITH (NOLOCK) ON t.GLAcctKey = a.GLAcctKey
+					INNER JOIN tsmCompany c WITH (NOLOCK) ON a.CompanyID = c.CompanyID
+				WHERE a.CompanyID=? AND t.FiscYear BETWEEN ? AND ?
+					AND t.CurrID <> c.CurrID
+				GROUP BY t.GLAcctKey, t.CurrID, t.FiscYear, t.FiscPer;`, iCompanyID, iFromFiscYear, iToFiscYear)
+	}
+
+	bq.Set(`INSERT INTO #tglAcctHistAcctRefWrk (GLAcctKey, AcctRefKey, FiscYear, FiscPer, DebitAmt, CreditAmt)
+			SELECT t.GLAcctKey, t.AcctRefKey, t.FiscYear, t.FiscPer,
+				SUM(CASE WHEN t.PostAmtHC > 0 THEN t.PostAmtHC ELSE 0 END),
+				SUM(CASE WHEN t.PostAmtHC < 0 THEN -t.PostAmtHC ELSE 0 END)
+			FROM tglTransaction t WITH (NOLOCK)
+				INNER JOIN tglAccount a WITH (NOLOCK) ON t.GLAcctKey = a.GLAcctKey
+			WHERE a.CompanyID=? AND t.FiscYear BETWEEN ? AND ?
+				AND t.AcctRefKey IS NOT NULL
+			GROUP BY t.GLAcctKey, t.AcctRefKey, t.FiscYear, t.FiscPer;`, iCompanyID, iFromFiscYear, iToFiscYear)
+	if !bq.OK() {
+		return constants.ResultError, nil
+	}
+
+	// -- Beginning balances of the first year are kept as stored
+	bq.Set(`INSERT INTO #tglAcctHistWrk (GLAcctKey, FiscYear, FiscPer)
+			SELECT h.GLAcctKey, h.FiscYear, 1
+			FROM tglAcctHist h WITH (NOLOCK)
+				INNER JOIN tglAccount a WITH (NOLOCK) ON h.GLAcctKey = a.GLAcctKey
+			WHERE a.CompanyID=? AND h.FiscYear=? AND h.FiscPer=1
+				AND NOT EXISTS (SELECT 1 FROM #tglAcctHistWrk w
+								WHERE w.GLAcctKey=h.GLAcctKey AND w.FiscYear=h.FiscYear AND w.FiscPer=1);`, iCompanyID, lFiscYears[0])
+
+	bq.Set(`UPDATE w
+			SET w.BegBal = h.BegBal,
+				w.StatBegBal = h.StatBegBal
+			FROM #tglAcctHistWrk w
+				INNER JOIN tglAcctHist h WITH (NOLOCK) ON w.GLAcctKey = h.GLAcctKey
+					AND w.FiscYear = h.FiscYear AND w.FiscPer = h.FiscPer
+			WHERE w.FiscYear=? AND w.FiscPer=1;`, lFiscYears[0])
+
+	if lUseMultCurr {
+		bq.Set(`INSERT INTO #tglAcctHistCurrWrk (GLAcctKey, CurrID, FiscYear, FiscPer, BegBalHC, BegBalNC)
+				SELECT h.GLAcctKey, h.CurrID, h.FiscYear, 1, h.BegBalHC, h.BegBalNC
+				FROM tglAcctHistCurr h WITH (NOLOCK)
+					INNER JOIN tglAccount a WITH (NOLOCK) ON h.GLAcctKey = a.GLAcctKey
+				WHERE a.CompanyID=? AND h.FiscYear=? AND h.FiscPer=1
+					AND NOT EXISTS (SELECT 1 FROM #tglAcctHistCurrWrk w
+									WHERE w.GLAcctKey=h.GLAcctKey AND w.CurrID=h.CurrID AND w.FiscYear=h.FiscYear AND w.FiscPer=1);`, iCompanyID, lFiscYears[0])
+
+		bq.Set(`UPDATE w
+				SET w.BegBalHC = h.BegBalHC,
+					w.BegBalNC = h.BegBalNC
+				FROM #tglAcctHistCurrWrk w
+					INNER JOIN tglAcctHistCurr h WITH (NOLOCK) ON w.GLAcctKey = h.GLAcctKey AND w.CurrID = h.CurrID
+						AND w.FiscYear = h.FiscYear AND w.FiscPer = h.FiscPer
+				WHERE w.FiscYear=? AND w.FiscPer=1;`, lFiscYears[0])
+	}
+	if !bq.OK() {
+		return constants.ResultError, nil
+	}
+
+	// -- Roll the rebuilt ending balances into the beginning balances of the following years
+	for i := 1; i < len(lFiscYears); i++ {
+		lPriorFiscYear := lFiscYears[i-1]
+		lFiscYear := lFiscYears[i]
+
+		endBal := make(map[int64]dec.Decimal)
+		endStat := make(map[int64]dec.Decimal)
+		qr = bq.Get(`SELECT GLAcctKey, SUM(BegBal) + SUM(DebitAmt) - SUM(CreditAmt), SUM(StatBegBal) + SUM(StatQty)
+					 FROM #tglAcctHistWrk
+					 WHERE FiscYear=?
+					 GROUP BY GLAcctKey;`, lPriorFiscYear)
+		for _, v := range qr.Data {
+			bal, err := dec.Ord(v, 1)
+			if err != nil {
+				return constants.ResultError, nil
+			}
+			stat, err := dec.Ord(v, 2)
+			if err != nil {
+				return constants.ResultError, nil
+			}
+			endBal[v.ValueInt64Ord(0)] = bal
+			endStat[v.ValueInt64Ord(0)] = stat
+		}
+
+		begBal, ok := closeYearBalances(bq, iCompanyID, endBal, accts)
+		if !ok {
+			return constants.ResultError, nil
+		}
+		begStat, ok := closeYearBalances(bq, iCompanyID, endStat, accts)
+		if !ok {
+			return constants.ResultError, nil
+		}
+
+		for k := range begStat {
+			if _, ok := begBal[k]; !ok {
+				begBal[k] = dec.Zero
+			}
+		}
+
+		for k, bal := range begBal {
+			bq.Set(`IF NOT EXISTS (SELECT 1 FROM #tglAcctHistWrk WHERE GLAcctKey=? AND FiscYear=? AND FiscPer=1)
+						INSERT INTO #tglAcctHistWrk (GLAcctKey, FiscYear, FiscPer) VALUES (?, ?, 1);`,
+				k, lFiscYear, k, lFiscYear)
+			bq.Set(`UPDATE #tglAcctHistWrk
+					SET BegBal=?, StatBegBal=?
+					WHERE GLAcctKey=? AND FiscYear=? AND FiscPer=1;`, bal, begStat[k], k, lFiscYear)
+		}
+
+		// Only balance sheet accounts carry their currency balances forward
+		if lUseMultCurr {
+			bq.Set(`INSERT INTO #tglAcctHistCurrWrk (GLAcctKey, CurrID, FiscYear, FiscPer)
+					SELECT DISTINCT w.GLAcctKey, w.CurrID, ?, 1
+					FROM #tglAcctHistCurrWrk w
+					WHERE w.FiscYear=?
+						AND NOT EXISTS (SELECT 1 FROM #tglAcctHistCurrWrk n
+										WHERE n.GLAcctKey=w.GLAcctKey AND n.CurrID=w.CurrID AND n.FiscYear=? AND n.FiscPer=1);`,
+				lFiscYear, lPriorFiscYear, lFiscYear)
+
+			bq.Set(`UPDATE n
+					SET n.BegBalHC = p.EndBalHC,
+						n.BegBalNC = p.EndBalNC
+					FROM #tglAcctHistCurrWrk n
+						INNER JOIN (SELECT w.GLAcctKey, w.CurrID,
+									SUM(w.BegBalHC) + SUM(w.DebitAmtHC) - SUM(w.CreditAmtHC) EndBalHC,
+									SUM(w.BegBalNC) + SUM(w.DebitAmtNC) - SUM(w.CreditAmtNC) EndBalNC
+								FROM #tglAcctHistCurrWrk w
+									INNER JOIN tglAccount a WITH (NOLOCK) ON (w.GLAcctKey = a.GLAcctKey)
+									INNER JOIN tglNaturalAcct b WITH (NOLOCK) ON (a.NaturalAcctKey = b.NaturalAcctKey)
+									INNER JOIN tglAcctType c WITH (NOLOCK) ON (b.AcctTypeKey = c.AcctTypeKey)
+									INNER JOIN tglAcctCategory d WITH (NOLOCK) ON (c.AcctCategoryKey = d.AcctCategoryKey)
+								WHERE w.FiscYear=?
+									AND (d.AcctCatID IN (1,2,3) OR (d.AcctCatID = 9 AND ? = 0))
+								GROUP BY w.GLAcctKey, w.CurrID) p ON n.GLAcctKey = p.GLAcctKey AND n.CurrID = p.CurrID
+					WHERE n.FiscYear=? AND n.FiscPer=1;`, lPriorFiscYear, lClearNonFin, lFiscYear)
+		}
+
+		if !bq.OK() {
+			return constants.ResultError, nil
+		}
+	}
+
+	// -- Compare with the stored history
+	qr = bq.Get(`SELECT a.GLAcctNo, COALESCE(w.FiscYear, h.FiscYear) FiscYear, COALESCE(w.FiscPer, h.FiscPer) FiscPer,
+						COALESCE(h.BegBal, 0) sBegBal, COALESCE(w.BegBal, 0) wBegBal,
+						COALESCE(h.DebitAmt, 0) sDebitAmt, COALESCE(w.DebitAmt, 0) wDebitAmt,
+						COALESCE(h.CreditAmt, 0) sCreditAmt, COALESCE(w.CreditAmt, 0) wCreditAmt,
+						COALESCE(h.StatBegBal, 0) sStatBegBal, COALESCE(w.StatBegBal, 0) wStatBegBal,
+						COALESCE(h.StatQty, 0) sStatQty, COALESCE(w.StatQty, 0) wStatQty
+				 FROM #tglAcctHistWrk w
+					FULL OUTER JOIN (SELECT h.* FROM tglAcctHist h WITH (NOLOCK)
+										INNER JOIN tglAccount a WITH (NOLOCK) ON h.GLAcctKey = a.GLAcctKey
+									 WHERE a.CompanyID=? AND h.FiscYear BETWEEN ? AND ?) h
+						ON w.GLAcctKey = h.GLAcctKey AND w.FiscYear = h.FiscYear AND w.FiscPer = h.FiscPer
+					INNER JOIN tglAccount a WITH (NOLOCK) ON a.GLAcctKey = COALESCE(w.GLAcctKey, h.GLAcctKey)
+				 ORDER BY a.GLAcctNo, 2, 3;`, iCompanyID, iFromFiscYear, iToFiscYear)
+	if !bq.OK() {
+		return constants.ResultError, nil
+	}
+	for _, v := range qr.Data {
+		for _, c := range []string{"BegBal", "DebitAmt", "CreditAmt", "StatBegBal", "StatQty"} {
+			sw, err := dec.Cols(v, "s"+c, "w"+c)
+			if err != nil {
+				return constants.ResultError, nil
+			}
+			s, w := sw[0], sw[1]
+			if s.Equal(w) {
+				continue
+			}
+
+			Diffs = append(Diffs, AcctHistDiff{
+				Table:      "tglAcctHist",
+				GLAcctNo:   v.ValueString("GLAcctNo"),
+				FiscYear:   v.ValueString("FiscYear"),
+				FiscPer:    int(v.ValueInt64("FiscPer")),
+				Column:     c,
+				StoredAmt:  s,
+				RebuiltAmt: w,
+			})
+		}
+	}
+
+	if lUseMultCurr {
+		qr = bq.Get(`SELECT a.GLAcctNo, COALESCE(w.CurrID, h.CurrID) CurrID,
+							COALESCE(w.FiscYear, h.FiscYear) FiscYear, COALESCE(w.FiscPer, h.FiscPer) FiscPer,
+							COALESCE(h.BegBalHC, 0) sBegBalHC, COALESCE(w.BegBalHC, 0) wBegBalHC,
+							COALESCE(h.BegBalNC, 0) sBegBalNC, COALESCE(w.BegBalNC, 0) wBegBalNC,
+							COALESCE(h.DebitAmtHC, 0) sDebitAmtHC, COALESCE(w.DebitAmtHC, 0) wDebitAmtHC,
+							COALESCE(h.CreditAmtHC, 0) sCreditAmtHC, COALESCE(w.CreditAmtHC, 0) wCreditAmtHC,
+							COALESCE(h.DebitAmtNC, 0) sDebitAmtNC, COALESCE(w.DebitAmtNC, 0) wDebitAmtNC,
+							COALESCE(h.CreditAmtNC, 0) sCreditAmtNC, COALESCE(w.CreditAmtNC, 0) wCreditAmtNC
+					 FROM #tglAcctHistCurrWrk w
+						FULL OUTER JOIN (SELECT h.* FROM tglAcctHistCurr h WITH (NOLOCK)
+											INNER JOIN tglAccount a WITH (NOLOCK) ON h.GLAcctKey = a.GLAcctKey
+										 WHERE a.CompanyID=? AND h.FiscYear BETWEEN ? AND ?) h
+							ON w.GLAcctKey = h.GLAcctKey AND w.CurrID = h.CurrID AND w.FiscYear = h.FiscYear AND w.FiscPer = h.FiscPer
+						INNER JOIN tglAccount a WITH (NOLOCK) ON a.GLAcctKey = COALESCE(w.GLAcctKey, h.GLAcctKey)
+					 ORDER BY a.GLAcctNo, 2, 3, 4;`, iCompanyID, iFromFiscYear, iToFiscYear)
+		if !bq.OK() {
+			return constants.ResultError, nil
+		}
+		for _, v := range qr.Data {
+			for _, c := range []string{"BegBalHC", "BegBalNC", "DebitAmtHC", "CreditAmtHC", "DebitAmtNC", "CreditAmtNC"} {
+				sw, err := dec.Cols(v, "s"+c, "w"+c)
+				if err != nil {
+					return constants.ResultError, nil
+				}
+				s, w := sw[0], sw[1]
+				if s.Equal(w) {
+					continue
+				}
+
+				Diffs = append(Diffs, AcctHistDiff{
+					Table:      "tglAcctHistCurr",
+					GLAcctNo:   v.ValueString("GLAcctNo"),
+					FiscYear:   v.ValueString("FiscYear"),
+					FiscPer:    int(v.ValueInt64("FiscPer")),
+					CurrID:     v.ValueString("CurrID"),
+					Column:     c,
+					StoredAmt:  s,
+					RebuiltAmt: w,
+				})
+			}
+		}
+	}
+
+	qr = bq.Get(`SELECT a.GLAcctNo, COALESCE(w.AcctRefKey, h.AcctRefKey) AcctRefKey,
+						COALESCE(w.FiscYear, h.FiscYear) FiscYear, COALESCE(w.FiscPer, h.FiscPer) FiscPer,
+						COALESCE(h.DebitAmt, 0) sDebitAmt, COALESCE(w.DebitAmt, 0) wDebitAmt,
+						COALESCE(h.CreditAmt, 0) sCreditAmt, COALESCE(w.CreditAmt, 0) wCreditAmt
+				 FROM #tglAcctHistAcctRefWrk w
+					FULL OUTER JOIN (SELECT h.* FROM tglAcctHistAcctRef h WITH (NOLOCK)
+										INNER JOIN tglAccount a WITH (NOLOCK) ON h.GLAcctKey = a.GLAcctKey
+									 WHERE a.CompanyID=? AND h.FiscYear BETWEEN ? AND ?) h
+						ON w.GLAcctKey = h.GLAcctKey AND w.AcctRefKey = h.AcctRefKey AND w.FiscYear = h.FiscYear AND w.FiscPer = h.FiscPer
+					INNER JOIN tglAccount a WITH (NOLOCK) ON a.GLAcctKey = COALESCE(w.GLAcctKey, h.GLAcctKey)
+				 ORDER BY a.GLAcctNo, 2, 3, 4;`, iCompanyID, iFromFiscYear, iToFiscYear)
+	if !bq.OK() {
+		return constants.ResultError, nil
+	}
+	for _, v := range qr.Data {
+		for _, c := range []string{"DebitAmt", "CreditAmt"} {
+			sw, err := dec.Cols(v, "s"+c, "w"+c)
+			if err != nil {
+				return constants.ResultError, nil
+			}
+			s, w := sw[0], sw[1]
+			if s.Equal(w) {
+				continue
+			}
+
+			Diffs = append(Diffs, AcctHistDiff{
+				Table:      "tglAcctHistAcctRef",
+				GLAcctNo:   v.ValueString("GLAcctNo"),
+				FiscYear:   v.ValueString("FiscYear"),
+				FiscPer:    int(v.ValueInt64("FiscPer")),
+				AcctRefKey: int(v.ValueInt64("AcctRefKey")),
+				Column:     c,
+				StoredAmt:  s,
+				RebuiltAmt: w,
+			})
+		}
+	}
+
+	if len(Diffs) == 0 {
+		return constants.ResultSuccess, Diffs
+	}
+
+	if !iApply {
+		return constants.ResultFail, Diffs
+	}
+
+	// -- Write the corrections
+	bq.Set(`BEGIN TRAN;`)
+
+	bq.Set(`UPDATE h
+			SET h.BegBal = w.BegBal,
+				h.DebitAmt = w.DebitAmt,
+				h.CreditAmt = w.CreditAmt,
+				h.StatBegBal = w.StatBegBal,
+				h.StatQty = w.StatQty,
+				h.UpdateCounter = h.UpdateCounter + 1
+			FROM tglAcctHist h
+				INNER JOIN #tglAcctHistWrk w ON h.GLAcctKey = w.GLAcctKey AND h.FiscYear = w.FiscYear AND h.FiscPer = w.FiscPer
+			WHERE h.BegBal <> w.BegBal OR h.DebitAmt <> w.DebitAmt OR h.CreditAmt <> w.CreditAmt
+				OR h.StatBegBal <> w.StatBegBal OR h.StatQty <> w.StatQty;`)
+
+	bq.Set(`INSERT INTO tglAcctHist (BegBal, CreditAmt, DebitAmt, FiscPer, FiscYear, GLAcctKey, StatBegBal, StatQty, UpdateCounter)
+			SELECT w.BegBal, w.CreditAmt, w.DebitAmt, w.FiscPer, w.FiscYear, w.GLAcctKey, w.StatBegBal, w.StatQty, 1
+			FROM #tglAcctHistWrk w
+			WHERE NOT EXISTS (SELECT 1 FROM tglAcctHist h WITH (NOLOCK)
+							  WHERE h.GLAcctKey = w.GLAcctKey AND h.FiscYear = w.FiscYear AND h.FiscPer = w.FiscPer);`)
+
+	bq.Set(`UPDATE h
+			SET h.BegBal = 0, h.DebitAmt = 0, h.CreditAmt = 0, h.StatBegBal = 0, h.StatQty = 0,
+				h.UpdateCounter = h.UpdateCounter + 1
+			FROM tglAcctHist h
+				INNER JOIN tglAccount a WITH (NOLOCK) ON h.GLAcctKey = a.GLAcctKey
+			WHERE a.CompanyID=? AND h.FiscYear BETWEEN ? AND ?
+				AND (h.BegBal <> 0 OR h.DebitAmt <> 0 OR h.CreditAmt <> 0 OR h.StatBegBal <> 0 OR h.StatQty <> 0)
+				AND NOT EXISTS (SELECT 1 FROM #tglAcctHistWrk w
+								WHERE h.GLAcctKey = w.GLAcctKey AND h.FiscYear = w.FiscYear AND h.FiscPer = w.FiscPer);`,
+		iCompanyID, iFromFiscYear, iToFiscYear)
+
+	if lUseMultCurr {
+		bq.Set(`UPDATE h
+				SET h.BegBalHC = w.BegBalHC, h.BegBalNC = w.BegBalNC,
+					h.DebitAmtHC = w.DebitAmtHC, h.CreditAmtHC = w.CreditAmtHC,
+					h.DebitAmtNC = w.DebitAmtNC, h.CreditAmtNC = w.CreditAmtNC
+				FROM tglAcctHistCurr h
+					INNER JOIN #tglAcctHistCurrWrk w ON h.GLAcctKey = w.GLAcctKey AND h.CurrID = w.CurrID
+						AND h.FiscYear = w.FiscYear AND h.FiscPer = w.FiscPer;`)
+
+		bq.Set(`INSERT INTO tglAcctHistCurr (BegBalHC, BegBalNC, CreditAmtHC, CreditAmtNC, CurrID,
+											 DebitAmtHC, DebitAmtNC, FiscPer, FiscYear, GLAcctKey)
+				SELECT w.BegBalHC, w.BegBalNC, w.CreditAmtHC, w.CreditAmtNC, w.CurrID,
+					w.DebitAmtHC, w.DebitAmtNC, w.FiscPer, w.FiscYear, w.GLAcctKey
+				FROM #tglAcctHistCurrWrk w
+				WHERE NOT EXISTS (SELECT 1 FROM tglAcctHistCurr h WITH (NOLOCK)
+								  WHERE h.GLAcctKey = w.GLAcctKey AND h.CurrID = w.CurrID
+									AND h.FiscYear = w.FiscYear AND h.FiscPer = w.FiscPer);`)
+
+		bq.Set(`UPDATE h
+				SET h.BegBalHC = 0, h.BegBalNC = 0, h.DebitAmtHC = 0, h.CreditAmtHC = 0, h.DebitAmtNC = 0, h.CreditAmtNC = 0
+				FROM tglAcctHistCurr h
+					INNER JOIN tglAccount a WITH (NOLOCK) ON h.GLAcctKey = a.GLAcctKey
+				WHERE a.CompanyID=? AND h.FiscYear BETWEEN ? AND ?
+					AND NOT EXISTS (SELECT 1 FROM #tglAcctHistCurrWrk w
+									WHERE h.GLAcctKey = w.GLAcctKey AND h.CurrID = w.CurrID
+										AND h.FiscYear = w.FiscYear AND h.FiscPer = w.FiscPer);`,
+			iCompanyID, iFromFiscYear, iToFiscYear)
+	}
+
+	bq.Set(`UPDATE h
+			SET h.DebitAmt = w.DebitAmt, h.CreditAmt = w.CreditAmt
+			FROM tglAcctHistAcctRef h
+				INNER JOIN #tglAcctHistAcctRefWrk w ON h.GLAcctKey = w.GLAcctKey AND h.AcctRefKey = w.AcctRefKey
+					AND h.FiscYear = w.FiscYear AND h.FiscPer = w.FiscPer;`)
+
+	bq.Set(`INSERT INTO tglAcctHistAcctRef (AcctRefKey, CreditAmt, DebitAmt, FiscPer, FiscYear, GLAcctKey)
+			SELECT w.AcctRefKey, w.CreditAmt, w.DebitAmt, w.FiscPer, w.FiscYear, w.GLAcctKey
+			FROM #tglAcctHistAcctRefWrk w
+			WHERE NOT EXISTS (SELECT 1 FROM tglAcctHistAcctRef h WITH (NOLOCK)
+							  WHERE h.GLAcctKey = w.GLAcctKey AND h.AcctRefKey = w.AcctRefKey
+								AND h.FiscYear = w.FiscYear AND h.FiscPer = w.FiscPer);`)
+
+	bq.Set(`UPDATE h
+			SET h.DebitAmt = 0, h.CreditAmt = 0
+			FROM tglAcctHistAcctRef h
+				INNER JOIN tglAccount a WITH (NOLOCK) ON h.GLAcctKey = a.GLAcctKey
+			WHERE a.CompanyID=? AND h.FiscYear BETWEEN ? AND ?
+				AND NOT EXISTS (SELECT 1 FROM #tglAcctHistAcctRefWrk w
+								WHERE h.GLAcctKey = w.GLAcctKey AND h.AcctRefKey = w.AcctRefKey
+									AND h.FiscYear = w.FiscYear AND h.FiscPer = w.FiscPer);`,
+		iCompanyID, iFromFiscYear, iToFiscYear)
+
+	if !bq.OK() {
+		bq.Waive()
+		bq.Set(`IF @@TRANCOUNT > 0 ROLLBACK;`)
+		return constants.ResultError, Diffs
+	}
+
+	bq.Set(`COMMIT;`)
+
+	return constants.ResultSuccess, Diffs
+}
@@ -2,6 +2,8 @@ package gl
 
 import (
 	"gosqljobs/invtcommit/functions/constants"
+	"gosqljobs/invtcommit/functions/dec"
+	"gosqljobs/invtcommit/functions/sm"
 	"time"
 
 	du "github.com/eaglebush/datautils"
@@ -130,24 +132,21 @@ func SetAPIGLPosting(
 		return constants.ResultSuccess
 	}
 
-	lSuspenseAcctKey := int64(0)
 	lRetEarnGLAcctNo := ``
 	lClearNonFin := false
 	lUseMultCurr := false
 	lAcctRefUsage := 0
 
 	// Retrieve GL Options Info
-	qr := bq.Get(`SELECT SuspenseAcctKey, RetainedEarnAcct, 
-						ClearNonFin, UseMultCurr, AcctRefUsage 
-				 FROM tglOptions WITH (NOLOCK) 
+	qr := bq.Get(`SELECT RetainedEarnAcct, ClearNonFin, UseMultCurr, AcctRefUsage
+				 FROM tglOptions WITH (NOLOCK)
 				 WHERE CompanyID=?;`, iCompanyID)
 	if !qr.HasData {
 		return constants.ResultError
 	}
 
-	lSuspenseAcctKey = qr.First().ValueInt64("SuspenseAcctKey")
 	lRetEarnGLAcctNo = qr.First().ValueString("RetainedEarnAcct")
-	lClearNonFin = qr.First().ValueInt64("lClearNonFin") == 1
+	lClearNonFin = qr.First().ValueInt64("ClearNonFin") == 1
 	lUseMultCurr = qr.First().ValueInt64("UseMultCurr") == 1
 	lAcctRefUsage = int(qr.First().ValueInt64("AcctRefUsage"))
 
@@ -172,15 +171,15 @@ func SetAPIGLPosting(
 	}
 
 	// Retrieve Batch PostDate
-	var lBatchPostDate *time.Time
+	var lBatchPostDate time.Time
 	qr = bq.Get(`SELECT MIN(PostDate)
 				 FROM tglPosting WITH (NOLOCK)
 				 WHERE BatchKey=?;`, iBatchKey)
 	if qr.HasData {
-		*lBatchPostDate = qr.First().ValueTimeOrd(0)
-		if lBatchPostDate == nil {
-			return constants.ResultFail
-		}
+		lBatchPostDate = qr.First().ValueTimeOrd(0)
+	}
+	if lBatchPostDate.IsZero() {
+		return constants.ResultError
 	}
 
 	// Determine if there are any tglPosting rows with NULL Post Dates.
@@ -203,7 +202,9 @@ func SetAPIGLPosting(
 
 	// Retrieve Fiscal Year Info
 	lFiscYear := ``
-	oRetval, oStatus, oFiscYear, oFiscPer, oStartDate, oEndDate := GetFiscalYearPeriod(bq, iCompanyID, *lBatchPostDate, 3, lFiscYear, iUserID)
+	oRetval, oStatus, oFiscYear, oFiscPer, _, _ := GetFiscalYearPeriod(bq, iCompanyID, lBatchPostDate, 3, lFiscYear, iUserID)
+
+	bq.ScopeName("SetAPIGLPosting")
 
 	if oRetval == 5 {
 		return constants.ResultFail
@@ -218,6 +219,7 @@ func SetAPIGLPosting(
 	lRowCount := 0
 
 	// Determine how many non-beginning balance rows in tglPosting will go to tglTransaction.
+	// Beginning balance rows (NatCurrBegBal <> 0) only come from GL and are not posted here.
 	qr = bq.Get(`SELECT COUNT(1) FROM tglPosting WITH (NOLOCK) WHERE BatchKey = ? AND NatCurrBegBal = 0;`, iBatchKey)
 	if qr.HasData {
 		lRowCount = int(qr.First().ValueInt64Ord(0))
@@ -227,5 +229,251 @@ func SetAPIGLPosting(
 		return constants.ResultSuccess
 	}
 
-	
+	// The transactions and the history are written together.
+	bq.Set(`BEGIN TRAN;`)
+
+	rollback := func(rv constants.ResultConstant) constants.ResultConstant {
+		bq.Waive()
+		bq.Set(`IF @@TRANCOUNT > 0 ROLLBACK;`)
+		return rv
+	}
+
+	res := SetAPIInsertGLTrans(bq, iBatchKey, &lBatchPostDate, oFiscYear, oFiscPer, lSourceModuleNo, lRowCount)
+
+	bq.ScopeName("SetAPIGLPosting")
+
+	if res != constants.ResultSuccess {
+		return rollback(res)
+	}
+
+	// -- Period activity of the batch (tglAcctHist)
+	bq.Set(`INSERT INTO tglAcctHist (BegBal, CreditAmt, DebitAmt, FiscPer, FiscYear, GLAcctKey, StatBegBal, StatQty, UpdateCounter)
+			SELECT DISTINCT 0, 0, 0, ?, ?, t.GLAcctKey, 0, 0, 1
+			FROM tglTransaction t WITH (NOLOCK)
+			WHERE t.BatchKey=?
+				AND NOT EXISTS (SELECT 1 FROM tglAcctHist h WITH (NOLOCK)
+								WHERE h.GLAcctKey = t.GLAcctKey AND h.FiscYear=? AND h.FiscPer=?);`,
+		oFiscPer, oFiscYear, iBatchKey, oFiscYear, oFiscPer)
+	if !bq.OK() {
+		return rollback(constants.ResultConstant(7))
+	}
+
+	bq.Set(`UPDATE h
+			SET h.DebitAmt = h.DebitAmt + t.DebitAmt,
+				h.CreditAmt = h.CreditAmt + t.CreditAmt,
+				h.StatQty = h.StatQty + t.StatQty,
+				h.UpdateCounter = h.UpdateCounter + 1
+			FROM tglAcctHist h
+				INNER JOIN (SELECT GLAcctKey,
+								SUM(CASE WHEN PostAmtHC > 0 THEN PostAmtHC ELSE 0 END) DebitAmt,
+								SUM(CASE WHEN PostAmtHC < 0 THEN -PostAmtHC ELSE 0 END) CreditAmt,
+								SUM(COALESCE(PostQty, 0)) StatQty
+							FROM tglTransaction WITH (NOLOCK)
+							WHERE BatchKey=?
+							GROUP BY GLAcctKey) t ON h.GLAcctKey = t.GLAcctKey
+			WHERE h.FiscYear=? AND h.FiscPer=?;`, iBatchKey, oFiscYear, oFiscPer)
+	if !bq.OK() {
+		return rollback(constants.ResultConstant(8))
+	}
+
+	// -- Period activity of the non-home currencies (tglAcctHistCurr)
+	if lUseMultCurr {
+		bq.Set(`INSERT INTO tglAcctHistCurr (BegBalHC, BegBalNC, CreditAmtHC, CreditAmtNC, CurrID,
+											 DebitAmtHC, DebitAmtNC, FiscPer, FiscYear, GLAcctKey)
+				SELECT DISTINCT 0, 0, 0, 0, t.CurrID, 0, 0, ?, ?, t.GLAcctKey
+				FROM tglTransaction t WITH (NOLOCK)
+					INNER JOIN tsmCompany c WITH (NOLOCK) ON c.CompanyID=?
+				WHERE t.BatchKey=? AND t.CurrID <> c.CurrID
+					AND NOT EXISTS (SELECT 1 FROM tglAcctHistCurr h WITH (NOLOCK)
+									WHERE h.GLAcctKey = t.GLAcctKey AND h.CurrID = t.CurrID
+										AND h.FiscYear=? AND h.FiscPer=?);`,
+			oFiscPer, oFiscYear, iCompanyID, iBatchKey, oFiscYear, oFiscPer)
+		if !bq.OK() {
+			return rollback(constants.ResultConstant(9))
+		}
+
+		bq.Set(`UPDATE h
+				SET h.DebitAmtHC = h.DebitAmtHC + t.DebitAmtHC,
+					h.CreditAmtHC = h.CreditAmtHC + t.CreditAmtHC,
+					h.DebitAmtNC = h.DebitAmtNC + t.DebitAmtNC,
+					h.CreditAmtNC = h.CreditAmtNC + t.CreditAmtNC
+				FROM tglAcctHistCurr h
+					INNER JOIN (SELECT t.GLAcctKey, t.CurrID,
+									SUM(CASE WHEN t.PostAmtHC > 0 THEN t.PostAmtHC ELSE 0 END) DebitAmtHC,
+									SUM(CASE WHEN t.PostAmtHC < 0 THEN -t.PostAmtHC ELSE 0 END) CreditAmtHC,
+									SUM(CASE WHEN t.PostAmt > 0 THEN t.PostAmt ELSE 0 END) DebitAmtNC,
+									SUM(CASE WHEN t.PostAmt < 0 THEN -t.PostAmt ELSE 0 END) CreditAmtNC
+								FROM tglTransaction t WITH (NOLOCK)
+									INNER JOIN tsmCompany c WITH (NOLOCK) ON c.CompanyID=?
+								WHERE t.BatchKey=? AND t.CurrID <> c.CurrID
+								GROUP BY t.GLAcctKey, t.CurrID) t ON h.GLAcctKey = t.GLAcctKey AND h.CurrID = t.CurrID
+				WHERE h.FiscYear=? AND h.FiscPer=?;`, iCompanyID, iBatchKey, oFiscYear, oFiscPer)
+		if !bq.OK() {
+			return rollback(constants.ResultConstant(10))
+		}
+	}
+
+	// -- Period activity of the account reference codes (tglAcctHistAcctRef)
+	if lAcctRefUsage != 0 {
+		bq.Set(`INSERT INTO tglAcctHistAcctRef (AcctRefKey, CreditAmt, DebitAmt, FiscPer, FiscYear, GLAcctKey)
+				SELECT DISTINCT t.AcctRefKey, 0, 0, ?, ?, t.GLAcctKey
+				FROM tglTransaction t WITH (NOLOCK)
+				WHERE t.BatchKey=? AND t.AcctRefKey IS NOT NULL
+					AND NOT EXISTS (SELECT 1 FROM tglAcctHistAcctRef h WITH (NOLOCK)
+									WHERE h.GLAcctKey = t.GLAcctKey AND h.AcctRefKey = t.AcctRefKey
+										AND h.FiscYear=? AND h.FiscPer=?);`,
+			oFiscPer, oFiscYear, iBatchKey, oFiscYear, oFiscPer)
+		if !bq.OK() {
+			return rollback(constants.ResultConstant(11))
+		}
+
+		bq.Set(`UPDATE h
+				SET h.DebitAmt = h.DebitAmt + t.DebitAmt,
+					h.CreditAmt = h.CreditAmt + t.CreditAmt
+				FROM tglAcctHistAcctRef h
+					INNER JOIN (SELECT GLAcctKey, AcctRefKey,
+									SUM(CASE WHEN PostAmtHC > 0 THEN PostAmtHC ELSE 0 END) DebitAmt,
+									SUM(CASE WHEN PostAmtHC < 0 THEN -PostAmtHC ELSE 0 END) CreditAmt
+								FROM tglTransaction WITH (NOLOCK)
+								WHERE BatchKey=? AND AcctRefKey IS NOT NULL
+								GROUP BY GLAcctKey, AcctRefKey) t ON h.GLAcctKey = t.GLAcctKey AND h.AcctRefKey = t.AcctRefKey
+				WHERE h.FiscYear=? AND h.FiscPer=?;`, iBatchKey, oFiscYear, oFiscPer)
+		if !bq.OK() {
+			return rollback(constants.ResultConstant(12))
+		}
+	}
+
+	// -- Beginning balances of the fiscal years after the one posted to.  Balance sheet
+	// -- accounts carry their balance forward and income statement accounts are closed
+	// -- to the masked retained earnings account, the same as CalcBeginBalance.
+	qr = bq.Get(`SELECT 1 FROM tglFiscalYear WITH (NOLOCK) WHERE CompanyID=? AND FiscYear > ?;`, iCompanyID, oFiscYear)
+	if !bq.OK() {
+		return rollback(constants.ResultError)
+	}
+	if !qr.HasData {
+		bq.Set(`COMMIT;`)
+		return constants.ResultSuccess
+	}
+
+	bq.Set(`IF OBJECT_ID('tempdb..#tglFutBegBal') IS NOT NULL
+				TRUNCATE TABLE #tglFutBegBal
+			ELSE
+				CREATE TABLE #tglFutBegBal (
+					GLAcctKey INTEGER NOT NULL,
+					BegBal    DECIMAL(15,3) NOT NULL,
+					StatQty   DECIMAL(16,8) NOT NULL
+				);`)
+
+	qr = bq.Get(`SELECT t.GLAcctKey, a.GLAcctNo, e.AcctCatID, SUM(t.PostAmtHC) NetAmt, SUM(COALESCE(t.PostQty, 0)) NetQty
+				 FROM tglTransaction t WITH (NOLOCK)
+					INNER JOIN tglAccount a WITH (NOLOCK) ON (t.GLAcctKey = a.GLAcctKey)
+					INNER JOIN tglNaturalAcct b WITH (NOLOCK) ON (a.NaturalAcctKey = b.NaturalAcctKey)
+					INNER JOIN tglAcctType c WITH (NOLOCK) ON (b.AcctTypeKey = c.AcctTypeKey)
+					INNER JOIN tglAcctCategory e WITH (NOLOCK) ON (c.AcctCategoryKey = e.AcctCategoryKey)
+				 WHERE t.BatchKey=?
+				 GROUP BY t.GLAcctKey, a.GLAcctNo, e.AcctCatID;`, iBatchKey)
+	if !bq.OK() {
+		return rollback(constants.ResultConstant(16))
+	}
+
+	for _, v := range qr.Data {
+		lAcctCatID := v.ValueInt64("AcctCatID")
+
+		net, err := dec.Cols(v, "NetAmt", "NetQty")
+		if err != nil {
+			return rollback(constants.ResultConstant(16))
+		}
+
+		if sm.InInt64Array(&[]int64{1, 2, 3}, lAcctCatID) || (lAcctCatID == 9 && !lClearNonFin) {
+			bq.Set(`INSERT INTO #tglFutBegBal (GLAcctKey, BegBal, StatQty) VALUES (?, ?, ?);`,
+				v.ValueInt64("GLAcctKey"), net[0], net[1])
+			continue
+		}
+
+		if !sm.InInt64Array(&[]int64{4, 5, 6, 7, 8}, lAcctCatID) {
+			continue
+		}
+
+		if lRetEarnGLAcctNo == "" {
+			return rollback(constants.ResultConstant(13))
+		}
+
+		qr2 := bq.Get(`SELECT GLAcctKey FROM tglAccount WITH (NOLOCK) WHERE CompanyID=? AND GLAcctNo=?;`,
+			iCompanyID, sm.SubstAcct(v.ValueString("GLAcctNo"), lRetEarnGLAcctNo))
+		if !qr2.HasData {
+			return rollback(constants.ResultConstant(19))
+		}
+
+		bq.Set(`INSERT INTO #tglFutBegBal (GLAcctKey, BegBal, StatQty) VALUES (?, ?, 0);`,
+			qr2.First().ValueInt64Ord(0), net[0])
+	}
+	if !bq.OK() {
+		return rollback(constants.ResultConstant(16))
+	}
+
+	bq.Set(`INSERT INTO tglAcctHist (BegBal, CreditAmt, DebitAmt, FiscPer, FiscYear, GLAcctKey, StatBegBal, StatQty, UpdateCounter)
+			SELECT DISTINCT 0, 0, 0, 1, y.FiscYear, f.GLAcctKey, 0, 0, 1
+			FROM #tglFutBegBal f
+				CROSS JOIN tglFiscalYear y WITH (NOLOCK)
+			WHERE y.CompanyID=? AND y.FiscYear > ?
+				AND NOT EXISTS (SELECT 1 FROM tglAcctHist h WITH (NOLOCK)
+								WHERE h.GLAcctKey = f.GLAcctKey AND h.FiscYear = y.FiscYear AND h.FiscPer = 1);`,
+		iCompanyID, oFiscYear)
+	if !bq.OK() {
+		return rollback(constants.ResultConstant(14))
+	}
+
+	bq.Set(`UPDATE h
+			SET h.BegBal = h.BegBal + f.BegBal,
+				h.StatBegBal = h.StatBegBal + f.StatQty,
+				h.UpdateCounter = h.UpdateCounter + 1
+			FROM tglAcctHist h
+				INNER JOIN (SELECT GLAcctKey, SUM(BegBal) BegBal, SUM(StatQty) StatQty
+							FROM #tglFutBegBal
+							GROUP BY GLAcctKey) f ON h.GLAcctKey = f.GLAcctKey
+				INNER JOIN tglFiscalYear y WITH (NOLOCK) ON h.FiscYear = y.FiscYear
+			WHERE y.CompanyID=? AND y.FiscYear > ? AND h.FiscPer = 1;`, iCompanyID, oFiscYear)
+	if !bq.OK() {
+		return rollback(constants.ResultConstant(15))
+	}
+
+	// Only balance sheet accounts carry their currency balances forward
+	if lUseMultCurr {
+		bq.Set(`INSERT INTO tglAcctHistCurr (BegBalHC, BegBalNC, CreditAmtHC, CreditAmtNC, CurrID,
+											 DebitAmtHC, DebitAmtNC, FiscPer, FiscYear, GLAcctKey)
+				SELECT DISTINCT 0, 0, 0, 0, t.CurrID, 0, 0, 1, y.FiscYear, t.GLAcctKey
+				FROM tglTransaction t WITH (NOLOCK)
+					INNER JOIN tsmCompany c WITH (NOLOCK) ON c.CompanyID=?
+					INNER JOIN #tglFutBegBal f ON t.GLAcctKey = f.GLAcctKey
+					CROSS JOIN tglFiscalYear y WITH (NOLOCK)
+				WHERE t.BatchKey=? AND t.CurrID <> c.CurrID
+					AND y.CompanyID=? AND y.FiscYear > ?
+					AND NOT EXISTS (SELECT 1 FROM tglAcctHistCurr h WITH (NOLOCK)
+									WHERE h.GLAcctKey = t.GLAcctKey AND h.CurrID = t.CurrID
+										AND h.FiscYear = y.FiscYear AND h.FiscPer = 1);`,
+			iCompanyID, iBatchKey, iCompanyID, oFiscYear)
+		if !bq.OK() {
+			return rollback(constants.ResultConstant(22))
+		}
+
+		bq.Set(`UPDATE h
+				SET h.BegBalHC = h.BegBalHC + t.NetAmtHC,
+					h.BegBalNC = h.BegBalNC + t.NetAmtNC
+				FROM tglAcctHistCurr h
+					INNER JOIN (SELECT t.GLAcctKey, t.CurrID, SUM(t.PostAmtHC) NetAmtHC, SUM(t.PostAmt) NetAmtNC
+								FROM tglTransaction t WITH (NOLOCK)
+									INNER JOIN tsmCompany c WITH (NOLOCK) ON c.CompanyID=?
+								WHERE t.BatchKey=? AND t.CurrID <> c.CurrID
+									AND t.GLAcctKey IN (SELECT GLAcctKey FROM #tglFutBegBal)
+								GROUP BY t.GLAcctKey, t.CurrID) t ON h.GLAcctKey = t.GLAcctKey AND h.CurrID = t.CurrID
+					INNER JOIN tglFiscalYear y WITH (NOLOCK) ON h.FiscYear = y.FiscYear
+				WHERE y.CompanyID=? AND y.FiscYear > ? AND h.FiscPer = 1;`, iCompanyID, iBatchKey, iCompanyID, oFiscYear)
+		if !bq.OK() {
+			return rollback(constants.ResultConstant(23))
+		}
+	}
+
+	bq.Set(`COMMIT;`)
+
+	return constants.ResultSuccess
 }
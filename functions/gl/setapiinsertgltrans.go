@@ -2,6 +2,7 @@ package gl
 
 import (
 	"gosqljobs/invtcommit/functions/constants"
+	"gosqljobs/invtcommit/functions/sm"
 	"time"
 
 	du "github.com/eaglebush/datautils"
@@ -63,7 +64,8 @@ func SetAPIInsertGLTrans(
 	if iBatchPostDate == nil {
 		qr = bq.Get(`SELECT MIN(PostDate) FROM tglPosting WITH (NOLOCK) WHERE BatchKey=?;`, iBatchKey)
 		if qr.HasData {
-			*iBatchPostDate = qr.First().ValueTimeOrd(0)
+			lBatchPostDate := qr.First().ValueTimeOrd(0)
+			iBatchPostDate = &lBatchPostDate
 		}
 	}
 
@@ -183,11 +185,47 @@ func SetAPIInsertGLTrans(
 
 	// Check if we still need to get more keys
 	qr = bq.Get(`SELECT COUNT(*) FROM #tglTransaction WHERE glTranKey = 0;`)
-	if rc = qr.First().ValueInt64Ord(0); rc > 0 {
-		iRowsToBeInserted = int(qr.First().ValueInt64Ord(0))
-	}
+	iRowsToBeInserted = int(qr.First().ValueInt64Ord(0))
 
 	if iRowsToBeInserted > 0 {
+		lStartKey, _ := sm.GetNextBlockSurrogateKey(bq, `tglTransaction`, iRowsToBeInserted)
+		bq.ScopeName("SetAPIInsertGLTrans")
+
+		if lStartKey == 0 {
+			bq.Set(`DROP TABLE #tglTransaction;`)
+			return constants.ResultConstant(5)
+		}
+
+		bq.Set(`UPDATE t
+				SET t.glTranKey = ? + k.RowNo - 1
+				FROM #tglTransaction t
+					JOIN (SELECT PostingKey, ROW_NUMBER() OVER (ORDER BY PostingKey) RowNo
+						  FROM #tglTransaction
+						  WHERE glTranKey = 0) k ON t.PostingKey = k.PostingKey;`, lStartKey)
+		if !bq.OK() {
+			bq.Waive()
+			bq.Set(`DROP TABLE #tglTransaction;`)
+			return constants.ResultConstant(5)
+		}
+	}
 
+	// Now transfer the rows into the permanent tglTransaction table.
+	bq.Set(`INSERT INTO tglTransaction (
+				glTranKey, AcctRefKey, BatchKey, CreateType, CreateDate, CurrExchRate, CurrID, ExtCmnt,
+				FiscPer, FiscYear, GLAcctKey, JrnlKey, JrnlNo, PostAmt, PostAmtHC, PostCmnt,
+				PostDate, PostQty, SourceModuleNo, TranDate, TranKey, TranNo, TranType)
+			SELECT
+				glTranKey, AcctRefKey, BatchKey, CreateType, GETDATE(), CurrExchRate, CurrID, ExtCmnt,
+				FiscPer, FiscYear, GLAcctKey, JrnlKey, JrnlNo, PostAmt, PostAmtHC, PostCmnt,
+				?, PostQty, SourceModuleNo, TranDate, TranKey, TranNo, TranType
+			FROM #tglTransaction;`, iBatchPostDate)
+	if !bq.OK() {
+		bq.Waive()
+		bq.Set(`DROP TABLE #tglTransaction;`)
+		return constants.ResultConstant(6)
 	}
+
+	bq.Set(`DROP TABLE #tglTransaction;`)
+
+	return constants.ResultSuccess
 }
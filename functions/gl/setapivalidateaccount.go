@@ -26,8 +26,11 @@ import (
 //       (4)  That if a @iVerifyParams value other than one (1) is passed in,
 //            all parameter values in the NOTE below are guaranteed to be valid.
 //       (5)  The calling program is NOT relying on GL Accounts to be created
-//            if the AutoAcctAdd option is ON in tglOptions.  No GL Accounts
-//            are created when this sp is used for validation.
+//            if the AutoAcctAdd option is ON in tglOptions, unless it passes
+//            optCreateAccts.  In that mode, rows of #tglValidateAcct with a
+//            GLAcctNo and a GLAcctKey of zero (or of a masked account) are
+//            created with AutoAcctCreate before the accounts are validated, so
+//            the new accounts are validated in the same run.
 //       (6)  The calling program is NOT relying on Account Reference Codes to
 //            be created if AcctRefUsage is set to '2' in tglOptions.  No Account
 //            Reference Codes are created when this sp is used for validation.
@@ -55,6 +58,7 @@ import (
 //    @iValidateGLAccts  = [IN: 0, 1 or NULL; Default = 1]
 //    @iValidateAcctRefs = [IN: 0, 1 or NULL; Default = 1]
 //    @iValidateCurrIDs  = [IN: 0, 1 or NULL; Default = 1]
+//    optCreateAccts     = [IN: Create missing accounts if AutoAcctAdd is ON; Default = false]
 //
 // NOTE: The following parameters MUST be passed in with a valid value from the
 // calling stored procedure IF the @iVerifyParams parameter is passed in
@@ -124,10 +128,10 @@ func SetAPIValidateAccount(
 	iVerifyParams bool,
 	iValidateGLAccts bool,
 	iValidateAcctRefs bool,
-	iValidateCurrIDs bool) (Result constants.ResultConstant, Severity int, SessionID int) {
+	iValidateCurrIDs bool,
+	optCreateAccts bool) (Result constants.ResultConstant, Severity int, SessionID int) {
 
 	var qr du.QueryResult
-	var lErrMsgNo int
 
 	bq.ScopeName("SetAPIValidateAccount")
 
@@ -164,7 +168,6 @@ func SetAPIValidateAccount(
 	lAcctRefUsage := iAcctRefUsage
 	lAutoAcctAdd := iAutoAcctAdd
 	lUseMultCurr := iUseMultCurr
-	lGLAcctMask := iGLAcctMask
 	lHomeCurrID := iHomeCurrID
 
 	if iVerifyParams {
@@ -225,7 +228,6 @@ func SetAPIValidateAccount(
 		}
 		lAutoAcctAdd = qr.First().ValueInt64Ord(0) == 1
 		lUseMultCurr = qr.First().ValueInt64Ord(1) == 1
-		lGLAcctMask = qr.First().ValueStringOrd(2)
 		lAcctRefUsage = int(qr.First().ValueInt64Ord(3))
 	}
 
@@ -258,6 +260,30 @@ func SetAPIValidateAccount(
 
 	if iValidateGLAccts {
 
+		/* -------------- Create missing GL accounts if AutoAcctAdd is ON -------------- */
+		if optCreateAccts && lAutoAcctAdd {
+			qr = bq.Get(`SELECT DISTINCT GLAcctNo
+						 FROM #tglValidateAcct
+						 WHERE COALESCE(DATALENGTH(LTRIM(RTRIM(GLAcctNo))), 0) > 0
+							AND (GLAcctKey = 0 OR GLAcctKey IN (SELECT GLAcctKey
+																FROM tglAccount WITH (NOLOCK)
+																WHERE CompanyID=?
+																	AND CHARINDEX('*', GLAcctNo) > 0))
+							AND ValidationRetVal = 0;`, iCompanyID)
+			for _, v := range qr.Data {
+				lGLAcctNo := v.ValueStringOrd(0)
+
+				rv, lGLAcctKey := AutoAcctCreate(bq, iCompanyID, iBatchKey, iSessionID, iUserID, lGLAcctNo)
+				if rv != constants.ResultSuccess {
+					continue
+				}
+
+				bq.Set(`UPDATE #tglValidateAcct
+						SET GLAcctKey=?
+						WHERE GLAcctNo=? AND ValidationRetVal = 0;`, lGLAcctKey, lGLAcctNo)
+			}
+		}
+
 		/* -------------- Make sure all GL accounts exist in tglAccount -------------- */
 		qr = bq.Set(`UPDATE #tglValidateAcct
 					 SET ValidationRetVal=25, ErrorMsgNo = @lInvalidCurr
@@ -634,20 +660,20 @@ func SetAPIValidateAccount(
 	}
 
 	// Validate the Account Reference ID's in #tglValidateAcct now
-	if iValidateAcctRefs && iAcctRefUsage != 0 {
+	if iValidateAcctRefs && constants.AcctRefUsageConstant(lAcctRefUsage) != constants.AcctRefUsageNone {
 
-		lValidateAcctRefRetVal, lValidateAcctRefSeverity, iSessionID = SetAPIValidateAcctRef(bq, iCompanyID, iBatchKey, iSessionID, iUserID, iLanguageID, iAcctRefUsage, iEffectiveDate, false)
+		lValidateAcctRefRetVal, lValidateAcctRefSeverity, iSessionID = SetAPIValidateAcctRef(bq, iCompanyID, iBatchKey, iSessionID, iUserID, iLanguageID, lAcctRefUsage, iEffectiveDate, false)
 
 		/* Did the Account Reference Code validation go OK? */
 		switch lValidateAcctRefRetVal {
-		case 19, 20, 21, 23, 24, 25, 30, 33, 34:
+		case 19, 20, 21, 23, 24, 25, 30, 31, 33, 34:
 			lAcctRefValFail = 1
 			lValidateAcctRetVal = lValidateAcctRefRetVal
 
 			goto FinishFunc
 		}
 
-		if lValidateAcctRefRetVal != 0 || lValidateAcctRefRetVal != 1 {
+		if lValidateAcctRefRetVal != 0 && lValidateAcctRefRetVal != 1 {
 			lValidateAcctRetVal = lValidateAcctRefRetVal
 		}
 
@@ -656,7 +682,7 @@ func SetAPIValidateAccount(
 		}
 
 		// Verify that Account Reference Codes are valid for all Account Segments
-		if lAcctRefUsage == 1 {
+		if constants.AcctRefUsageConstant(lAcctRefUsage) == constants.AcctRefUsageValidated {
 
 			qr = bq.Get(`SELECT COUNT(SegmentKey) FROM tglSegment WITH (NOLOCK) WHERE CompanyID=?;`, iCompanyID)
 			lMaxAccountSegments = int(qr.First().ValueInt64Ord(0))
@@ -25,9 +25,16 @@ import (
 //       (3)  That all GLAcctKey's in #tglValidateAcct are only for @iCompanyID.
 //       (4)  That if a @iVerifyParams value other than one (1) is passed in,
 //            all parameter values in the NOTE below are guaranteed to be valid.
-//       (5)  The calling program is NOT relying on Account Reference Codes to
-//            be created if AcctRefUsage is set to '2' in tglOptions.  No Account
-//            Reference Codes are created when this sp is used for validation.
+//       (5)  Account Reference Codes are created with AcctRefCreate when
+//            AcctRefUsage is set to '2' in tglOptions, for the rows of
+//            #tglValidateAcct that have an AcctRefCode but no AcctRefKey.
+//            The new AcctRefKey is written back to #tglValidateAcct.
+//
+// Usage modes (constants.AcctRefUsage...):
+//       0 - None.       Account Reference Codes are not validated.
+//       1 - Validated.  Codes must exist for the company, be active and effective,
+//                       and required codes must be supplied.
+//       2 - Free-form.  Missing codes are created.  Codes must exist for the company.
 //
 // Use this sp with other Acuity API's that begin with spglSetAPI...
 //
@@ -37,7 +44,7 @@ import (
 //    @ioSessionID     = [IN/OUT: Valid No. or NULL; No Default]
 //    @iUserID           = [IN: Valid User or NULL; Default = spGetLoginName]
 //    @iLanguageID       = [IN: Valid Language ID or NULL; Default = NULL]
-//    @iAcctRefUsage     = [IN: 0, 1 or NULL; Default = 0]
+//    @iAcctRefUsage     = [IN: 0, 1, 2 or NULL; Default = 0]
 //    @iEffectiveDate    = [IN: Effective Date or NULL]
 //    @iVerifyParams     = [IN: 0, 1 or NULL; Default = 1]
 //
@@ -61,6 +68,7 @@ import (
 //          21 = Failure.  Company ID supplied does not exist.
 //          24 = Failure.  GL Options row for this Company does not exist.
 //          27 = Failure.  Account Reference Key exists but not for the correct Company.
+//          31 = Failure.  Account Reference Code cannot be created.  No group is valid for all Account Segments.
 //          30 = Failure.  Account Reference Key supplied does not exist.
 //          32 = Failure.  Failure of Account Reference Code Effective Dates Restriction.
 //          33 = Failure.  User ID not supplied and cannot be derived.
@@ -85,7 +93,7 @@ func SetAPIValidateAcctRef(bq *du.BatchQuery,
 	createAPIValidationTempTables(bq)
 
 	lLanguageID := iLanguageID
-	lAcctRefUsage := iAcctRefUsage
+	lAcctRefUsage := constants.AcctRefUsageConstant(iAcctRefUsage)
 
 	if iVerifyParams {
 
@@ -125,9 +133,9 @@ func SetAPIValidateAcctRef(bq *du.BatchQuery,
 			sm.LogError(bq, iBatchKey, 0, 19105, iCompanyID, ``, ``, ``, ``, 3, 2, iSessionID, 0, 0, 0, 0)
 			return constants.ResultConstant(24), 2, 0
 		}
-		lAcctRefUsage = int(qr.First().ValueInt64Ord(0))
+		lAcctRefUsage = constants.AcctRefUsageConstant(qr.First().ValueInt64Ord(0))
 
-		if lAcctRefUsage == 0 {
+		if lAcctRefUsage == constants.AcctRefUsageNone {
 			sm.LogError(bq, iBatchKey, 0, 19230, iCompanyID, ``, ``, ``, ``, 3, 2, iSessionID, 0, 0, 0, 0)
 			return constants.ResultConstant(42), 2, 0
 		}
@@ -143,10 +151,62 @@ func SetAPIValidateAcctRef(bq *du.BatchQuery,
 	const lAcctRefInactive int = 19227
 	const lAcctRefStart int = 19224
 	const lAcctRefEnd int = 19225
+	const lAcctRefSegs int = 19223
+
+	if lAcctRefUsage == constants.AcctRefUsageNone {
+		return constants.ResultSuccess, oSeverity, iSessionID
+	}
+
+	// Create the missing Account Reference Codes. This only applies when @lAcctRefUsage = 2 [Free-form ARC's]
+	if lAcctRefUsage == constants.AcctRefUsageFreeForm {
+
+		qr = bq.Get(`SELECT DISTINCT GLAcctKey, AcctRefCode
+					 FROM #tglValidateAcct
+					 WHERE COALESCE(AcctRefKey, 0) = 0
+						AND COALESCE(DATALENGTH(LTRIM(RTRIM(AcctRefCode))), 0) > 0
+						AND ValidationRetVal = 0;`)
+		for _, v := range qr.Data {
+			lGLAcctKey := int(v.ValueInt64Ord(0))
+			lAcctRefCode := v.ValueStringOrd(1)
+
+			rv, lAcctRefKey := AcctRefCreate(bq, iCompanyID, lGLAcctKey, lAcctRefCode, iEffectiveDate)
+			if rv == constants.ResultSuccess {
+				bq.Set(`UPDATE #tglValidateAcct
+						SET AcctRefKey = ?
+						WHERE GLAcctKey = ? AND AcctRefCode = ?
+							AND COALESCE(AcctRefKey, 0) = 0;`, lAcctRefKey, lGLAcctKey, lAcctRefCode)
+				continue
+			}
+
+			lErrMsgNo := lAcctRefSegs
+			switch rv {
+			case 32:
+				lErrMsgNo = lAcctRefStart
+			case 37:
+				lErrMsgNo = lAcctRefInactive
+			}
+
+			lErrorsOccurred = true
+			lValidateAcctRetVal = rv
+			oSeverity = constants.FatalError
+
+			bq.Set(`UPDATE #tglValidateAcct
+					SET ValidationRetVal = ?, ErrorMsgNo = ?
+					WHERE GLAcctKey = ? AND AcctRefCode = ?
+						AND COALESCE(AcctRefKey, 0) = 0
+						AND ValidationRetVal = 0;`, rv, lErrMsgNo, lGLAcctKey, lAcctRefCode)
+
+			bq.Set(`INSERT INTO #tciErrorStg (
+						GLAcctKey,   BatchKey,    ErrorType,   Severity, 
+						StringData1, StringData2, StringData3, StringData4, 
+						StringData5, StringNo)
+					VALUES (?, ?, ?, ?, ?, '', '', '', '', ?);`, lGLAcctKey, iBatchKey, constants.InterfaceError, constants.FatalError, lAcctRefCode, lErrMsgNo)
+		}
+	}
 
 	// Validate the required Account Reference ID's in #tglValidateAcct now
 	// This validation only applies when @lAcctRefUsage = 1 [Validated ARC's]
-	if lAcctRefUsage == 0 {
+	if lAcctRefUsage == constants.AcctRefUsageValidated {
 		qr = bq.Set(`UPDATE #tglValidateAcct
 					SET ValidationRetVal = 43,
 						ErrorMsgNo = ?
@@ -179,15 +239,17 @@ func SetAPIValidateAcctRef(bq *du.BatchQuery,
 						GLAcctKey,   BatchKey,    ErrorType,   Severity, 
 						StringData1, StringData2, StringData3, StringData4, 
 						StringData5, StringNo)
-					SELECT GLAcctKey, ?, ?, ?, CONVERT(VARCHAR(30), AcctRefKey), '', '', '', '', ?
-					FROM #tglValidateAcct WITH (NOLOCK)
-					WHERE ValidationRetVal = 30
-						AND ErrorMsgNo=?;`, iBatchKey, constants.InterfaceError, constants.FatalError, lAcctRefExist, lAcctRefExist)
+					SELECT a.GLAcctKey, ?, ?, ?, CONVERT(VARCHAR(30), c.MaskedGLAcctNo), '', '', '', '', ?
+					FROM #tglValidateAcct a WITH (NOLOCK), tglAccount b WITH (NOLOCK), #tglAcctMask c WITH (NOLOCK)
+					WHERE a.GLAcctKey = b.GLAcctKey
+						AND b.GLAcctNo = c.GLAcctNo
+						AND a.ValidationRetVal = 43
+						AND a.ErrorMsgNo = ?;`, iBatchKey, constants.InterfaceError, constants.FatalError, lAcctRefKeyReqd, lAcctRefKeyReqd)
 		}
 	}
 
 	// Do all the Reference Keys exist? This validation applies when @lAcctRefUsage = 1 or 2
-	if lAcctRefUsage == 1 || lAcctRefUsage == 2 {
+	if lAcctRefUsage == constants.AcctRefUsageValidated || lAcctRefUsage == constants.AcctRefUsageFreeForm {
 
 		// Validating that the Account Reference Keys exist in tglAcctRef
 		qr = bq.Set(`UPDATE #tglValidateAcct
@@ -241,7 +303,7 @@ func SetAPIValidateAcctRef(bq *du.BatchQuery,
 		}
 	}
 
-	if lAcctRefUsage == 1 {
+	if lAcctRefUsage == constants.AcctRefUsageValidated {
 
 		// Validating that the Account Reference Keys have an active status
 		qr = bq.Set(`UPDATE #tglValidateAcct
@@ -16,6 +16,9 @@ import (
 //                   against tglPosting.TranKey.  This should represent the InvtTranKey of a shipment line.
 //                   It is not the ShipKey or ShipLineKey.
 //
+//                   Summarized records are grouped by AcctRefKey so that the Account Reference Codes
+//                   of the detail records are carried to the summarized records.
+//
 //  Assumptions:     This SP assumes that the #tglPostingDetlTran has been populated with a list of TranKeys
 //                   found in tglPosting.
 //                      CREATE TABLE #tglPostingDetlTran (
@@ -46,13 +49,13 @@ func SummarizeBatchlessTglPosting(
 	bq.Set(`SELECT * INTO #tglPostingTmp FROM tglPosting WHERE 1=2;`)
 
 	qr = bq.Get(`SELECT ISNULL(OBJECT_ID('tempdb..#tglPostingDetlTran'),0);`)
-	if qr.First().ValueFloat64Ord(0) == 0 {
+	if qr.First().ValueInt64Ord(0) == 0 {
 		return constants.ResultError
 	}
 
 	ttbl := "tglPosting"
 	if optUseTempTable {
-		ttbl := "#tglPostingRPT"
+		ttbl = "#tglPostingRPT"
 	}
 	qr = bq.Get(`SELECT 1 FROM #tglPostingDetlTran tmp JOIN ` + ttbl + ` gl ON tmp.TranType = gl.TranType AND tmp.PostingDetlTranKey = gl.TranKey;`)
 	if !qr.HasData {
@@ -179,7 +182,7 @@ func SummarizeBatchlessTglPosting(
 				TranKey,          TranNo,              gl.TranType
 			FROM `+ttbl+` gl WITH (NOLOCK) 
 			JOIN #tglPostingDetlTran detl ON gl.TranType = detl.TranType AND gl.TranKey = detl.PostingDetlTranKey
-			WHERE ABS(Summarize) NOT IN (?,?);`, iBatchKey, summarizeSalesClr, summarizeSalesClr)
+			WHERE ABS(Summarize) NOT IN (?,?);`, iBatchKey, summarizeInventory, summarizeSalesClr)
 
 	// See if there is anything to do.
 	qr = bq.Get(`SELECT 1 FROM #tglPostingTmp`)
@@ -187,7 +190,7 @@ func SummarizeBatchlessTglPosting(
 		return constants.ResultError
 	}
 
-	bq.Set(`DELETE ` + ttbl + ` FROM tglPosting gl
+	bq.Set(`DELETE gl FROM ` + ttbl + ` gl
 			JOIN #tglPostingDetlTran detl ON gl.TranType = detl.TranType AND gl.TranKey = detl.PostingDetlTranKey;`)
 
 	bq.Set(` INSERT INTO ` + ttbl + ` (
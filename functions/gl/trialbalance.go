@@ -0,0 +1,328 @@
+package gl
+
+import (
+	"gosqljobs/invtcommit/functions/constants"
+	"gosqljobs/invtcommit/functions/dec"
+	"gosqljobs/invtcommit/functions/sm"
+	"sort"
+	"strings"
+
+	du "github.com/eaglebush/datautils"
+)
+
+// TrialBalanceLine - balances of an account or of an account roll-up
+type TrialBalanceLine struct {
+	GLAcctNo    string      `json:"glacctno"`
+	Description string      `json:"description"`
+	AcctCatID   int         `json:"acctcatid"`
+	BegBal      dec.Decimal `json:"begbal"`
+	DebitAmt    dec.Decimal `json:"debitamt"`
+	CreditAmt   dec.Decimal `json:"creditamt"`
+	EndBal      dec.Decimal `json:"endbal"`
+}
+
+// glAcctInfo - account information needed to compute and roll balances
+type glAcctInfo struct {
+	GLAcctNo    string
+	Description string
+	AcctCatID   int
+}
+
+// TrialBalance - Returns the beginning balance, debits, credits and ending balance of each
+//                account of a company for a range of fiscal periods from tglAcctHist.
+//
+//                If the beginning balances of the fiscal year have not yet been rolled
+//                by CalcBeginBalance, the beginning balances are computed from the ending
+//                balances of the prior years the same way CalcBeginBalance does: balance
+//                sheet accounts carry their balance forward and income statement accounts
+//                are closed to the retained earnings account.  Nothing is written.
+// ---------------------------------------------------------------------
+// Input Parameters:
+//    iCompanyID      Company ID
+//    iFiscYear       Fiscal year
+//    iFromFiscPer    Starting fiscal period
+//    iToFiscPer      Ending fiscal period
+//    iRollupMask     Optional. Account mask to roll up segments.  Asterisks (*) keep the
+//                    character of the account number, any other character replaces it.
+//                    Accounts with the same masked account number are added together.
+//                    Segment separators are ignored since account numbers are stored
+//                    unformatted.  An empty mask reports each account.
+// Output Parameters:
+//    Result          ReturnValue:
+//                       0 - Unexpected error
+//                       1 - Successful
+//                       2 - Fiscal year or periods not found
+//                       3 - Roll-up mask has an asterisk beyond the length of an account number
+//    Lines           Balances per account or roll-up, ordered by account number
+//    BegBalComputed  True if the beginning balances were computed from the prior years
+// ---------------------------------------------------------------------
+func TrialBalance(
+	bq *du.BatchQuery,
+	iCompanyID string,
+	iFiscYear string,
+	iFromFiscPer int,
+	iToFiscPer int,
+	iRollupMask string) (Result constants.ResultConstant, Lines []TrialBalanceLine, BegBalComputed bool) {
+
+	bq.ScopeName("TrialBalance")
+
+	qr := bq.Get(`SELECT COUNT(*)
+				  FROM tglFiscalPeriod WITH (NOLOCK)
+				  WHERE CompanyID=? AND FiscYear=? AND FiscPer BETWEEN ? AND ?;`,
+		iCompanyID, iFiscYear, iFromFiscPer, iToFiscPer)
+	if !bq.OK() {
+		return constants.ResultError, nil, false
+	}
+	if qr.First().ValueInt64Ord(0) == 0 || iFromFiscPer > iToFiscPer {
+		return constants.ResultFail, nil, false
+	}
+
+	accts, ok := getGLAcctInfo(bq, iCompanyID)
+	if !ok {
+		return constants.ResultError, nil, false
+	}
+
+	// Every asterisk of the mask must fall within the account numbers
+	rollupMask := sm.UnformatAcct(iRollupMask)
+	if last := strings.LastIndex(rollupMask, "*"); last != -1 {
+		for _, a := range accts {
+			if len(a.GLAcctNo) <= last {
+				return constants.ResultConstant(3), nil, false
+			}
+		}
+	}
+
+	// Beginning balance of the year
+	begBal, BegBalComputed, ok := yearBeginBalance(bq, iCompanyID, iFiscYear, accts)
+	if !ok {
+		return constants.ResultError, nil, false
+	}
+
+	// Activity of the periods before the starting period
+	qr = bq.Get(`SELECT h.GLAcctKey, SUM(h.DebitAmt) - SUM(h.CreditAmt)
+				 FROM tglAcctHist h WITH (NOLOCK)
+					INNER JOIN tglAccount a WITH (NOLOCK) ON h.GLAcctKey = a.GLAcctKey
+				 WHERE a.CompanyID=? AND h.FiscYear=? AND h.FiscPer < ?
+				 GROUP BY h.GLAcctKey;`, iCompanyID, iFiscYear, iFromFiscPer)
+	if !bq.OK() {
+		return constants.ResultError, nil, false
+	}
+	for _, v := range qr.Data {
+		k := v.ValueInt64Ord(0)
+		amt, err := dec.Ord(v, 1)
+		if err != nil {
+			return constants.ResultError, nil, false
+		}
+		begBal[k] = begBal[k].Add(amt)
+	}
+
+	// Activity of the periods
+	lines := make(map[string]*TrialBalanceLine)
+	line := func(glAcctKey int64) *TrialBalanceLine {
+		a := accts[glAcctKey]
+		acctNo := a.GLAcctNo
+		desc := a.Description
+		if rollupMask != "" {
+			acctNo = sm.SubstAcct(a.GLAcctNo, rollupMask)
+			desc = ""
+		}
+
+		l, ok := lines[acctNo]
+		if !ok {
+			l = &TrialBalanceLine{GLAcctNo: acctNo, Description: desc, AcctCatID: a.AcctCatID}
+			lines[acctNo] = l
+		}
+		return l
+	}
+
+	for k, v := range begBal {
+		if _, ok := accts[k]; ok && !v.IsZero() {
+			l := line(k)
+			l.BegBal = l.BegBal.Add(v)
+		}
+	}
+
+	qr = bq.Get(`SELECT h.GLAcctKey, SUM(h.DebitAmt), SUM(h.CreditAmt)
+				 FROM tglAcctHist h WITH (NOLOCK)
+					INNER JOIN tglAccount a WITH (NOLOCK) ON h.GLAcctKey = a.GLAcctKey
+				 WHERE a.CompanyID=? AND h.FiscYear=? AND h.FiscPer BETWEEN ? AND ?
+				 GROUP BY h.GLAcctKey;`, iCompanyID, iFiscYear, iFromFiscPer, iToFiscPer)
+	if !bq.OK() {
+		return constants.ResultError, nil, false
+	}
+	for _, v := range qr.Data {
+		k := v.ValueInt64Ord(0)
+		if _, ok := accts[k]; !ok {
+			continue
+		}
+
+		debit, err := dec.Ord(v, 1)
+		if err != nil {
+			return constants.ResultError, nil, false
+		}
+		credit, err := dec.Ord(v, 2)
+		if err != nil {
+			return constants.ResultError, nil, false
+		}
+
+		l := line(k)
+		l.DebitAmt = l.DebitAmt.Add(debit)
+		l.CreditAmt = l.CreditAmt.Add(credit)
+	}
+
+	Lines = make([]TrialBalanceLine, 0, len(lines))
+	for _, l := range lines {
+		l.EndBal = l.BegBal.Add(l.DebitAmt).Sub(l.CreditAmt)
+		Lines = append(Lines, *l)
+	}
+
+	sort.Slice(Lines, func(i, j int) bool {
+		return Lines[i].GLAcctNo < Lines[j].GLAcctNo
+	})
+
+	return constants.ResultSuccess, Lines, BegBalComputed
+}
+
+// yearBeginBalance - returns the beginning balances of a fiscal year per GLAcctKey.
+// When the year has not been rolled, the ending balances of the prior year are
+// carried forward to the balance sheet accounts and the retained earnings accounts.
+func yearBeginBalance(
+	bq *du.BatchQuery,
+	iCompanyID string,
+	iFiscYear string,
+	accts map[int64]glAcctInfo) (BegBal map[int64]dec.Decimal, Computed bool, OK bool) {
+
+	BegBal = make(map[int64]dec.Decimal)
+
+	qr := bq.Get(`SELECT h.GLAcctKey, SUM(h.BegBal)
+				  FROM tglAcctHist h WITH (NOLOCK)
+					INNER JOIN tglAccount a WITH (NOLOCK) ON h.GLAcctKey = a.GLAcctKey
+				  WHERE a.CompanyID=? AND h.FiscYear=? AND h.FiscPer=1
+				  GROUP BY h.GLAcctKey
+				  HAVING SUM(h.BegBal) <> 0;`, iCompanyID, iFiscYear)
+	if !bq.OK() {
+		return nil, false, false
+	}
+
+	// The year has been rolled
+	if qr.HasData {
+		for _, v := range qr.Data {
+			bal, err := dec.Ord(v, 1)
+			if err != nil {
+				return nil, false, false
+			}
+			BegBal[v.ValueInt64Ord(0)] = bal
+		}
+		return BegBal, false, true
+	}
+
+	lPriorFiscYear, _ := FSGivePriorYearPeriod(bq, iCompanyID, iFiscYear)
+	if lPriorFiscYear == "" {
+		return BegBal, false, true
+	}
+
+	// Ending balances of the prior year
+	priorBal, _, ok := yearBeginBalance(bq, iCompanyID, lPriorFiscYear, accts)
+	if !ok {
+		return nil, false, false
+	}
+
+	qr = bq.Get(`SELECT h.GLAcctKey, SUM(h.DebitAmt) - SUM(h.CreditAmt)
+				 FROM tglAcctHist h WITH (NOLOCK)
+					INNER JOIN tglAccount a WITH (NOLOCK) ON h.GLAcctKey = a.GLAcctKey
+				 WHERE a.CompanyID=? AND h.FiscYear=?
+				 GROUP BY h.GLAcctKey;`, iCompanyID, lPriorFiscYear)
+	if !bq.OK() {
+		return nil, false, false
+	}
+	for _, v := range qr.Data {
+		k := v.ValueInt64Ord(0)
+		amt, err := dec.Ord(v, 1)
+		if err != nil {
+			return nil, false, false
+		}
+		priorBal[k] = priorBal[k].Add(amt)
+	}
+
+	BegBal, ok = closeYearBalances(bq, iCompanyID, priorBal, accts)
+	if !ok {
+		return nil, false, false
+	}
+
+	return BegBal, true, true
+}
+
+// closeYearBalances - rolls the ending balances of a fiscal year into the beginning
+// balances of the next year.  Balance sheet accounts carry their balance forward and
+// income statement accounts are closed to the masked retained earnings account.
+func closeYearBalances(
+	bq *du.BatchQuery,
+	iCompanyID string,
+	iEndBal map[int64]dec.Decimal,
+	accts map[int64]glAcctInfo) (BegBal map[int64]dec.Decimal, OK bool) {
+
+	BegBal = make(map[int64]dec.Decimal)
+
+	lRetainedEarnAcct := ""
+	lClearNonFin := false
+	qr := bq.Get(`SELECT RetainedEarnAcct, ClearNonFin
+				 FROM tglOptions WITH (NOLOCK)
+				 WHERE CompanyID=?`, iCompanyID)
+	if !bq.OK() {
+		return nil, false
+	}
+	if qr.HasData {
+		lRetainedEarnAcct = qr.First().ValueString("RetainedEarnAcct")
+		lClearNonFin = qr.First().ValueBool("ClearNonFin")
+	}
+
+	acctKeys := make(map[string]int64, len(accts))
+	for k, a := range accts {
+		acctKeys[a.GLAcctNo] = k
+	}
+
+	for k, bal := range iEndBal {
+		a, ok := accts[k]
+		if !ok || bal.IsZero() {
+			continue
+		}
+
+		if sm.InIntArray(&[]int{1, 2, 3}, a.AcctCatID) || (a.AcctCatID == 9 && !lClearNonFin) {
+			BegBal[k] = BegBal[k].Add(bal)
+			continue
+		}
+
+		if sm.InIntArray(&[]int{4, 5, 6, 7, 8}, a.AcctCatID) {
+			if rk, ok := acctKeys[sm.SubstAcct(a.GLAcctNo, lRetainedEarnAcct)]; ok {
+				BegBal[rk] = BegBal[rk].Add(bal)
+			}
+		}
+	}
+
+	return BegBal, true
+}
+
+// getGLAcctInfo - returns the accounts of a company with their account category
+func getGLAcctInfo(bq *du.BatchQuery, iCompanyID string) (Accts map[int64]glAcctInfo, OK bool) {
+	Accts = make(map[int64]glAcctInfo)
+
+	qr := bq.Get(`SELECT a.GLAcctKey, a.GLAcctNo, a.Description, d.AcctCatID
+				 FROM tglAccount a WITH (NOLOCK)
+					INNER JOIN tglNaturalAcct b WITH (NOLOCK) ON (a.NaturalAcctKey = b.NaturalAcctKey)
+					INNER JOIN tglAcctType c WITH (NOLOCK) ON (b.AcctTypeKey = c.AcctTypeKey)
+					INNER JOIN tglAcctCategory d WITH (NOLOCK) ON (c.AcctCategoryKey = d.AcctCategoryKey)
+				 WHERE a.CompanyID=?;`, iCompanyID)
+	if !bq.OK() {
+		return nil, false
+	}
+
+	for _, v := range qr.Data {
+		Accts[v.ValueInt64("GLAcctKey")] = glAcctInfo{
+			GLAcctNo:    v.ValueString("GLAcctNo"),
+			Description: v.ValueString("Description"),
+			AcctCatID:   int(v.ValueInt64("AcctCatID")),
+		}
+	}
+
+	return Accts, true
+}
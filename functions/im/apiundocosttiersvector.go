@@ -7,13 +7,17 @@ import (
 )
 
 // APIUndoCostTiersVector - Undoes the update of cost tiers
+//   The pending quantities of the cost tiers are reduced by the distributed
+//   quantities of the transactions in one UPDATE.  The cost tiers are locked in
+//   WhseKey, ItemKey, CostingDate, CostTierKey order and all the changes are
+//   made in a single transaction.
 //   Input Values:
 //   ENVIRONMENT:
 //   1) #timUndoCostTierTranWrk must be populated with the records to be deleted.
 //   Output Values:
 //   @_oRetVal                    Return value
-//   0 = success
-//   1 = failure
+//   1 = success
+//   2 = failure.  Nothing is changed.
 func APIUndoCostTiersVector(bq *du.BatchQuery) constants.ResultConstant {
 	bq.ScopeName("APIUndoCostTiersVector")
 
@@ -62,91 +66,65 @@ func APIUndoCostTiersVector(bq *du.BatchQuery) constants.ResultConstant {
 
 	defer bq.Set(`TRUNCATE TABLE #timUndoCostTierTranWrk;`)
 
-	bq.Set(`INSERT #timUndoCostTierWrk
-				SELECT DISTINCT itc.costtierkey,0,0
-				FROM #timUndoCostTierTranWrk ucttw
-					INNER JOIN timInvtTranCost itc WITH (nolock) ON ucttw.invttrankey = itc.invttrankey
-				WHERE ucttw.eoi IN (?,?);`, constants.InventoryDecrease, constants.InventoryIncrease)
+	// Sum the distributed quantities of the transactions to undo by cost tier
+	bq.Set(`INSERT #timUndoCostTierWrk (costtierkey, pendqtydecrease, pendqtyincrease)
+			SELECT itc.CostTierKey,
+				COALESCE(SUM(CASE WHEN ucttw.eoi=? THEN itc.DistQty ELSE 0 END), 0),
+				COALESCE(SUM(CASE WHEN ucttw.eoi=? THEN itc.DistQty ELSE 0 END), 0)
+			FROM #timUndoCostTierTranWrk ucttw
+				INNER JOIN timInvtTranCost itc WITH (nolock) ON ucttw.invttrankey = itc.invttrankey
+			WHERE ucttw.eoi IN (?,?)
+			GROUP BY itc.CostTierKey;`,
+		constants.InventoryDecrease, constants.InventoryIncrease,
+		constants.InventoryDecrease, constants.InventoryIncrease)
 
 	// Just checking if the query went through without errors. Not necessarily has affected rows
 	if !bq.OK() {
 		return constants.ResultFail
 	}
 
-	// Update decrease
-	bq.Set(`UPDATE uctw
-				 SET uctw.PendQtyDecrease=invact.DistQty 
-				 FROM #timUndoCostTierWrk utcw
-				 	INNER JOIN (SELECT COALESCE(SUM(itc.distqty),0) DistQty, itc.CostTierKey
-							  	FROM timInvtTranCost itc WITH (nolock)
-									INNER JOIN #timUndoCostTierTranWrk ucttw ON itc.invttrankey = ucttw.invttrankey  
-								  WHERE ucttw.eoi=?) AS invact ON utcw.CostTierKey=invact.CostTierKey;`, constants.InventoryDecrease)
-
-	// Just checking if the query went through without errors. Not necessarily has affected rows
+	// To resolve deadlock issues, use index to sort the records in the temp table
+	// before join with the permanent table, so that the locks placed
+	// on timCostTier will be in the same order as other processes.
+	bq.Set(`INSERT INTO #timUndoCostTierWrk1 (whsekey, itemkey, costingdate, costtierkey, pendqtydecrease, pendqtyincrease)
+			SELECT ct.WhseKey, ct.ItemKey, ct.CostingDate, ct.CostTierKey, uctw.pendqtydecrease, uctw.pendqtyincrease
+			FROM #timUndoCostTierWrk uctw
+				INNER JOIN timCostTier ct WITH (nolock) ON ct.CostTierKey=uctw.costtierkey;`)
 	if !bq.OK() {
 		return constants.ResultFail
 	}
 
-	// Update increase
-	bq.Set(`UPDATE uctw
-				 SET uctw.PendQtyIncrease=invact.DistQty 
-				 FROM #timUndoCostTierWrk utcw
-				 	INNER JOIN (SELECT COALESCE(SUM(itc.distqty),0) DistQty, itc.CostTierKey
-							  	FROM timInvtTranCost itc WITH (nolock)
-									INNER JOIN #timUndoCostTierTranWrk ucttw ON itc.invttrankey = ucttw.invttrankey  
-								  WHERE ucttw.eoi=?) AS invact ON utcw.CostTierKey=invact.CostTierKey;`, constants.InventoryIncrease)
+	// The cost tiers, the transfer costs and the transaction costs are undone together
+	bq.Set(`BEGIN TRAN;`)
 
-	// Just checking if the query went through without errors. Not necessarily has affected rows
-	if !bq.OK() {
+	// rollback - undoes everything done since BEGIN TRAN
+	rollback := func() constants.ResultConstant {
+		bq.Waive()
+		bq.Set(`IF @@TRANCOUNT > 0 ROLLBACK;`)
 		return constants.ResultFail
 	}
 
-	// To resolve deadlock issues, use index to sort the records in the temp table
-	// before join with the permanent table, so that the locks placed
-	// on timCostTier will be in the same order as other processes.
-	qr = bq.Set(`INSERT INTO #timUndoCostTierWrk1
-				 SELECT ct.WhseKey, ct.ItemKey, ct.CostingDate,	ct.CostTierKey,	uctw.PendQtyDecrease, uctw.PendQtyIncrease
-				 FROM #timUndoCostTierWrk uctw
-					INNER JOIN timCostTier ct WITH (nolock) ON ct.CostTierKey=uctw.CostTierKey;`)
-	if qr.HasAffectedRows {
-		// We don't use the UPDATE FROM statement here to update the pending quantities here because this is often unreliable
-		qr = bq.Get(`SELECT ct.CostTierKey, 
-							ct.PendingQtyIncrease - uctw.PendQtyIncrease AS NewPendingQtyIncrease,
-							ct.PendingQtyDecrease - uctw.PendingQtyDecrease AS NewPendingQtyDecrease
-					 FROM #timUndoCostTierWrk1 uctw
-						INNER JOIN timCostTier ct ON ct.WhseKey=uctw.WhseKey
-					 		AND ct.ItemKey=uctw.ItemKey
-							AND ct.CostingDate=uctw.CostingDate
-							AND ct.CostTierKey=uctw.CostTierKey);`)
-
-		if qr.HasData {
-			// Instead we loop to update the quantities to be sure
-			for _, v := range qr.Data {
-
-				ck := v.ValueInt64Ord(0)
-				pqi := v.ValueFloat64Ord(1)
-				pqd := v.ValueFloat64Ord(2)
-
-				qr2 := bq.Set(`UPDATE timCostTier SET PendingQtyIncrease=?, PendingQtyDecrease=? WHERE CostTierKey=?;`, pqi, pqd, ck)
-				if !qr2.HasAffectedRows {
-					return constants.ResultFail
-				}
-			}
-		}
+	// Lock the cost tiers in the order of the clustered index of the work table
+	// (WhseKey, ItemKey, CostingDate, CostTierKey) before they are updated.
+	bq.Get(`SELECT ct.CostTierKey
+			FROM #timUndoCostTierWrk1 uctw
+				INNER LOOP JOIN timCostTier ct WITH (UPDLOCK, ROWLOCK) ON ct.CostTierKey=uctw.costtierkey
+			ORDER BY uctw.whsekey, uctw.itemkey, uctw.costingdate, uctw.costtierkey
+			OPTION (FORCE ORDER);`)
+	if !bq.OK() {
+		return rollback()
 	}
 
-	// Changed the query to an inner join
-	bq.Set(`DELETE timCostTier
+	bq.Set(`UPDATE ct
+			SET ct.PendQtyIncrease = ct.PendQtyIncrease - uctw.pendqtyincrease,
+				ct.PendQtyDecrease = ct.PendQtyDecrease - uctw.pendqtydecrease
 			FROM timCostTier ct
-				INNER JOIN #timUndoCostTierWrk uctw	
-					ON ct.costtierkey=uctw.costtierkey 
-						AND ct.origqty=0
-						AND ct.pendqtydecrease=0
-						AND ct.pendqtyincrease=0
-						AND ct.qtyused=0
-						AND ct.status=?;`, constants.InventoryStatusPending)
+				INNER JOIN #timUndoCostTierWrk1 uctw ON ct.WhseKey=uctw.whsekey
+					AND ct.ItemKey=uctw.itemkey
+					AND ct.CostingDate=uctw.costingdate
+					AND ct.CostTierKey=uctw.costtierkey;`)
 	if !bq.OK() {
-		return constants.ResultFail
+		return rollback()
 	}
 
 	// 	We'll only delete timTrnsfrCost records for transit warehouse
@@ -165,7 +143,7 @@ func APIUndoCostTiersVector(bq *du.BatchQuery) constants.ResultConstant {
 				LEFT JOIN timPendInvtTran pit (nolock) ON t.invttrankey=pit.invttrankey
 			WHERE w.eoi=? AND pit.invttrankey IS NULL;`, constants.InventoryIncrease)
 	if !bq.OK() {
-		return constants.ResultFail
+		return rollback()
 	}
 
 	// Delete from timInvtTranCost
@@ -173,6 +151,25 @@ func APIUndoCostTiersVector(bq *du.BatchQuery) constants.ResultConstant {
 			FROM #timUndoCostTierTranWrk ucttw
 				INNER JOIN timInvtTranCost tc ON tc.InvtTranKey=ucttw.InvtTranKey
 			WHERE ucttw.eoi IN (?,?);`, constants.InventoryIncrease, constants.InventoryDecrease)
+	if !bq.OK() {
+		return rollback()
+	}
+
+	// Pending cost tiers left without any quantity are no longer needed.
+	// This is done after the transaction costs pointing to them are removed.
+	bq.Set(`DELETE ct
+			FROM timCostTier ct
+				INNER JOIN #timUndoCostTierWrk1 uctw ON ct.CostTierKey=uctw.costtierkey
+			WHERE ct.OrigQty=0
+				AND ct.PendQtyDecrease=0
+				AND ct.PendQtyIncrease=0
+				AND ct.QtyUsed=0
+				AND ct.Status=?;`, constants.InventoryStatusPending)
+	if !bq.OK() {
+		return rollback()
+	}
+
+	bq.Set(`COMMIT;`)
 	if !bq.OK() {
 		return constants.ResultFail
 	}
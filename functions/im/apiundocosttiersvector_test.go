@@ -0,0 +1,173 @@
+package im
+
+import (
+	"gosqljobs/invtcommit/functions/constants"
+	"gosqljobs/invtcommit/functions/dec"
+	"gosqljobs/invtcommit/functions/sm"
+	"gosqljobs/invtcommit/functions/testdb"
+	"testing"
+	"time"
+
+	du "github.com/eaglebush/datautils"
+)
+
+// undoFixture - a cost tier and the transaction cost of one transaction to undo
+type undoFixture struct {
+	CostTierKey int
+	InvtTranKey int
+}
+
+// newUndoFixture - inserts a cost tier with a transaction cost of iDistQty and
+// queues the transaction in #timUndoCostTierTranWrk
+func newUndoFixture(
+	t *testing.T,
+	bq *du.BatchQuery,
+	iStatus constants.InventoryStatusConstant,
+	iOrigQty, iPendQtyIncrease, iPendQtyDecrease, iDistQty dec.Decimal,
+	iEOI constants.InventoryActionConstant,
+	iTrnsfrOrderLineKey int) undoFixture {
+
+	t.Helper()
+
+	whseKey := testdb.Int(t, "INVTCOMMIT_TEST_WHSEKEY")
+	itemKey := testdb.Int(t, "INVTCOMMIT_TEST_ITEMKEY")
+
+	f := undoFixture{
+		CostTierKey: sm.GetNextSurrogateKey(bq, `timCostTier`),
+		InvtTranKey: sm.GetNextSurrogateKey(bq, `timInvtTran`),
+	}
+
+	bq.Set(`INSERT INTO timCostTier (
+				CostTierKey, WhseKey, ItemKey, CostingDate, OrigQty,
+				PendQtyIncrease, PendQtyDecrease, QtyUsed, Status, UnitCost)
+			VALUES (?, ?, ?, ?, ?, ?, ?, 0, ?, 1);`,
+		f.CostTierKey, whseKey, itemKey, time.Now().Format("2006-01-02"), iOrigQty,
+		iPendQtyIncrease, iPendQtyDecrease, iStatus)
+	bq.Set(`INSERT INTO timInvtTranCost (InvtTranKey, CostTierKey, DistQty) VALUES (?, ?, ?);`,
+		f.InvtTranKey, f.CostTierKey, iDistQty)
+
+	var lineKey interface{}
+	if iTrnsfrOrderLineKey != 0 {
+		lineKey = iTrnsfrOrderLineKey
+		bq.Set(`INSERT INTO timTrnsfrCost (CostTierKey, TrnsfrOrderLineKey) VALUES (?, ?);`,
+			f.CostTierKey, iTrnsfrOrderLineKey)
+	}
+
+	bq.Set(`IF OBJECT_ID('tempdb..#timUndoCostTierTranWrk') IS NULL
+				CREATE TABLE #timUndoCostTierTranWrk
+				(
+					invttrankey        INTEGER NOT NULL,
+					trnsfrorderlinekey INTEGER NULL,
+					eoi                SMALLINT NOT NULL
+				);`)
+	bq.Set(`INSERT INTO #timUndoCostTierTranWrk (invttrankey, trnsfrorderlinekey, eoi) VALUES (?, ?, ?);`,
+		f.InvtTranKey, lineKey, iEOI)
+	if !bq.OK() {
+		t.Fatalf("Fixture not created: %s", bq.LastErrorText())
+	}
+
+	return f
+}
+
+// count - returns the number of rows of a query
+func count(t *testing.T, bq *du.BatchQuery, iSQL string, iArgs ...interface{}) int64 {
+	t.Helper()
+
+	qr := bq.Get(iSQL, iArgs...)
+	if !bq.OK() {
+		t.Fatalf("%s: %s", iSQL, bq.LastErrorText())
+	}
+
+	return qr.First().ValueInt64Ord(0)
+}
+
+// col - reads a decimal column of a row
+func col(t *testing.T, r interface{ ValueString(string) string }, iName string) dec.Decimal {
+	t.Helper()
+
+	d, err := dec.Col(r, iName)
+	if err != nil {
+		t.Fatalf("%s: %s", iName, err)
+	}
+
+	return d
+}
+
+// TestAPIUndoCostTiersVectorDecrease - the pending decrease of an active cost tier
+// is given back and the transaction cost is removed
+func TestAPIUndoCostTiersVectorDecrease(t *testing.T) {
+	bq := testdb.Open(t)
+	testdb.Begin(t, bq)
+
+	f := newUndoFixture(t, bq, constants.InventoryStatusActive,
+		dec.FromInt(10), dec.FromInt(0), dec.FromInt(6), dec.FromInt(4),
+		constants.InventoryDecrease, 0)
+
+	if res := APIUndoCostTiersVector(bq); res != constants.ResultSuccess {
+		t.Fatalf("APIUndoCostTiersVector returned %d: %s", res, bq.LastErrorText())
+	}
+
+	qr := bq.Get(`SELECT PendQtyIncrease, PendQtyDecrease FROM timCostTier WITH (NOLOCK) WHERE CostTierKey=?;`, f.CostTierKey)
+	if !qr.HasData {
+		t.Fatalf("Cost tier %d deleted", f.CostTierKey)
+	}
+	if q := col(t, qr.First(), "PendQtyDecrease"); !q.Equal(dec.FromInt(2)) {
+		t.Errorf("PendQtyDecrease is %s, want 2", q)
+	}
+	if q := col(t, qr.First(), "PendQtyIncrease"); !q.IsZero() {
+		t.Errorf("PendQtyIncrease is %s, want 0", q)
+	}
+
+	if n := count(t, bq, `SELECT COUNT(*) FROM timInvtTranCost WITH (NOLOCK) WHERE InvtTranKey=?;`, f.InvtTranKey); n != 0 {
+		t.Errorf("%d timInvtTranCost rows left", n)
+	}
+	if n := count(t, bq, `SELECT COUNT(*) FROM #timUndoCostTierTranWrk;`); n != 0 {
+		t.Errorf("%d #timUndoCostTierTranWrk rows left", n)
+	}
+}
+
+// TestAPIUndoCostTiersVectorIncrease - a pending cost tier left without any quantity
+// is deleted
+func TestAPIUndoCostTiersVectorIncrease(t *testing.T) {
+	bq := testdb.Open(t)
+	testdb.Begin(t, bq)
+
+	f := newUndoFixture(t, bq, constants.InventoryStatusPending,
+		dec.FromInt(0), dec.FromInt(5), dec.FromInt(0), dec.FromInt(5),
+		constants.InventoryIncrease, 0)
+
+	if res := APIUndoCostTiersVector(bq); res != constants.ResultSuccess {
+		t.Fatalf("APIUndoCostTiersVector returned %d: %s", res, bq.LastErrorText())
+	}
+
+	if n := count(t, bq, `SELECT COUNT(*) FROM timCostTier WITH (NOLOCK) WHERE CostTierKey=?;`, f.CostTierKey); n != 0 {
+		t.Errorf("Pending cost tier %d not deleted", f.CostTierKey)
+	}
+	if n := count(t, bq, `SELECT COUNT(*) FROM timInvtTranCost WITH (NOLOCK) WHERE InvtTranKey=?;`, f.InvtTranKey); n != 0 {
+		t.Errorf("%d timInvtTranCost rows left", n)
+	}
+}
+
+// TestAPIUndoCostTiersVectorTransit - the transfer cost of a transit transfer in is
+// deleted with its cost tier
+func TestAPIUndoCostTiersVectorTransit(t *testing.T) {
+	bq := testdb.Open(t)
+	lineKey := testdb.Int(t, "INVTCOMMIT_TEST_TRNSFRORDERLINEKEY")
+	testdb.Begin(t, bq)
+
+	f := newUndoFixture(t, bq, constants.InventoryStatusPending,
+		dec.FromInt(0), dec.FromInt(3), dec.FromInt(0), dec.FromInt(3),
+		constants.InventoryIncrease, lineKey)
+
+	if res := APIUndoCostTiersVector(bq); res != constants.ResultSuccess {
+		t.Fatalf("APIUndoCostTiersVector returned %d: %s", res, bq.LastErrorText())
+	}
+
+	if n := count(t, bq, `SELECT COUNT(*) FROM timTrnsfrCost WITH (NOLOCK) WHERE CostTierKey=? AND TrnsfrOrderLineKey=?;`,
+		f.CostTierKey, lineKey); n != 0 {
+		t.Errorf("timTrnsfrCost of cost tier %d not deleted", f.CostTierKey)
+	}
+	if n := count(t, bq, `SELECT COUNT(*) FROM timCostTier WITH (NOLOCK) WHERE CostTierKey=?;`, f.CostTierKey); n != 0 {
+		t.Errorf("Pending cost tier %d not deleted", f.CostTierKey)
+	}
+}
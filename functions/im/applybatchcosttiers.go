@@ -0,0 +1,160 @@
+package im
+
+import (
+	"gosqljobs/invtcommit/functions/constants"
+	"gosqljobs/invtcommit/functions/dec"
+	"strings"
+
+	du "github.com/eaglebush/datautils"
+)
+
+// costTransferOutTranTypes - tran types whose cost is given to the receipt in their SourceInvtTranKey
+var costTransferOutTranTypes = []interface{}{
+	constants.POTranTypeTransOut,
+	constants.IMTranTypeTransfOut,
+}
+
+// ApplyBatchCostTiers - Costs the inventory transactions of a disposable batch through their cost tiers
+// ---------------------------------------------------------------------
+// The inventory transactions are read from the pending IM posting rows (timPosting)
+// of the batch.  Only the rows that change the quantity on hand (timTranType.QtyOnHandEffect)
+// are costed, so drop shipments are skipped.  An adjustment is an increase or a decrease
+// by the sign of its quantity.  Transactions that already have cost tier
+// distributions (timInvtTranCost) are skipped so the routine can be run again after a
+// failure.  A transfer out that was costed by an earlier run still gives its cost, read
+// from its distributions, to its receipt.
+//
+//    SO  810 Customer Shipment, 812 Transfer Shipment      use the cost tiers of the warehouse
+//        811 Customer Return                               new pending cost tier at the unit cost returned
+//    IM  707 Issue, 712 and 713 kit decreases, 710 Adjustment (decrease)
+//                                                          use the cost tiers of the warehouse
+//        711 and 714 kit increases, 710 Adjustment (increase)
+//                                                          new pending cost tier at the unit cost of the posting row
+//        706 Transfer Out                                  uses the cost tiers of the warehouse it leaves
+//        705 Transfer In                                   new pending cost tier at the cost of its transfer out
+//    PO  1110 Receipt from Vendor                          new pending cost tier at the unit cost received
+//        1111 PO Return                                    uses the cost tiers of the warehouse
+//        1112 Receipt from Warehouse                       new pending cost tier at the cost of its transfer out
+//        1113 Transfer Out (Transit)                       uses the cost tiers of the transit warehouse
+//
+// It is called by CommitInvtTrans for each disposable batch once the posting rows of
+// the batch are created.
+//
+// The transfers out are costed first so that the receipts carry the cost out of the
+// warehouse the goods left.  They are written in a separate transaction; when the
+// other transactions fail, the batch is undone with PostAPIUndoCostTiersUpdate.
+//
+// Input Parameters:
+//    @_iBatchKey         Disposable batch of the transactions
+//    @_iAllowNegative    Allow the quantity on hand to go below zero
+// Output Parameters:
+//    oRetVal      ReturnValue:
+// 	  0 - Did not make it through the procedure
+// 	  1 - Successful
+// 	  2 - Not enough quantity in the cost tiers
+// 	  3 - Item not found in the warehouse (timInventory)
+// 	  4 - Invalid quantity or inventory effect
+//    oResults     Cost of each transaction
+// ---------------------------------------------------------------------
+func ApplyBatchCostTiers(
+	bq *du.BatchQuery,
+	iBatchKey int,
+	iAllowNegative bool) (Result constants.ResultConstant, Results []CostTierResult) {
+
+	bq.ScopeName("ApplyBatchCostTiers")
+
+	tot := `?` + strings.Repeat(`,?`, len(costTransferOutTranTypes)-1)
+	qr := bq.Get(`SELECT p.InvtTranKey, p.WhseKey, p.ItemKey, p.TranType, e.QtyOnHandEffect,
+					ABS(p.TranQty) AS TranQty, COALESCE(p.UnitCost, 0) AS UnitCost,
+					COALESCE(p.SourceInvtTranKey, 0) AS SourceInvtTranKey, p.TranDate,
+					CASE WHEN p.TranType IN (`+tot+`) THEN 1 ELSE 0 END AS TransferOut,
+					c.CostRows, COALESCE(c.CostQty, 0) AS CostQty, COALESCE(c.CostExt, 0) AS CostExt
+				  FROM timPosting p WITH (NOLOCK)
+					JOIN timTranType tt WITH (NOLOCK) ON p.TranType = tt.TranType
+					CROSS APPLY (SELECT CASE WHEN p.TranType = ? THEN SIGN(p.TranQty)
+										ELSE tt.QtyOnHandEffect END AS QtyOnHandEffect) e
+					OUTER APPLY (SELECT COUNT(*) AS CostRows,
+									SUM(ABS(itc.DistQty)) AS CostQty,
+									SUM(ABS(itc.DistQty) * ct.UnitCost) AS CostExt
+								 FROM timInvtTranCost itc WITH (NOLOCK)
+									JOIN timCostTier ct WITH (NOLOCK) ON itc.CostTierKey = ct.CostTierKey
+								 WHERE itc.InvtTranKey = p.InvtTranKey) c
+				  WHERE p.BatchKey=?
+					AND p.TranQty <> 0
+					AND e.QtyOnHandEffect IN (?,?)
+				  ORDER BY p.InvtTranKey;`,
+		append(append([]interface{}{}, costTransferOutTranTypes...),
+			constants.IMTranTypeAdjustment, iBatchKey, constants.InventoryDecrease, constants.InventoryIncrease)...)
+	if !bq.OK() {
+		return constants.ResultError, nil
+	}
+
+	transfers := make([]CostTierTran, 0)
+	trans := make([]CostTierTran, 0)
+	src := make(map[int]int)          // InvtTranKey of a receipt by its transfer out
+	cost := make(map[int]dec.Decimal) // Unit cost of a receipt by its InvtTranKey
+
+	for _, v := range qr.Data {
+		qty, err := dec.Cols(v, "TranQty", "UnitCost", "CostQty", "CostExt")
+		if err != nil {
+			return constants.ResultError, nil
+		}
+
+		t := CostTierTran{
+			InvtTranKey: int(v.ValueInt64("InvtTranKey")),
+			WhseKey:     int(v.ValueInt64("WhseKey")),
+			ItemKey:     int(v.ValueInt64("ItemKey")),
+			Effect:      constants.InventoryActionConstant(v.ValueInt64("QtyOnHandEffect")),
+			Qty:         qty[0],
+			UnitCost:    qty[1],
+			CostingDate: v.ValueTime("TranDate"),
+		}
+
+		costed := v.ValueInt64("CostRows") > 0
+
+		if v.ValueInt64("TransferOut") == 1 {
+			src[t.InvtTranKey] = int(v.ValueInt64("SourceInvtTranKey"))
+			if !costed {
+				transfers = append(transfers, t)
+				continue
+			}
+
+			// Costed by an earlier run
+			if src[t.InvtTranKey] != 0 && qty[2].Sign() > 0 {
+				cost[src[t.InvtTranKey]] = qty[3].Div(qty[2], dec.CostPlaces)
+			}
+			continue
+		}
+
+		if !costed {
+			trans = append(trans, t)
+		}
+	}
+
+	Results = make([]CostTierResult, 0, len(transfers)+len(trans))
+
+	res, rs := ApplyCostTiers(bq, transfers, iAllowNegative)
+	if res != constants.ResultSuccess {
+		return res, nil
+	}
+	Results = append(Results, rs...)
+
+	// The receipt is received at the cost of its transfer out
+	for _, r := range rs {
+		if k := src[r.InvtTranKey]; k != 0 {
+			cost[k] = r.UnitCost
+		}
+	}
+	for i, t := range trans {
+		if c, ok := cost[t.InvtTranKey]; ok {
+			trans[i].UnitCost = c
+		}
+	}
+
+	res, rs = ApplyCostTiers(bq, trans, iAllowNegative)
+	if res != constants.ResultSuccess {
+		return res, nil
+	}
+
+	return constants.ResultSuccess, append(Results, rs...)
+}
@@ -0,0 +1,383 @@
+package im
+
+import (
+	"gosqljobs/invtcommit/functions/constants"
+	"gosqljobs/invtcommit/functions/dec"
+	"gosqljobs/invtcommit/functions/sm"
+	"sort"
+	"time"
+
+	du "github.com/eaglebush/datautils"
+)
+
+// CostTierTran - an inventory transaction to cost
+type CostTierTran struct {
+	InvtTranKey int
+	WhseKey     int
+	ItemKey     int
+	Effect      constants.InventoryActionConstant // InventoryIncrease or InventoryDecrease
+	Qty         dec.Decimal                       // Quantity in stock UOM.  Always positive.
+	UnitCost    dec.Decimal                       // Increases only.  Unit cost of the new cost tier.
+	CostingDate time.Time                         // Optional. Costing date of a new cost tier (default today)
+}
+
+// CostTierDist - the quantity of a cost tier used by a transaction (timInvtTranCost)
+type CostTierDist struct {
+	CostTierKey int         `json:"costtierkey"`
+	DistQty     dec.Decimal `json:"distqty"`
+	UnitCost    dec.Decimal `json:"unitcost"`
+	Negative    bool        `json:"negative"`
+}
+
+// CostTierResult - the cost of a transaction and the cost tiers it used
+type CostTierResult struct {
+	InvtTranKey   int                             `json:"invttrankey"`
+	ValuationMeth constants.ValuationMethConstant `json:"valuationmeth"`
+	UnitCost      dec.Decimal                     `json:"unitcost"`
+	ExtCost       dec.Decimal                     `json:"extcost"`
+	Dists         []CostTierDist                  `json:"dists"`
+}
+
+// costTier - a cost tier of an item and warehouse being costed
+type costTier struct {
+	CostTierKey int
+	UnitCost    dec.Decimal
+	AvailQty    dec.Decimal
+}
+
+// itemCost - the valuation and the cost tiers of an item in a warehouse
+type itemCost struct {
+	ValuationMeth constants.ValuationMethConstant
+	StdUnitCost   dec.Decimal
+	LastUnitCost  dec.Decimal
+	Tiers         []*costTier // In CostingDate, CostTierKey order
+}
+
+// ApplyCostTiers - Costs inventory transactions and writes their cost tier usage
+// ---------------------------------------------------------------------
+// A decrease uses the available quantity (OrigQty - QtyUsed - PendQtyDecrease) of
+// the active cost tiers of the item and warehouse.  The tiers are used in costing
+// date order; LIFO items use the latest tier first.  The quantity used is added
+// to the PendQtyDecrease of each tier and written to timInvtTranCost.
+//
+// The cost of a decrease depends on the valuation method of the item:
+//    FIFO, LIFO and Actual  the unit costs of the tiers used
+//    Average                the average unit cost of the available tiers
+//    Standard               the standard unit cost of the item in the warehouse
+//
+// When the tiers do not have enough quantity, the rest is taken from a new pending
+// negative tier (PendQtyDecrease only) costed at the standard, average or last unit
+// cost.  This is only done when iAllowNegative is true.
+//
+// An increase creates a new pending cost tier with the quantity in PendQtyIncrease.
+// Standard cost items use the standard unit cost for the tier.
+//
+// Nothing written here is final.  The pending quantities are reversed by
+// PostAPIUndoCostTiersUpdate (APIUndoCostTiersVector), which also removes the
+// pending tiers left without quantity.
+//
+// The cost tiers are locked per warehouse and item in WhseKey, ItemKey order and
+// everything is written in a single transaction.  The keys of the new tiers are
+// taken before the transaction starts.
+//
+// Input Parameters:
+//    @_iTrans            Transactions to cost
+//    @_iAllowNegative    Allow the quantity on hand to go below zero
+// Output Parameters:
+//    oRetVal      ReturnValue:
+// 	  0 - Did not make it through the procedure
+// 	  1 - Successful
+// 	  2 - Not enough quantity in the cost tiers.  Nothing is written.
+// 	  3 - Item not found in the warehouse (timInventory).  Nothing is written.
+// 	  4 - Invalid quantity or inventory effect.  Nothing is written.
+//    oResults     Cost of each transaction in the order of iTrans
+// ---------------------------------------------------------------------
+func ApplyCostTiers(
+	bq *du.BatchQuery,
+	iTrans []CostTierTran,
+	iAllowNegative bool) (Result constants.ResultConstant, Results []CostTierResult) {
+
+	bq.ScopeName("ApplyCostTiers")
+
+	Results = make([]CostTierResult, len(iTrans))
+	if len(iTrans) == 0 {
+		return constants.ResultSuccess, Results
+	}
+
+	// Lock the items in WhseKey, ItemKey order
+	order := make([]int, len(iTrans))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		ta, tb := iTrans[order[a]], iTrans[order[b]]
+		if ta.WhseKey != tb.WhseKey {
+			return ta.WhseKey < tb.WhseKey
+		}
+		return ta.ItemKey < tb.ItemKey
+	})
+
+	for _, t := range iTrans {
+		if t.Qty.Sign() <= 0 || (t.Effect != constants.InventoryIncrease && t.Effect != constants.InventoryDecrease) {
+			return constants.ResultConstant(4), nil
+		}
+	}
+
+	// The keys are taken before BEGIN TRAN so that tciSurrogateKey is not locked while the
+	// tiers are written.  Each transaction gets one key: the tier of an increase or the
+	// negative tier of a decrease.  The keys of the decreases that do not go negative are
+	// not used.
+	lStartKey, _ := sm.GetNextBlockSurrogateKey(bq, `timCostTier`, len(iTrans))
+	bq.ScopeName("ApplyCostTiers")
+	if !bq.OK() || lStartKey == 0 {
+		return constants.ResultError, nil
+	}
+
+	bq.Set(`BEGIN TRAN;`)
+
+	// rollback - undoes everything done since BEGIN TRAN
+	rollback := func(rv constants.ResultConstant) (constants.ResultConstant, []CostTierResult) {
+		bq.Waive()
+		bq.Set(`IF @@TRANCOUNT > 0 ROLLBACK;`)
+		return rv, nil
+	}
+
+	type whseItem struct{ WhseKey, ItemKey int }
+	items := make(map[whseItem]*itemCost)
+
+	for _, i := range order {
+		t := iTrans[i]
+		if t.CostingDate.IsZero() {
+			t.CostingDate = time.Now()
+		}
+
+		k := whseItem{t.WhseKey, t.ItemKey}
+		ic, ok := items[k]
+		if !ok {
+			var res constants.ResultConstant
+			res, ic = loadItemCost(bq, t.WhseKey, t.ItemKey)
+			if res != constants.ResultSuccess {
+				return rollback(res)
+			}
+			items[k] = ic
+		}
+
+		var res constants.ResultConstant
+		if t.Effect == constants.InventoryIncrease {
+			res, Results[i] = ic.increase(bq, t, lStartKey+i)
+		} else {
+			res, Results[i] = ic.decrease(bq, t, iAllowNegative, lStartKey+i)
+		}
+
+		bq.ScopeName("ApplyCostTiers")
+
+		if res != constants.ResultSuccess {
+			return rollback(res)
+		}
+	}
+
+	bq.Set(`COMMIT;`)
+	if !bq.OK() {
+		return constants.ResultError, nil
+	}
+
+	return constants.ResultSuccess, Results
+}
+
+// loadItemCost - reads the valuation of an item in a warehouse and locks its open cost tiers
+func loadItemCost(bq *du.BatchQuery, iWhseKey int, iItemKey int) (Result constants.ResultConstant, Item *itemCost) {
+	qr := bq.Get(`SELECT i.ValuationMeth, COALESCE(inv.StdUnitCost, 0) AS StdUnitCost
+				  FROM timInventory inv WITH (NOLOCK)
+					JOIN timItem i WITH (NOLOCK) ON inv.ItemKey = i.ItemKey
+				  WHERE inv.WhseKey=? AND inv.ItemKey=?;`, iWhseKey, iItemKey)
+	if !bq.OK() {
+		return constants.ResultError, nil
+	}
+	if !qr.HasData {
+		return constants.ResultConstant(3), nil
+	}
+
+	stdUnitCost, err := dec.Col(qr.First(), "StdUnitCost")
+	if err != nil {
+		return constants.ResultError, nil
+	}
+
+	Item = &itemCost{
+		ValuationMeth: constants.ValuationMethConstant(qr.First().ValueInt64("ValuationMeth")),
+		StdUnitCost:   stdUnitCost,
+		Tiers:         make([]*costTier, 0),
+	}
+
+	qr = bq.Get(`SELECT CostTierKey, UnitCost, OrigQty - QtyUsed - PendQtyDecrease AS AvailQty
+				 FROM timCostTier WITH (UPDLOCK, ROWLOCK)
+				 WHERE WhseKey=? AND ItemKey=? AND Status IN (?,?)
+				 ORDER BY CostingDate, CostTierKey;`,
+		iWhseKey, iItemKey, constants.InventoryStatusPending, constants.InventoryStatusActive)
+	if !bq.OK() {
+		return constants.ResultError, nil
+	}
+	for _, v := range qr.Data {
+		tier, err := dec.Cols(v, "UnitCost", "AvailQty")
+		if err != nil {
+			return constants.ResultError, nil
+		}
+
+		Item.Tiers = append(Item.Tiers, &costTier{
+			CostTierKey: int(v.ValueInt64("CostTierKey")),
+			UnitCost:    tier[0],
+			AvailQty:    tier[1],
+		})
+	}
+
+	// The last unit cost includes the closed tiers
+	qr = bq.Get(`SELECT TOP 1 UnitCost
+				 FROM timCostTier WITH (NOLOCK)
+				 WHERE WhseKey=? AND ItemKey=?
+				 ORDER BY CostingDate DESC, CostTierKey DESC;`, iWhseKey, iItemKey)
+	if !bq.OK() {
+		return constants.ResultError, nil
+	}
+	if qr.HasData {
+		if Item.LastUnitCost, err = dec.Ord(qr.First(), 0); err != nil {
+			return constants.ResultError, nil
+		}
+	}
+
+	return constants.ResultSuccess, Item
+}
+
+// decrease - uses the available quantity of the cost tiers.  The negative tier, if any, gets iNegTierKey.
+func (ic *itemCost) decrease(
+	bq *du.BatchQuery,
+	t CostTierTran,
+	iAllowNegative bool,
+	iNegTierKey int) (constants.ResultConstant, CostTierResult) {
+
+	r := CostTierResult{
+		InvtTranKey:   t.InvtTranKey,
+		ValuationMeth: ic.ValuationMeth,
+		Dists:         make([]CostTierDist, 0),
+	}
+
+	availQty, availCost := dec.Zero, dec.Zero
+	for _, ct := range ic.Tiers {
+		if ct.AvailQty.Sign() > 0 {
+			availQty = availQty.Add(ct.AvailQty)
+			availCost = availCost.Add(ct.AvailQty.Mul(ct.UnitCost))
+		}
+	}
+
+	if availQty.Cmp(t.Qty) < 0 && !iAllowNegative {
+		return constants.ResultFail, r
+	}
+
+	avgUnitCost := ic.LastUnitCost
+	if availQty.Sign() > 0 {
+		avgUnitCost = availCost.Div(availQty, dec.CostPlaces)
+	}
+
+	tiers := ic.Tiers
+	if ic.ValuationMeth == constants.ValuationMethLIFO {
+		tiers = make([]*costTier, len(ic.Tiers))
+		for i, ct := range ic.Tiers {
+			tiers[len(ic.Tiers)-1-i] = ct
+		}
+	}
+
+	rem := t.Qty
+	for _, ct := range tiers {
+		if rem.IsZero() {
+			break
+		}
+		if ct.AvailQty.Sign() <= 0 {
+			continue
+		}
+
+		q := ct.AvailQty
+		if q.Cmp(rem) > 0 {
+			q = rem
+		}
+
+		bq.Set(`UPDATE timCostTier SET PendQtyDecrease = PendQtyDecrease + ? WHERE CostTierKey=?;`, q, ct.CostTierKey)
+
+		ct.AvailQty = ct.AvailQty.Sub(q)
+		rem = rem.Sub(q)
+		r.Dists = append(r.Dists, CostTierDist{CostTierKey: ct.CostTierKey, DistQty: q, UnitCost: ct.UnitCost})
+	}
+
+	// Negative inventory
+	if rem.Sign() > 0 {
+		negUnitCost := ic.LastUnitCost
+		switch ic.ValuationMeth {
+		case constants.ValuationMethStandard:
+			negUnitCost = ic.StdUnitCost
+		case constants.ValuationMethAverage:
+			negUnitCost = avgUnitCost
+		}
+
+		key := iNegTierKey
+		bq.Set(`INSERT INTO timCostTier (
+					CostTierKey, WhseKey, ItemKey, CostingDate, OrigQty,
+					PendQtyIncrease, PendQtyDecrease, QtyUsed, Status, UnitCost)
+				VALUES (?, ?, ?, ?, 0, 0, ?, 0, ?, ?);`,
+			key, t.WhseKey, t.ItemKey, t.CostingDate.Format("2006-01-02"), rem, constants.InventoryStatusPending, negUnitCost)
+
+		ic.Tiers = append(ic.Tiers, &costTier{CostTierKey: key, UnitCost: negUnitCost, AvailQty: rem.Neg()})
+		r.Dists = append(r.Dists, CostTierDist{CostTierKey: key, DistQty: rem, UnitCost: negUnitCost, Negative: true})
+	}
+
+	for _, d := range r.Dists {
+		bq.Set(`INSERT INTO timInvtTranCost (InvtTranKey, CostTierKey, DistQty) VALUES (?, ?, ?);`,
+			t.InvtTranKey, d.CostTierKey, d.DistQty)
+	}
+	if !bq.OK() {
+		return constants.ResultError, r
+	}
+
+	switch ic.ValuationMeth {
+	case constants.ValuationMethStandard:
+		r.ExtCost = t.Qty.Mul(ic.StdUnitCost).RoundAmt()
+	case constants.ValuationMethAverage:
+		r.ExtCost = t.Qty.Mul(avgUnitCost).RoundAmt()
+	default:
+		for _, d := range r.Dists {
+			r.ExtCost = r.ExtCost.Add(d.DistQty.Mul(d.UnitCost))
+		}
+		r.ExtCost = r.ExtCost.RoundAmt()
+	}
+	r.UnitCost = r.ExtCost.Div(t.Qty, dec.CostPlaces)
+
+	return constants.ResultSuccess, r
+}
+
+// increase - creates a pending cost tier with the key iTierKey for the quantity received
+func (ic *itemCost) increase(bq *du.BatchQuery, t CostTierTran, iTierKey int) (constants.ResultConstant, CostTierResult) {
+	unitCost := t.UnitCost
+	if ic.ValuationMeth == constants.ValuationMethStandard {
+		unitCost = ic.StdUnitCost
+	}
+
+	key := iTierKey
+	bq.Set(`INSERT INTO timCostTier (
+				CostTierKey, WhseKey, ItemKey, CostingDate, OrigQty,
+				PendQtyIncrease, PendQtyDecrease, QtyUsed, Status, UnitCost)
+			VALUES (?, ?, ?, ?, 0, ?, 0, 0, ?, ?);`,
+		key, t.WhseKey, t.ItemKey, t.CostingDate.Format("2006-01-02"), t.Qty, constants.InventoryStatusPending, unitCost)
+	bq.Set(`INSERT INTO timInvtTranCost (InvtTranKey, CostTierKey, DistQty) VALUES (?, ?, ?);`,
+		t.InvtTranKey, key, t.Qty)
+	if !bq.OK() {
+		return constants.ResultError, CostTierResult{}
+	}
+
+	// A pending tier cannot be used until it is posted
+	ic.Tiers = append(ic.Tiers, &costTier{CostTierKey: key, UnitCost: unitCost})
+
+	ext := t.Qty.Mul(unitCost).RoundAmt()
+	return constants.ResultSuccess, CostTierResult{
+		InvtTranKey:   t.InvtTranKey,
+		ValuationMeth: ic.ValuationMeth,
+		UnitCost:      unitCost,
+		ExtCost:       ext,
+		Dists:         []CostTierDist{{CostTierKey: key, DistQty: t.Qty, UnitCost: unitCost}},
+	}
+}
@@ -0,0 +1,306 @@
+package im
+
+import (
+	"gosqljobs/invtcommit/functions/constants"
+	"gosqljobs/invtcommit/functions/dec"
+	"gosqljobs/invtcommit/functions/sm"
+	"gosqljobs/invtcommit/functions/testdb"
+	"testing"
+	"time"
+
+	du "github.com/eaglebush/datautils"
+)
+
+// costFixture - the warehouse, item and active cost tiers of a costing test
+type costFixture struct {
+	WhseKey  int
+	ItemKey  int
+	TierKeys []int
+}
+
+// costFixtureDate - costing date of the first fixture tier.  The fixture tiers are the
+// latest tiers of the item so that they give its last unit cost.
+var costFixtureDate = time.Date(2999, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// newCostFixture - sets the valuation of the test item and replaces its open cost tiers
+// with active tiers of the quantities and unit costs, in costing date order
+func newCostFixture(
+	t *testing.T,
+	bq *du.BatchQuery,
+	iValuationMeth constants.ValuationMethConstant,
+	iStdUnitCost string,
+	iTiers ...[2]string) costFixture {
+
+	t.Helper()
+
+	f := costFixture{
+		WhseKey: testdb.Int(t, "INVTCOMMIT_TEST_WHSEKEY"),
+		ItemKey: testdb.Int(t, "INVTCOMMIT_TEST_ITEMKEY"),
+	}
+
+	bq.Set(`UPDATE timItem SET ValuationMeth=? WHERE ItemKey=?;`, iValuationMeth, f.ItemKey)
+	bq.Set(`UPDATE timInventory SET StdUnitCost=? WHERE WhseKey=? AND ItemKey=?;`, iStdUnitCost, f.WhseKey, f.ItemKey)
+	bq.Set(`UPDATE timCostTier SET Status=?
+			WHERE WhseKey=? AND ItemKey=? AND Status IN (?,?);`,
+		constants.InventoryStatusClosed, f.WhseKey, f.ItemKey, constants.InventoryStatusPending, constants.InventoryStatusActive)
+
+	for i, tier := range iTiers {
+		key := sm.GetNextSurrogateKey(bq, `timCostTier`)
+		bq.Set(`INSERT INTO timCostTier (
+					CostTierKey, WhseKey, ItemKey, CostingDate, OrigQty,
+					PendQtyIncrease, PendQtyDecrease, QtyUsed, Status, UnitCost)
+				VALUES (?, ?, ?, ?, ?, 0, 0, 0, ?, ?);`,
+			key, f.WhseKey, f.ItemKey, costFixtureDate.AddDate(0, 0, i).Format("2006-01-02"), tier[0],
+			constants.InventoryStatusActive, tier[1])
+		f.TierKeys = append(f.TierKeys, key)
+	}
+	if !bq.OK() {
+		t.Fatalf("Fixture not created: %s", bq.LastErrorText())
+	}
+
+	return f
+}
+
+// tran - a transaction of the fixture item with a new InvtTranKey
+func (f costFixture) tran(t *testing.T, bq *du.BatchQuery, iEffect constants.InventoryActionConstant, iQty, iUnitCost string) CostTierTran {
+	t.Helper()
+
+	return CostTierTran{
+		InvtTranKey: sm.GetNextSurrogateKey(bq, `timInvtTran`),
+		WhseKey:     f.WhseKey,
+		ItemKey:     f.ItemKey,
+		Effect:      iEffect,
+		Qty:         num(t, iQty),
+		UnitCost:    num(t, iUnitCost),
+		CostingDate: costFixtureDate.AddDate(1, 0, 0),
+	}
+}
+
+// num - parses a number of a test
+func num(t *testing.T, s string) dec.Decimal {
+	t.Helper()
+
+	d, err := dec.Parse(s)
+	if err != nil {
+		t.Fatalf("%q: %s", s, err)
+	}
+
+	return d
+}
+
+// pendQtyDecrease - returns the pending decrease of a cost tier
+func pendQtyDecrease(t *testing.T, bq *du.BatchQuery, iCostTierKey int) dec.Decimal {
+	t.Helper()
+
+	qr := bq.Get(`SELECT PendQtyDecrease FROM timCostTier WITH (NOLOCK) WHERE CostTierKey=?;`, iCostTierKey)
+	if !bq.OK() || !qr.HasData {
+		t.Fatalf("Cost tier %d not found: %s", iCostTierKey, bq.LastErrorText())
+	}
+
+	return col(t, qr.First(), "PendQtyDecrease")
+}
+
+// TestApplyCostTiersDecrease - a decrease uses the tiers and is costed by the valuation
+// method of the item
+func TestApplyCostTiersDecrease(t *testing.T) {
+	tests := []struct {
+		name     string
+		meth     constants.ValuationMethConstant
+		qty      string
+		extCost  string
+		tierDecr [2]string // PendQtyDecrease of the two tiers
+	}{
+		// Tiers: 5 @ 1.00, then 5 @ 2.00.  Standard unit cost 3.00.
+		{"FIFO", constants.ValuationMethFIFO, "7", "9.000", [2]string{"5", "2"}},
+		{"LIFO", constants.ValuationMethLIFO, "7", "12.000", [2]string{"2", "5"}},
+		{"Actual", constants.ValuationMethActual, "6", "7.000", [2]string{"5", "1"}},
+		{"Average", constants.ValuationMethAverage, "4", "6.000", [2]string{"4", "0"}},
+		{"Standard", constants.ValuationMethStandard, "4", "12.000", [2]string{"4", "0"}},
+	}
+
+	bq := testdb.Open(t)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testdb.Begin(t, bq)
+
+			f := newCostFixture(t, bq, tt.meth, "3.00", [2]string{"5", "1.00"}, [2]string{"5", "2.00"})
+			tran := f.tran(t, bq, constants.InventoryDecrease, tt.qty, "0")
+
+			res, rs := ApplyCostTiers(bq, []CostTierTran{tran}, false)
+			if res != constants.ResultSuccess {
+				t.Fatalf("ApplyCostTiers returned %d: %s", res, bq.LastErrorText())
+			}
+
+			if r := rs[0]; !r.ExtCost.Equal(num(t, tt.extCost)) || r.ValuationMeth != tt.meth {
+				t.Errorf("ExtCost %s with valuation %d, want %s with %d", r.ExtCost, r.ValuationMeth, tt.extCost, tt.meth)
+			}
+
+			var distQty dec.Decimal
+			for i, key := range f.TierKeys {
+				if q := pendQtyDecrease(t, bq, key); !q.Equal(num(t, tt.tierDecr[i])) {
+					t.Errorf("PendQtyDecrease of tier %d is %s, want %s", i+1, q, tt.tierDecr[i])
+				}
+			}
+			for _, d := range rs[0].Dists {
+				distQty = distQty.Add(d.DistQty)
+			}
+			if !distQty.Equal(num(t, tt.qty)) {
+				t.Errorf("Distributed %s, want %s", distQty, tt.qty)
+			}
+
+			if n := count(t, bq, `SELECT COUNT(*) FROM timInvtTranCost WITH (NOLOCK) WHERE InvtTranKey=?;`, tran.InvtTranKey); n != int64(len(rs[0].Dists)) {
+				t.Errorf("%d timInvtTranCost rows, want %d", n, len(rs[0].Dists))
+			}
+		})
+	}
+}
+
+// TestApplyCostTiersIncrease - an increase creates a pending tier, at the standard unit
+// cost for standard cost items
+func TestApplyCostTiersIncrease(t *testing.T) {
+	tests := []struct {
+		name     string
+		meth     constants.ValuationMethConstant
+		unitCost string
+	}{
+		{"FIFO", constants.ValuationMethFIFO, "4.50"},
+		{"Standard", constants.ValuationMethStandard, "3.00"},
+	}
+
+	bq := testdb.Open(t)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testdb.Begin(t, bq)
+
+			f := newCostFixture(t, bq, tt.meth, "3.00")
+			tran := f.tran(t, bq, constants.InventoryIncrease, "2", "4.50")
+
+			res, rs := ApplyCostTiers(bq, []CostTierTran{tran}, false)
+			if res != constants.ResultSuccess {
+				t.Fatalf("ApplyCostTiers returned %d: %s", res, bq.LastErrorText())
+			}
+
+			qr := bq.Get(`SELECT Status, UnitCost, PendQtyIncrease FROM timCostTier WITH (NOLOCK) WHERE CostTierKey=?;`, rs[0].Dists[0].CostTierKey)
+			if !qr.HasData {
+				t.Fatalf("Cost tier %d not created", rs[0].Dists[0].CostTierKey)
+			}
+			r := qr.First()
+			if constants.InventoryStatusConstant(r.ValueInt64("Status")) != constants.InventoryStatusPending {
+				t.Errorf("Status is %d, want pending", r.ValueInt64("Status"))
+			}
+			if c := col(t, r, "UnitCost"); !c.Equal(num(t, tt.unitCost)) {
+				t.Errorf("UnitCost is %s, want %s", c, tt.unitCost)
+			}
+			if q := col(t, r, "PendQtyIncrease"); !q.Equal(dec.FromInt(2)) {
+				t.Errorf("PendQtyIncrease is %s, want 2", q)
+			}
+		})
+	}
+}
+
+// TestApplyCostTiersNegative - the quantity missing from the tiers is taken from a new
+// pending negative tier at the last unit cost
+func TestApplyCostTiersNegative(t *testing.T) {
+	bq := testdb.Open(t)
+	testdb.Begin(t, bq)
+
+	f := newCostFixture(t, bq, constants.ValuationMethFIFO, "3.00", [2]string{"5", "1.00"}, [2]string{"5", "2.00"})
+	tran := f.tran(t, bq, constants.InventoryDecrease, "12", "0")
+
+	res, rs := ApplyCostTiers(bq, []CostTierTran{tran}, true)
+	if res != constants.ResultSuccess {
+		t.Fatalf("ApplyCostTiers returned %d: %s", res, bq.LastErrorText())
+	}
+
+	dists := rs[0].Dists
+	if len(dists) != 3 || !dists[2].Negative {
+		t.Fatalf("Got %d distributions, want 2 tiers and a negative tier", len(dists))
+	}
+	if !dists[2].DistQty.Equal(dec.FromInt(2)) || !dists[2].UnitCost.Equal(num(t, "2.00")) {
+		t.Errorf("Negative tier %s @ %s, want 2 @ 2.00", dists[2].DistQty, dists[2].UnitCost)
+	}
+	if !rs[0].ExtCost.Equal(num(t, "19.000")) {
+		t.Errorf("ExtCost is %s, want 19.000", rs[0].ExtCost)
+	}
+
+	qr := bq.Get(`SELECT Status, OrigQty, PendQtyDecrease FROM timCostTier WITH (NOLOCK) WHERE CostTierKey=?;`, dists[2].CostTierKey)
+	if !qr.HasData {
+		t.Fatalf("Negative tier %d not created", dists[2].CostTierKey)
+	}
+	if constants.InventoryStatusConstant(qr.First().ValueInt64("Status")) != constants.InventoryStatusPending ||
+		!col(t, qr.First(), "OrigQty").IsZero() || !col(t, qr.First(), "PendQtyDecrease").Equal(dec.FromInt(2)) {
+		t.Errorf("Negative tier is not pending with a pending decrease of 2")
+	}
+}
+
+// TestApplyCostTiersNotEnough - without negative inventory a decrease larger than the
+// tiers is refused
+func TestApplyCostTiersNotEnough(t *testing.T) {
+	bq := testdb.Open(t)
+	testdb.Begin(t, bq)
+
+	f := newCostFixture(t, bq, constants.ValuationMethFIFO, "3.00", [2]string{"5", "1.00"})
+	tran := f.tran(t, bq, constants.InventoryDecrease, "6", "0")
+
+	if res, _ := ApplyCostTiers(bq, []CostTierTran{tran}, false); res != constants.ResultFail {
+		t.Errorf("ApplyCostTiers returned %d, want %d", res, constants.ResultFail)
+	}
+}
+
+// TestApplyCostTiersUndo - APIUndoCostTiersVector gives back everything ApplyCostTiers wrote
+func TestApplyCostTiersUndo(t *testing.T) {
+	bq := testdb.Open(t)
+	testdb.Begin(t, bq)
+
+	f := newCostFixture(t, bq, constants.ValuationMethFIFO, "3.00", [2]string{"5", "1.00"}, [2]string{"5", "2.00"})
+	trans := []CostTierTran{
+		f.tran(t, bq, constants.InventoryDecrease, "12", "0"),
+		f.tran(t, bq, constants.InventoryIncrease, "4", "5.00"),
+	}
+
+	res, rs := ApplyCostTiers(bq, trans, true)
+	if res != constants.ResultSuccess {
+		t.Fatalf("ApplyCostTiers returned %d: %s", res, bq.LastErrorText())
+	}
+
+	bq.Set(`CREATE TABLE #timUndoCostTierTranWrk
+			(
+				invttrankey        INTEGER NOT NULL,
+				trnsfrorderlinekey INTEGER NULL,
+				eoi                SMALLINT NOT NULL
+			);`)
+	for _, tran := range trans {
+		bq.Set(`INSERT INTO #timUndoCostTierTranWrk (invttrankey, trnsfrorderlinekey, eoi) VALUES (?, NULL, ?);`,
+			tran.InvtTranKey, tran.Effect)
+	}
+	if !bq.OK() {
+		t.Fatalf("#timUndoCostTierTranWrk not filled: %s", bq.LastErrorText())
+	}
+
+	if res := APIUndoCostTiersVector(bq); res != constants.ResultSuccess {
+		t.Fatalf("APIUndoCostTiersVector returned %d: %s", res, bq.LastErrorText())
+	}
+
+	for i, key := range f.TierKeys {
+		if q := pendQtyDecrease(t, bq, key); !q.IsZero() {
+			t.Errorf("PendQtyDecrease of tier %d is %s after the undo, want 0", i+1, q)
+		}
+	}
+
+	// The negative tier and the tier of the increase are pending and left without quantity
+	for _, r := range rs {
+		for _, d := range r.Dists {
+			if d.Negative || r.InvtTranKey == trans[1].InvtTranKey {
+				if n := count(t, bq, `SELECT COUNT(*) FROM timCostTier WITH (NOLOCK) WHERE CostTierKey=?;`, d.CostTierKey); n != 0 {
+					t.Errorf("Pending tier %d not deleted", d.CostTierKey)
+				}
+			}
+		}
+
+		if n := count(t, bq, `SELECT COUNT(*) FROM timInvtTranCost WITH (NOLOCK) WHERE InvtTranKey=?;`, r.InvtTranKey); n != 0 {
+			t.Errorf("%d timInvtTranCost rows of transaction %d left", n, r.InvtTranKey)
+		}
+	}
+}
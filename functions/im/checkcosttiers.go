@@ -0,0 +1,319 @@
+package im
+
+import (
+	"gosqljobs/invtcommit/functions/constants"
+	"gosqljobs/invtcommit/functions/dec"
+
+	du "github.com/eaglebush/datautils"
+)
+
+// Kinds of cost tier findings
+const (
+	CostTierNegPendQty       = "NegPendQty"       // Negative PendQtyIncrease or PendQtyDecrease
+	CostTierPendQtyDiff      = "PendQtyDiff"      // Pending quantities do not agree with timInvtTranCost
+	CostTierOrphanPending    = "OrphanPending"    // Pending tier without quantity nor timInvtTranCost rows
+	CostTierOrphanTrnsfrCost = "OrphanTrnsfrCost" // timTrnsfrCost row of a deleted cost tier
+)
+
+// CostTierFinding - a cost tier that does not agree with the transactions that use it
+type CostTierFinding struct {
+	Kind               string      `json:"kind"`
+	WhseKey            int         `json:"whsekey"`
+	WhseID             string      `json:"whseid"`
+	ItemKey            int         `json:"itemkey"`
+	ItemID             string      `json:"itemid"`
+	CostTierKey        int         `json:"costtierkey"`
+	TrnsfrOrderLineKey int         `json:"trnsfrorderlinekey,omitempty"`
+	PendQtyIncrease    dec.Decimal `json:"pendqtyincrease"`
+	PendQtyDecrease    dec.Decimal `json:"pendqtydecrease"`
+	ExpQtyIncrease     dec.Decimal `json:"expqtyincrease"`
+	ExpQtyDecrease     dec.Decimal `json:"expqtydecrease"`
+	Explanation        string      `json:"explanation"`
+}
+
+// CheckCostTiers - Checks the pending quantities of the cost tiers against the transactions that use them
+// ---------------------------------------------------------------------
+// The expected pending quantities of a cost tier are the distributed quantities
+// (timInvtTranCost) of the pending and the committed but unposted transactions,
+// loaded by loadPendQtyTrans.
+//
+// With iRepair, the findings are repaired by repairCostTiers: the pending
+// quantities are set to the expected quantities, the transfer costs of deleted
+// cost tiers are removed and the pending cost tiers left without quantity and
+// transactions are removed, following the rules of APIUndoCostTiersVector.  The
+// cost tiers found are locked first and the expected quantities are computed
+// again inside the transaction, so that a commit that ran after the check is not
+// overwritten.  Everything is done in a single transaction.  ReconcilePendQty is
+// built on this check and repair.
+//
+// Input Parameters:
+//    @_iCompanyId      Company ID
+//    @_iWhseKey        Optional. Key of the warehouse (0 for all)
+//    @_iItemKey        Optional. Key of the item (0 for all)
+//    @_iRepair         Correct the cost tiers
+// Output Parameters:
+//    oRetVal      ReturnValue:
+// 	  0 - Did not make it through the procedure
+// 	  1 - No findings (or repaired)
+// 	  2 - Findings not repaired
+//    oFindings    Findings in WhseID, ItemID, CostTierKey order
+// ---------------------------------------------------------------------
+func CheckCostTiers(
+	bq *du.BatchQuery,
+	iCompanyID string,
+	iWhseKey int,
+	iItemKey int,
+	iRepair bool) (Result constants.ResultConstant, Findings []CostTierFinding) {
+
+	bq.ScopeName("CheckCostTiers")
+
+	bq.Set(`IF OBJECT_ID('tempdb..#timCostTierChk') IS NOT NULL
+				TRUNCATE TABLE #timCostTierChk
+			ELSE
+			BEGIN
+				CREATE TABLE #timCostTierChk
+				(
+					whsekey         INTEGER NOT NULL,
+					itemkey         INTEGER NOT NULL,
+					costingdate     DATETIME NOT NULL,
+					costtierkey     INTEGER NOT NULL,
+					status          SMALLINT NOT NULL,
+					hasdist         SMALLINT NOT NULL DEFAULT 0,
+					pendqtyincrease DECIMAL(16, 8) NOT NULL,
+					pendqtydecrease DECIMAL(16, 8) NOT NULL,
+					expqtyincrease  DECIMAL(16, 8) NOT NULL DEFAULT 0,
+					expqtydecrease  DECIMAL(16, 8) NOT NULL DEFAULT 0,
+					empty           SMALLINT NOT NULL DEFAULT 0,
+					finding         SMALLINT NOT NULL DEFAULT 0
+				)
+
+				CREATE CLUSTERED INDEX #timCostTierChk_cls
+					ON #timCostTierChk (whsekey, itemkey, costingdate, costtierkey)
+			END;`)
+	if !bq.OK() {
+		return constants.ResultError, nil
+	}
+
+	// Distributed quantities of the pending and the committed but unposted transactions
+	if loadPendQtyTrans(bq, iCompanyID, false) != constants.ResultSuccess {
+		return constants.ResultError, nil
+	}
+
+	// Cost tiers of the company that are open, have pending quantities or are used by
+	// the pending transactions
+	bq.Set(`INSERT INTO #timCostTierChk (whsekey, itemkey, costingdate, costtierkey, status, pendqtyincrease, pendqtydecrease, empty)
+			SELECT ct.WhseKey, ct.ItemKey, ct.CostingDate, ct.CostTierKey, ct.Status, ct.PendQtyIncrease, ct.PendQtyDecrease,
+				CASE WHEN ct.OrigQty=0 AND ct.QtyUsed=0 THEN 1 ELSE 0 END
+			FROM timCostTier ct WITH (NOLOCK)
+				JOIN timWarehouse w WITH (NOLOCK) ON ct.WhseKey = w.WhseKey
+			WHERE w.CompanyID=?
+				AND (ct.WhseKey=? OR ?=0)
+				AND (ct.ItemKey=? OR ?=0)
+				AND (ct.Status IN (?,?) OR ct.PendQtyIncrease<>0 OR ct.PendQtyDecrease<>0
+					OR ct.CostTierKey IN (SELECT costtierkey FROM #timPendQtyExp));`,
+		iCompanyID, iWhseKey, iWhseKey, iItemKey, iItemKey,
+		constants.InventoryStatusPending, constants.InventoryStatusActive)
+	if !bq.OK() {
+		return constants.ResultError, nil
+	}
+
+	bq.Set(`UPDATE c SET c.hasdist=1
+			FROM #timCostTierChk c
+			WHERE EXISTS (SELECT 1 FROM timInvtTranCost itc WITH (NOLOCK) WHERE itc.CostTierKey = c.costtierkey);`)
+
+	bq.Set(`UPDATE c
+			SET c.expqtyincrease = e.pendqtyincrease,
+				c.expqtydecrease = e.pendqtydecrease
+			FROM #timCostTierChk c
+				JOIN #timPendQtyExp e ON c.costtierkey = e.costtierkey;`)
+
+	bq.Set(`UPDATE #timCostTierChk
+			SET finding=1
+			WHERE pendqtyincrease < 0 OR pendqtydecrease < 0
+				OR pendqtyincrease <> expqtyincrease OR pendqtydecrease <> expqtydecrease
+				OR (status=? AND hasdist=0 AND empty=1);`, constants.InventoryStatusPending)
+	if !bq.OK() {
+		return constants.ResultError, nil
+	}
+
+	qr := bq.Get(`SELECT c.costtierkey, c.whsekey, w.WhseID, c.itemkey, i.ItemID,
+					c.pendqtyincrease, c.pendqtydecrease, c.expqtyincrease, c.expqtydecrease,
+					c.status, c.hasdist, c.empty
+				  FROM #timCostTierChk c
+					JOIN timWarehouse w WITH (NOLOCK) ON c.whsekey = w.WhseKey
+					JOIN timItem i WITH (NOLOCK) ON c.itemkey = i.ItemKey
+				  WHERE c.finding=1
+				  ORDER BY w.WhseID, i.ItemID, c.costtierkey;`)
+	if !bq.OK() {
+		return constants.ResultError, nil
+	}
+
+	Findings = make([]CostTierFinding, 0)
+	for _, v := range qr.Data {
+		qty, err := dec.Cols(v, "pendqtyincrease", "pendqtydecrease", "expqtyincrease", "expqtydecrease")
+		if err != nil {
+			return constants.ResultError, nil
+		}
+
+		f := CostTierFinding{
+			WhseKey:         int(v.ValueInt64("whsekey")),
+			WhseID:          v.ValueString("WhseID"),
+			ItemKey:         int(v.ValueInt64("itemkey")),
+			ItemID:          v.ValueString("ItemID"),
+			CostTierKey:     int(v.ValueInt64("costtierkey")),
+			PendQtyIncrease: qty[0],
+			PendQtyDecrease: qty[1],
+			ExpQtyIncrease:  qty[2],
+			ExpQtyDecrease:  qty[3],
+		}
+
+		switch {
+		case f.PendQtyIncrease.Sign() < 0 || f.PendQtyDecrease.Sign() < 0:
+			f.Kind = CostTierNegPendQty
+			f.Explanation = "Pending quantity is negative. A failed commit undid more than it applied."
+		case v.ValueInt64("status") == int64(constants.InventoryStatusPending) && v.ValueInt64("hasdist") == 0 && v.ValueInt64("empty") == 1:
+			f.Kind = CostTierOrphanPending
+			f.Explanation = "Pending cost tier is not used by any transaction. It was left by a failed commit."
+		default:
+			f.Kind = CostTierPendQtyDiff
+			f.Explanation = "Pending quantities do not agree with the pending and the unposted transactions."
+		}
+
+		Findings = append(Findings, f)
+	}
+
+	// Transfer costs of cost tiers that no longer exist
+	qr = bq.Get(`SELECT tc.CostTierKey, tc.TrnsfrOrderLineKey
+				 FROM timTrnsfrCost tc WITH (NOLOCK)
+				 WHERE NOT EXISTS (SELECT 1 FROM timCostTier ct WITH (NOLOCK) WHERE ct.CostTierKey = tc.CostTierKey)
+					AND tc.TrnsfrOrderLineKey IN (SELECT tol.TrnsfrOrderLineKey
+												  FROM timTrnsfrOrderLine tol WITH (NOLOCK)
+													JOIN timTrnsfrOrder t WITH (NOLOCK) ON tol.TrnsfrOrderKey = t.TrnsfrOrderKey
+												  WHERE t.CompanyID=?)
+				 ORDER BY tc.TrnsfrOrderLineKey, tc.CostTierKey;`, iCompanyID)
+	if !bq.OK() {
+		return constants.ResultError, nil
+	}
+	for _, v := range qr.Data {
+		Findings = append(Findings, CostTierFinding{
+			Kind:               CostTierOrphanTrnsfrCost,
+			CostTierKey:        int(v.ValueInt64("CostTierKey")),
+			TrnsfrOrderLineKey: int(v.ValueInt64("TrnsfrOrderLineKey")),
+			Explanation:        "Transfer cost points to a deleted cost tier. The transfer order line cannot be reconciled.",
+		})
+	}
+
+	if len(Findings) == 0 {
+		return constants.ResultSuccess, Findings
+	}
+
+	if !iRepair {
+		return constants.ResultFail, Findings
+	}
+
+	if repairCostTiers(bq, iCompanyID, Findings) != constants.ResultSuccess {
+		return constants.ResultError, Findings
+	}
+
+	return constants.ResultSuccess, Findings
+}
+
+// repairCostTiers - Repairs the cost tiers marked in #timCostTierChk (finding=1) in one transaction
+// ---------------------------------------------------------------------
+// The cost tiers are locked in WhseKey, ItemKey, CostingDate, CostTierKey order
+// and their expected quantities are computed again.  Their pending quantities are
+// set to the expected quantities, the transfer costs of the OrphanTrnsfrCost
+// findings are removed and the pending cost tiers left without quantity and
+// transactions are removed.  It is the repair of CheckCostTiers and of
+// ReconcilePendQty.
+//
+// Input Parameters:
+//    @_iCompanyId      Company ID
+//    @_iFindings       Findings of CheckCostTiers
+// Output Parameters:
+//    oRetVal      ReturnValue:
+// 	  0 - Did not make it through the procedure
+// 	  1 - Successful
+// ---------------------------------------------------------------------
+func repairCostTiers(bq *du.BatchQuery, iCompanyID string, iFindings []CostTierFinding) constants.ResultConstant {
+	bq.Set(`BEGIN TRAN;`)
+
+	// rollback - undoes everything done since BEGIN TRAN
+	rollback := func() constants.ResultConstant {
+		bq.Waive()
+		bq.Set(`IF @@TRANCOUNT > 0 ROLLBACK;`)
+		return constants.ResultError
+	}
+
+	bq.Get(`SELECT ct.CostTierKey
+			FROM #timCostTierChk c
+				INNER LOOP JOIN timCostTier ct WITH (UPDLOCK, ROWLOCK) ON ct.CostTierKey=c.costtierkey
+			WHERE c.finding=1
+			ORDER BY c.whsekey, c.itemkey, c.costingdate, c.costtierkey
+			OPTION (FORCE ORDER);`)
+	if !bq.OK() {
+		return rollback()
+	}
+
+	// The expected quantities are computed again now that the cost tiers are locked
+	if loadPendQtyTrans(bq, iCompanyID, true) != constants.ResultSuccess {
+		return rollback()
+	}
+
+	bq.Set(`UPDATE c
+			SET c.expqtyincrease = COALESCE(e.pendqtyincrease, 0),
+				c.expqtydecrease = COALESCE(e.pendqtydecrease, 0)
+			FROM #timCostTierChk c
+				LEFT JOIN #timPendQtyExp e ON c.costtierkey = e.costtierkey
+			WHERE c.finding=1;`)
+	if !bq.OK() {
+		return rollback()
+	}
+
+	bq.Set(`UPDATE ct
+			SET ct.PendQtyIncrease = c.expqtyincrease,
+				ct.PendQtyDecrease = c.expqtydecrease
+			FROM timCostTier ct
+				INNER JOIN #timCostTierChk c ON ct.WhseKey=c.whsekey
+					AND ct.ItemKey=c.itemkey
+					AND ct.CostingDate=c.costingdate
+					AND ct.CostTierKey=c.costtierkey
+			WHERE c.finding=1
+				AND (ct.PendQtyIncrease <> c.expqtyincrease OR ct.PendQtyDecrease <> c.expqtydecrease);`)
+	if !bq.OK() {
+		return rollback()
+	}
+
+	for _, f := range iFindings {
+		if f.Kind != CostTierOrphanTrnsfrCost {
+			continue
+		}
+		bq.Set(`DELETE timTrnsfrCost WHERE CostTierKey=? AND TrnsfrOrderLineKey=?;`, f.CostTierKey, f.TrnsfrOrderLineKey)
+	}
+	if !bq.OK() {
+		return rollback()
+	}
+
+	// Pending cost tiers left without any quantity are no longer needed
+	bq.Set(`DELETE ct
+			FROM timCostTier ct
+				INNER JOIN #timCostTierChk c ON ct.CostTierKey=c.costtierkey
+			WHERE c.finding=1
+				AND ct.OrigQty=0
+				AND ct.PendQtyDecrease=0
+				AND ct.PendQtyIncrease=0
+				AND ct.QtyUsed=0
+				AND ct.Status=?
+				AND NOT EXISTS (SELECT 1 FROM timInvtTranCost itc WHERE itc.CostTierKey = ct.CostTierKey);`,
+		constants.InventoryStatusPending)
+	if !bq.OK() {
+		return rollback()
+	}
+
+	bq.Set(`COMMIT;`)
+	if !bq.OK() {
+		return constants.ResultError
+	}
+
+	return constants.ResultSuccess
+}
@@ -0,0 +1,264 @@
+package im
+
+import (
+	"gosqljobs/invtcommit/functions/constants"
+	"gosqljobs/invtcommit/functions/dec"
+	"gosqljobs/invtcommit/functions/sm"
+
+	du "github.com/eaglebush/datautils"
+)
+
+// NegInvtFinding - a transaction line that takes a bin or lot below zero
+type NegInvtFinding struct {
+	TranType  int                             `json:"trantype"` // SO, IM or PO tran type
+	TranKey   int                             `json:"trankey"`
+	TranID    string                          `json:"tranid"`
+	WhseID    string                          `json:"whseid"`
+	ItemID    string                          `json:"itemid"`
+	WhseBinID string                          `json:"whsebinid"`
+	LotNo     string                          `json:"lotno"`
+	CommitQty dec.Decimal                     `json:"commitqty"` // Quantity of all the transactions committed from the bin and lot
+	AvailQty  dec.Decimal                     `json:"availqty"`
+	Policy    constants.NegInvtPolicyConstant `json:"policy"`
+}
+
+// CheckNegativeInvt - Checks the quantity on hand of the transactions in #tciTransToCommit before they are committed
+// ---------------------------------------------------------------------
+// The distributions (timInvtTranDist) of the lines that decrease the quantity on
+// hand are added up per warehouse, item, bin and lot and compared with the
+// quantity on hand of the bin and lot (timWhseBinInvt).  These are:
+//    - the lines of the customer shipments and transfer shipments (tsoShipLine)
+//    - the IM transactions whose tran type decreases the quantity on hand
+//      (timTranType.QtyOnHandEffect)
+//    - the lines of the PO returns (tpoRcvrLine)
+// Only the transactions that have not been processed yet are checked.
+//
+// When the quantity committed is more than the quantity on hand, the negative
+// inventory policy is applied:
+//    0 Allow    the transactions are committed
+//    1 Warn     the transactions are committed and a warning is logged
+//    2 Block    the CommitStatus of the transactions is set to CommitStatusNegInventory
+//               and an error is logged
+//
+// Policies are read from the optional table timNegInvtPolicy_HAI:
+//
+//    CREATE TABLE timNegInvtPolicy_HAI (
+//       WhseKey        INTEGER NOT NULL,      -- 0 = all the warehouses
+//       ItemKey        INTEGER NOT NULL,      -- 0 = all the items
+//       NegInvtPolicy  SMALLINT NOT NULL)     -- NegInvtPolicyConstant
+//
+// The row of the item overrides the row of the warehouse, and a row of the
+// warehouse and item overrides both.  Without any row the transactions are
+// committed (Allow).  The message strings are added by sql/imNegInvtPolicy.sql.
+//
+// The messages are logged with LogError to the pre-commit batch of each transaction.
+// CommitInvtTrans runs this check before the disposable batches are created.
+//
+// Input Parameters:
+//    @_iSessionID      Session ID of the error log
+// Output Parameters:
+//    oRetVal      ReturnValue:
+// 	  0 - Did not make it through the procedure
+// 	  1 - No transaction was blocked
+// 	  2 - Some transactions were blocked
+//    oFindings    Transaction lines that take a bin or lot below zero (warned or blocked)
+// ---------------------------------------------------------------------
+func CheckNegativeInvt(
+	bq *du.BatchQuery,
+	iSessionID int) (Result constants.ResultConstant, Findings []NegInvtFinding) {
+
+	const lNegInvtWarning int = 990100 // sql/imNegInvtPolicy.sql
+	const lNegInvtBlocked int = 990101
+
+	bq.ScopeName("CheckNegativeInvt")
+
+	bq.Set(`IF OBJECT_ID('tempdb..#tciNegInvtDist') IS NOT NULL
+				TRUNCATE TABLE #tciNegInvtDist
+			ELSE
+				CREATE TABLE #tciNegInvtDist
+				(
+					trantype          INTEGER NOT NULL,
+					trankey           INTEGER NOT NULL,
+					tranid            VARCHAR(13) NOT NULL,
+					precommitbatchkey INTEGER NOT NULL,
+					linekey           INTEGER NOT NULL,
+					invttrankey       INTEGER NOT NULL,
+					whsekey           INTEGER NOT NULL,
+					itemkey           INTEGER NOT NULL,
+					whsebinkey        INTEGER NOT NULL,
+					invtlotkey        INTEGER NULL,
+					distqty           DECIMAL(16, 8) NOT NULL
+				);`)
+
+	bq.Set(`IF OBJECT_ID('tempdb..#tciNegInvt') IS NOT NULL
+				TRUNCATE TABLE #tciNegInvt
+			ELSE
+				CREATE TABLE #tciNegInvt
+				(
+					whsekey    INTEGER NOT NULL,
+					itemkey    INTEGER NOT NULL,
+					whsebinkey INTEGER NOT NULL,
+					invtlotkey INTEGER NULL,
+					commitqty  DECIMAL(16, 8) NOT NULL,
+					availqty   DECIMAL(16, 8) NOT NULL,
+					policy     SMALLINT NOT NULL
+				);`)
+	if !bq.OK() {
+		return constants.ResultError, nil
+	}
+
+	// Drop shipments and customer returns do not take anything from the warehouse
+	bq.Set(`INSERT INTO #tciNegInvtDist (trantype, trankey, tranid, precommitbatchkey, linekey, invttrankey,
+				whsekey, itemkey, whsebinkey, invtlotkey, distqty)
+			SELECT t.TranType, t.TranKey, ps.TranID, t.PreCommitBatchKey, sl.ShipLineKey, sl.InvtTranKey,
+				wb.WhseKey, sl.ItemKey, d.WhseBinKey, d.InvtLotKey, ABS(d.DistQty)
+			FROM #tciTransToCommit t
+				JOIN tsoPendShipment ps WITH (NOLOCK) ON t.TranKey = ps.ShipKey
+				JOIN tsoShipLine sl WITH (NOLOCK) ON ps.ShipKey = sl.ShipKey
+				JOIN timInvtTranDist d WITH (NOLOCK) ON sl.InvtTranKey = d.InvtTranKey
+				JOIN timWhseBin wb WITH (NOLOCK) ON d.WhseBinKey = wb.WhseBinKey
+			WHERE COALESCE(t.CommitStatus, 0)=? AND COALESCE(t.DispoBatchKey, 0)=0
+				AND t.TranType IN (?,?)
+				AND d.DistQty <> 0;`,
+		constants.CommitStatusDefault, constants.SOTranTypeCustShip, constants.SOTranTypeTransShip)
+
+	// IM transactions that decrease the quantity on hand
+	bq.Set(`INSERT INTO #tciNegInvtDist (trantype, trankey, tranid, precommitbatchkey, linekey, invttrankey,
+				whsekey, itemkey, whsebinkey, invtlotkey, distqty)
+			SELECT t.TranType, t.TranKey, pit.TranID, t.PreCommitBatchKey, 0, pit.InvtTranKey,
+				wb.WhseKey, pit.ItemKey, d.WhseBinKey, d.InvtLotKey, ABS(d.DistQty)
+			FROM #tciTransToCommit t
+				JOIN timPendInvtTran pit WITH (NOLOCK) ON t.TranKey = pit.InvtTranKey AND t.TranType = pit.TranType
+				JOIN timTranType tt WITH (NOLOCK) ON pit.TranType = tt.TranType
+				JOIN timInvtTranDist d WITH (NOLOCK) ON pit.InvtTranKey = d.InvtTranKey
+				JOIN timWhseBin wb WITH (NOLOCK) ON d.WhseBinKey = wb.WhseBinKey
+			WHERE COALESCE(t.CommitStatus, 0)=? AND COALESCE(t.DispoBatchKey, 0)=0
+				AND tt.QtyOnHandEffect=?
+				AND d.DistQty <> 0;`,
+		constants.CommitStatusDefault, constants.InventoryDecrease)
+
+	// PO returns
+	bq.Set(`INSERT INTO #tciNegInvtDist (trantype, trankey, tranid, precommitbatchkey, linekey, invttrankey,
+				whsekey, itemkey, whsebinkey, invtlotkey, distqty)
+			SELECT t.TranType, t.TranKey, r.TranID, t.PreCommitBatchKey, rl.RcvrLineKey, rl.InvtTranKey,
+				wb.WhseKey, pl.ItemKey, d.WhseBinKey, d.InvtLotKey, ABS(d.DistQty)
+			FROM #tciTransToCommit t
+				JOIN tpoPendReceiver r WITH (NOLOCK) ON t.TranKey = r.RcvrKey AND t.TranType = r.TranType
+				JOIN tpoRcvrLine rl WITH (NOLOCK) ON r.RcvrKey = rl.RcvrKey
+				JOIN tpoPOLine pl WITH (NOLOCK) ON rl.POLineKey = pl.POLineKey
+				JOIN timInvtTranDist d WITH (NOLOCK) ON rl.InvtTranKey = d.InvtTranKey
+				JOIN timWhseBin wb WITH (NOLOCK) ON d.WhseBinKey = wb.WhseBinKey
+			WHERE COALESCE(t.CommitStatus, 0)=? AND COALESCE(t.DispoBatchKey, 0)=0
+				AND t.TranType=?
+				AND d.DistQty <> 0;`,
+		constants.CommitStatusDefault, constants.POTranTypeReturn)
+	if !bq.OK() {
+		return constants.ResultError, nil
+	}
+
+	bq.Set(`INSERT INTO #tciNegInvt (whsekey, itemkey, whsebinkey, invtlotkey, commitqty, availqty, policy)
+			SELECT c.whsekey, c.itemkey, c.whsebinkey, c.invtlotkey, c.commitqty, COALESCE(bi.QtyOnHand, 0), ?
+			FROM (SELECT whsekey, itemkey, whsebinkey, invtlotkey, SUM(distqty) AS commitqty
+				  FROM #tciNegInvtDist
+				  GROUP BY whsekey, itemkey, whsebinkey, invtlotkey) c
+				LEFT JOIN timWhseBinInvt bi WITH (NOLOCK) ON c.whsebinkey = bi.WhseBinKey
+					AND c.itemkey = bi.ItemKey
+					AND COALESCE(c.invtlotkey, 0) = COALESCE(bi.InvtLotKey, 0)
+			WHERE c.commitqty > COALESCE(bi.QtyOnHand, 0);`,
+		constants.NegInvtPolicyAllow)
+
+	// The most specific policy: warehouse and item, item, warehouse, then all
+	bq.Set(`IF OBJECT_ID('timNegInvtPolicy_HAI') IS NOT NULL
+				UPDATE n
+				SET n.policy = p.NegInvtPolicy
+				FROM #tciNegInvt n
+					CROSS APPLY (SELECT TOP 1 np.NegInvtPolicy
+								 FROM timNegInvtPolicy_HAI np WITH (NOLOCK)
+								 WHERE np.WhseKey IN (0, n.whsekey) AND np.ItemKey IN (0, n.itemkey)
+								 ORDER BY np.ItemKey DESC, np.WhseKey DESC) p;`)
+
+	bq.Set(`DELETE #tciNegInvt WHERE policy=?;`, constants.NegInvtPolicyAllow)
+	if !bq.OK() {
+		return constants.ResultError, nil
+	}
+
+	qr := bq.Get(`SELECT d.trantype, d.trankey, d.precommitbatchkey, d.linekey, d.invttrankey,
+					d.tranid AS TranID, w.WhseID, i.ItemID, wb.WhseBinID, COALESCE(l.LotNo, '') AS LotNo,
+					n.commitqty, n.availqty, n.policy
+				  FROM #tciNegInvtDist d
+					JOIN #tciNegInvt n ON d.whsekey = n.whsekey
+						AND d.itemkey = n.itemkey
+						AND d.whsebinkey = n.whsebinkey
+						AND COALESCE(d.invtlotkey, 0) = COALESCE(n.invtlotkey, 0)
+					JOIN timWarehouse w WITH (NOLOCK) ON d.whsekey = w.WhseKey
+					JOIN timItem i WITH (NOLOCK) ON d.itemkey = i.ItemKey
+					JOIN timWhseBin wb WITH (NOLOCK) ON d.whsebinkey = wb.WhseBinKey
+					LEFT JOIN timInvtLot l WITH (NOLOCK) ON d.invtlotkey = l.InvtLotKey
+				  ORDER BY d.tranid, w.WhseID, i.ItemID, wb.WhseBinID;`)
+	if !bq.OK() {
+		return constants.ResultError, nil
+	}
+
+	Findings = make([]NegInvtFinding, 0, len(qr.Data))
+	if !qr.HasData {
+		return constants.ResultSuccess, Findings
+	}
+
+	blocked := false
+	for _, v := range qr.Data {
+		qty, err := dec.Cols(v, "commitqty", "availqty")
+		if err != nil {
+			return constants.ResultError, nil
+		}
+
+		f := NegInvtFinding{
+			TranType:  int(v.ValueInt64("trantype")),
+			TranKey:   int(v.ValueInt64("trankey")),
+			TranID:    v.ValueString("TranID"),
+			WhseID:    v.ValueString("WhseID"),
+			ItemID:    v.ValueString("ItemID"),
+			WhseBinID: v.ValueString("WhseBinID"),
+			LotNo:     v.ValueString("LotNo"),
+			CommitQty: qty[0],
+			AvailQty:  qty[1],
+			Policy:    constants.NegInvtPolicyConstant(v.ValueInt64("policy")),
+		}
+
+		strNo, severity := lNegInvtWarning, constants.Warning
+		if f.Policy == constants.NegInvtPolicyBlock {
+			strNo, severity = lNegInvtBlocked, constants.FatalError
+			blocked = true
+		}
+
+		sm.LogError(bq, int(v.ValueInt64("precommitbatchkey")), 0, strNo,
+			f.TranID, f.WhseID, f.ItemID, f.WhseBinID, f.LotNo,
+			constants.InterfaceError, severity, iSessionID,
+			f.TranType, f.TranKey, int(v.ValueInt64("invttrankey")), int(v.ValueInt64("linekey")))
+
+		Findings = append(Findings, f)
+	}
+
+	bq.ScopeName("CheckNegativeInvt")
+
+	if !blocked {
+		return constants.ResultSuccess, Findings
+	}
+
+	bq.Set(`UPDATE t
+			SET t.CommitStatus=?
+			FROM #tciTransToCommit t
+			WHERE EXISTS (SELECT 1
+						  FROM #tciNegInvtDist d
+							JOIN #tciNegInvt n ON d.whsekey = n.whsekey
+								AND d.itemkey = n.itemkey
+								AND d.whsebinkey = n.whsebinkey
+								AND COALESCE(d.invtlotkey, 0) = COALESCE(n.invtlotkey, 0)
+						  WHERE d.trantype=t.TranType AND d.trankey=t.TranKey
+							AND n.policy=?);`,
+		constants.CommitStatusNegInventory, constants.NegInvtPolicyBlock)
+	if !bq.OK() {
+		return constants.ResultError, Findings
+	}
+
+	return constants.ResultFail, Findings
+}
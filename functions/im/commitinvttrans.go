@@ -0,0 +1,128 @@
+package im
+
+import (
+	"gosqljobs/invtcommit/functions/bat"
+	"gosqljobs/invtcommit/functions/constants"
+	"time"
+
+	du "github.com/eaglebush/datautils"
+)
+
+// RegisterFunc - creates the pending posting rows (timPosting and tglPosting) of the
+// transactions of a disposable batch.  The module registers are passed in so that
+// im does not depend on the modules.
+type RegisterFunc func(
+	bq *du.BatchQuery,
+	iBatchKey int,
+	iCompanyID string,
+	iModuleNo constants.ModuleConstant) constants.ResultConstant
+
+// CommitInvtTrans - Commits the transactions in #tciTransToCommit through disposable batches
+// ---------------------------------------------------------------------
+// The steps of the commit:
+//    1. LockCommitItemWhse                 warehouse and item locks (deferred rows are skipped)
+//    2. CheckNegativeInvt                  quantity on hand of the decreases (blocked rows are skipped)
+//    3. CreateInvtCommitDisposableBatch    disposable batches of the transactions
+//    4. iRegister                          posting rows of each disposable batch
+//       UpdateBatchTotals                  totals of each disposable batch from its posting rows
+//    5. ApplyBatchCostTiers                cost tiers of the transactions of each disposable batch
+//    6. UnlockCommitItemWhse               always, also when a step fails
+//
+// Transactions that already have a disposable batch from an earlier run are
+// registered and costed again; both steps skip what is already done.  When a
+// batch cannot be costed, its pending cost tiers are undone with
+// PostAPIUndoCostTiersUpdate and the commit stops.
+//
+// Input Parameters:
+//    @_iUserID         User creating the batches and owning the locks
+//    @_iSessionID      Session ID of the error log
+//    @_iRegister       Module register of the disposable batches
+//    @_iAllowNegative  Allow the quantity on hand to go below zero
+//    @_iLockRetries    Number of times the locks held by others are requested again
+//    @_iLockWait       Time between the lock requests
+// Output Parameters:
+//    oRetVal      ReturnValue:
+// 	  0 - Did not make it through the procedure
+// 	  1 - Successful
+// 	  2 - Not enough quantity in the cost tiers (see ApplyBatchCostTiers)
+// 	  3 - Item not found in the warehouse (timInventory)
+// 	  4 - Invalid quantity or inventory effect
+//    oResults     Cost of each transaction
+// ---------------------------------------------------------------------
+func CommitInvtTrans(
+	bq *du.BatchQuery,
+	iUserID string,
+	iSessionID int,
+	iRegister RegisterFunc,
+	iAllowNegative bool,
+	iLockRetries int,
+	iLockWait time.Duration) (Result constants.ResultConstant, Results []CostTierResult) {
+
+	bq.ScopeName("CommitInvtTrans")
+
+	// The cost tiers of a warehouse and item are only updated by the commit holding its lock
+	if res, _ := LockCommitItemWhse(bq, iUserID, iLockRetries, iLockWait); res == constants.ResultError {
+		UnlockCommitItemWhse(bq)
+		return constants.ResultError, nil
+	}
+	defer UnlockCommitItemWhse(bq)
+
+	// Transactions that take a bin or lot below zero against the policy get CommitStatusNegInventory
+	if res, _ := CheckNegativeInvt(bq, iSessionID); res == constants.ResultError {
+		return constants.ResultError, nil
+	}
+
+	bq.ScopeName("CommitInvtTrans")
+
+	qr := bq.Get(`SELECT COUNT(*) FROM #tciTransToCommit WHERE COALESCE(DispoBatchKey, 0)=0 AND COALESCE(CommitStatus, 0)>=0;`)
+	if !bq.OK() {
+		return constants.ResultError, nil
+	}
+
+	if qr.First().ValueInt64Ord(0) > 0 {
+		if CreateInvtCommitDisposableBatch(bq, iUserID) != constants.BatchReturnValid {
+			return constants.ResultError, nil
+		}
+
+		bq.ScopeName("CommitInvtTrans")
+	}
+
+	qr = bq.Get(`SELECT DISTINCT t.DispoBatchKey, t.CompanyID, bt.ModuleNo
+				 FROM #tciTransToCommit t
+					JOIN tciBatchLog bl WITH (NOLOCK) ON t.DispoBatchKey = bl.BatchKey
+					JOIN tciBatchType bt WITH (NOLOCK) ON bl.BatchType = bt.BatchType
+				 WHERE COALESCE(t.DispoBatchKey, 0)<>0 AND COALESCE(t.CommitStatus, 0)>=0
+				 ORDER BY t.DispoBatchKey;`)
+	if !bq.OK() {
+		return constants.ResultError, nil
+	}
+
+	Results = make([]CostTierResult, 0)
+	for _, v := range qr.Data {
+		batchKey := int(v.ValueInt64("DispoBatchKey"))
+		cid := v.ValueString("CompanyID")
+		mod := constants.ModuleConstant(v.ValueInt64("ModuleNo"))
+
+		if res := iRegister(bq, batchKey, cid, mod); res != constants.ResultSuccess {
+			return constants.ResultError, Results
+		}
+
+		// The totals are computed from the posting rows written by the register
+		if bat.UpdateBatchTotals(bq, batchKey) != constants.ResultSuccess {
+			return constants.ResultError, Results
+		}
+
+		bq.ScopeName("CommitInvtTrans")
+
+		res, rs := ApplyBatchCostTiers(bq, batchKey, iAllowNegative)
+		if res != constants.ResultSuccess {
+			PostAPIUndoCostTiersUpdate(bq, batchKey, cid, mod)
+			return res, Results
+		}
+		Results = append(Results, rs...)
+
+		bq.ScopeName("CommitInvtTrans")
+	}
+
+	return constants.ResultSuccess, Results
+}
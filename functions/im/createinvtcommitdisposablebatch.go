@@ -3,6 +3,7 @@ package im
 import (
 	"gosqljobs/invtcommit/functions/bat"
 	"gosqljobs/invtcommit/functions/constants"
+	"strings"
 
 	du "github.com/eaglebush/datautils"
 )
@@ -12,16 +13,25 @@ import (
 //                     key value that represents the disposable batch for the transaction.
 //	---------------------------------------------------------------------
 //   					This procedure will create a disposable batch for any #tciTransToCommit row that does no
-//   					have a DispoBatchKey.
+//   					have a DispoBatchKey.  SO shipments get SO batches (BatchType 801, 802).  IM
+//   					transactions get IM batches (BatchType 701, or 704 for kits).  PO receivers get PO
+//   					batches (BatchType 1101 for receipts, 1103 for returns).  The pending IM
+//   					transactions (timPendInvtTran) and PO receivers (tpoPendReceiver) are moved to their
+//   					disposable batch so that the module register posts them.  Rows with a negative
+//   					CommitStatus (deferred by LockCommitItemWhse or blocked by CheckNegativeInvt) are
+//   					left without a batch.  The batch totals (tciBatchLog) are computed from the
+//   					posting rows, so they are updated by CommitInvtTrans after the register.
 //
 //    Assumptions:     This SP assumes that the #tciTransToCommit has been populated appropriately and completely.
 //
 //                       CREATE TABLE #tciTransToCommit (
 //                        	CompanyID         VARCHAR(3) NOT NULL,
-//                        	TranType          INTEGER NOT NULL,  -- Shipment tran types including 810, 811, 812.
+//                        	TranType          INTEGER NOT NULL,  -- Shipment tran types including 810, 811, 812 or
+//                        	                                     -- IM and PO tran types in constants.IMCommitTranTypes
+//                        	                                     -- and constants.POCommitTranTypes.
 //                        	PostDate          DATETIME NOT NULL, -- Post date of the transaction.
 //                        	InvcDate          DATETIME NULL,     -- Date use to create the invoice or credit memo.
-//                        	TranKey           INTEGER NOT NULL,  -- Represents the ShipKey of the transactions to commit.
+//                        	TranKey           INTEGER NOT NULL,  -- Represents the ShipKey (SO), InvtTranKey (IM) or RcvrKey (PO) of the transactions to commit.
 //                        	PreCommitBatchKey INTEGER NOT NULL,  -- Represents the module's hidden system batch for uncommitted trans.
 //                        	DispoBatchKey     INTEGER NULL,      -- Temporary batch used to run through the posting routines.
 //                        	CommitStatus      INTEGER DEFAULT 0  -- Status use to determine progress of each transaction.
@@ -42,18 +52,49 @@ func CreateInvtCommitDisposableBatch(bq *du.BatchQuery, UserID string) constants
 					PostDate,
 					COALESCE(InvcDate, PostDate) As InvcDate
 				FROM #tcitranstocommit 
-				WHERE ISNULL(DispoBatchKey,0)=0 AND TranType IN (?,?,?)
+				WHERE ISNULL(DispoBatchKey,0)=0 AND ISNULL(CommitStatus,0)>=0 AND TranType IN (?,?,?)
 				UNION
 				SELECT CompanyID,
 					? AS BatchTranType,
 					PostDate,
 					COALESCE(InvcDate, PostDate) As InvcDate
 				FROM #tcitranstocommit 
-				WHERE ISNULL(DispoBatchKey,0)=0 AND TranType IN (?);`,
+				WHERE ISNULL(DispoBatchKey,0)=0 AND ISNULL(CommitStatus,0)>=0 AND TranType IN (?);`,
 		constants.BatchTranTypeSOProcShip, constants.SOTranTypeDropShip, constants.SOTranTypeCustShip, constants.SOTranTypeTransShip,
 		constants.BatchTranTypeSOProcCustRtrn, constants.SOTranTypeCustRtrn)
 
-	if !qr.HasData {
+	// IM transactions and PO receivers are committed through the same disposable batches
+	imtt := `?` + strings.Repeat(`,?`, len(constants.IMCommitTranTypes)-1)
+	qri := bq.Get(`SELECT t.CompanyID,
+					btt.BatchType AS BatchTranType,
+					t.PostDate,
+					MIN(p.TranID) AS FromTranID, MAX(p.TranID) AS ToTranID,
+					MIN(w.WhseID) AS MinWhseID, MAX(w.WhseID) AS MaxWhseID
+				FROM #tcitranstocommit t
+					JOIN tciBatchTranType btt WITH (NOLOCK) ON t.TranType = btt.TranType
+					LEFT JOIN timPendInvtTran p WITH (NOLOCK) ON t.TranKey = p.InvtTranKey
+					LEFT JOIN timWarehouse w WITH (NOLOCK) ON p.WhseKey = w.WhseKey
+				WHERE ISNULL(t.DispoBatchKey,0)=0 AND ISNULL(t.CommitStatus,0)>=0 AND btt.BatchType IN (?,?)
+					AND t.TranType IN (`+imtt+`)
+				GROUP BY t.CompanyID, btt.BatchType, t.PostDate;`,
+		append([]interface{}{constants.BatchTranTypeIMProcInvTran, constants.BatchTranTypeIMProcKitAss}, constants.IMCommitTranTypes...)...)
+
+	pott := `?` + strings.Repeat(`,?`, len(constants.POCommitTranTypes)-1)
+	qrp := bq.Get(`SELECT t.CompanyID,
+					btt.BatchType AS BatchTranType,
+					t.PostDate,
+					MIN(r.TranID) AS FromTranID, MAX(r.TranID) AS ToTranID,
+					MIN(w.WhseID) AS MinWhseID, MAX(w.WhseID) AS MaxWhseID
+				FROM #tcitranstocommit t
+					JOIN tciBatchTranType btt WITH (NOLOCK) ON t.TranType = btt.TranType
+					LEFT JOIN tpoPendReceiver r WITH (NOLOCK) ON t.TranKey = r.RcvrKey
+					LEFT JOIN timWarehouse w WITH (NOLOCK) ON r.WhseKey = w.WhseKey
+				WHERE ISNULL(t.DispoBatchKey,0)=0 AND ISNULL(t.CommitStatus,0)>=0 AND btt.BatchType IN (?,?)
+					AND t.TranType IN (`+pott+`)
+				GROUP BY t.CompanyID, btt.BatchType, t.PostDate;`,
+		append([]interface{}{constants.BatchTranTypePORcptGoods, constants.BatchTranTypePORtrnGoods}, constants.POCommitTranTypes...)...)
+
+	if !qr.HasData && !qri.HasData && !qrp.HasData {
 		return constants.BatchReturnError
 	}
 
@@ -66,13 +107,52 @@ func CreateInvtCommitDisposableBatch(bq *du.BatchQuery, UserID string) constants
 		pd := r.ValueTime("PostDate")
 		idt := r.ValueTime("InvcDate")
 
-		res, batchKey, _ := bat.GetNextBatch(bq, cid, 8, bt, UserID, `Disposable Batch for Inventory Commit`, pd, 1, &idt)
+		// Warehouse and transaction range for the batch comment template
+		tts := []interface{}{constants.SOTranTypeCustRtrn}
+		if constants.BatchTranTypeConstant(bt) == constants.BatchTranTypeSOProcShip {
+			tts = []interface{}{constants.SOTranTypeDropShip, constants.SOTranTypeCustShip, constants.SOTranTypeTransShip}
+		}
+
+		opt := bat.BatchOptions{
+			CompanyID: cid,
+			UserID:    UserID,
+			BatchCmnt: `Disposable Batch for Inventory Commit`,
+			PostDate:  pd,
+			BatchType: bt,
+			InvcDate:  &idt,
+		}
+
+		qr2 = bq.Get(`SELECT MIN(ps.TranID) AS FromTranID, MAX(ps.TranID) AS ToTranID,
+						MIN(w.WhseID) AS MinWhseID, MAX(w.WhseID) AS MaxWhseID
+					  FROM #tcitranstocommit t
+						JOIN tsoPendShipment ps WITH (NOLOCK) ON t.TranKey = ps.ShipKey
+						LEFT JOIN timWarehouse w WITH (NOLOCK) ON ps.WhseKey = w.WhseKey
+					  WHERE ISNULL(t.DispoBatchKey,0)=0 AND ISNULL(t.CommitStatus,0)>=0 AND t.CompanyID=? AND t.PostDate=?
+						AND COALESCE(t.InvcDate, t.PostDate)=? AND t.TranType IN (?`+strings.Repeat(`,?`, len(tts)-1)+`);`,
+			append([]interface{}{cid, pd, idt}, tts...)...)
+		if qr2.HasData {
+			r2 := qr2.First()
+			opt.FromTranID = r2.ValueString("FromTranID")
+			opt.ToTranID = r2.ValueString("ToTranID")
+			if r2.ValueString("MinWhseID") == r2.ValueString("MaxWhseID") {
+				opt.WhseID = r2.ValueString("MinWhseID")
+			}
+		}
+
+		res, batchKey, _ := bat.GetNextBatchOpt(bq, constants.ModuleSO, opt, 1)
 
 		if res != constants.BatchReturnValid {
 			return res
 		}
 
-		upd := `UPDATE #tcitranstocommit SET DispoBatchKey=? WHERE CompanyID=? AND PostDate=? AND COALESCE(InvcDate, PostDate)=? AND TranType IN `
+		// A disposable batch is posted right away. It must not be held, private or under transaction control.
+		if bat.SetBatchFlags(bq, batchKey, constants.ModuleSO, bat.BatchFlags{}) == constants.ResultError {
+			return constants.BatchReturnError
+		}
+
+		bq.ScopeName("CreateInvtCommitDisposableBatch")
+
+		upd := `UPDATE #tcitranstocommit SET DispoBatchKey=? WHERE ISNULL(CommitStatus,0)>=0 AND CompanyID=? AND PostDate=? AND COALESCE(InvcDate, PostDate)=? AND TranType IN `
 
 		switch constants.BatchTranTypeConstant(bt) {
 		case constants.BatchTranTypeSOProcShip:
@@ -90,6 +170,76 @@ func CreateInvtCommitDisposableBatch(bq *du.BatchQuery, UserID string) constants
 				return constants.BatchReturnError
 			}
 		}
+
+		bq.ScopeName("CreateInvtCommitDisposableBatch")
+	}
+
+	for _, m := range []struct {
+		Module    constants.ModuleConstant
+		Data      du.QueryResult
+		TranTypes []interface{}
+		In        string
+		Pending   string // Pending table of the transactions and its key
+		TranKey   string
+	}{
+		{constants.ModuleIM, qri, constants.IMCommitTranTypes, imtt, "timPendInvtTran", "InvtTranKey"},
+		{constants.ModulePO, qrp, constants.POCommitTranTypes, pott, "tpoPendReceiver", "RcvrKey"},
+	} {
+		for _, r := range m.Data.Data {
+
+			bt := int(r.ValueInt64("BatchTranType"))
+			cid := r.ValueString("CompanyID")
+			pd := r.ValueTime("PostDate")
+
+			opt := bat.BatchOptions{
+				CompanyID:  cid,
+				UserID:     UserID,
+				BatchCmnt:  `Disposable Batch for Inventory Commit`,
+				PostDate:   pd,
+				BatchType:  bt,
+				FromTranID: r.ValueString("FromTranID"),
+				ToTranID:   r.ValueString("ToTranID"),
+			}
+			if r.ValueString("MinWhseID") == r.ValueString("MaxWhseID") {
+				opt.WhseID = r.ValueString("MinWhseID")
+			}
+
+			res, batchKey, _ := bat.GetNextBatchOpt(bq, m.Module, opt, 1)
+
+			if res != constants.BatchReturnValid {
+				return res
+			}
+
+			if bat.SetBatchFlags(bq, batchKey, m.Module, bat.BatchFlags{}) == constants.ResultError {
+				return constants.BatchReturnError
+			}
+
+			bq.ScopeName("CreateInvtCommitDisposableBatch")
+
+			qr2 = bq.Set(`UPDATE #tcitranstocommit SET DispoBatchKey=?
+						  WHERE ISNULL(DispoBatchKey,0)=0 AND ISNULL(CommitStatus,0)>=0 AND CompanyID=? AND PostDate=?
+							AND TranType IN (SELECT TranType FROM tciBatchTranType WITH (NOLOCK) WHERE BatchType=?)
+							AND TranType IN (`+m.In+`);`,
+				append([]interface{}{batchKey, cid, pd, bt}, m.TranTypes...)...)
+
+			if qr2.HasData {
+				if qr2.Get(0).ValueInt64("Affected") == 0 {
+					return constants.BatchReturnError
+				}
+			}
+
+			// The register posts the pending transactions of its batch
+			bq.Set(`UPDATE p SET BatchKey=t.DispoBatchKey
+					FROM `+m.Pending+` p
+						JOIN #tcitranstocommit t ON p.`+m.TranKey+` = t.TranKey
+					WHERE t.DispoBatchKey=? AND t.TranType IN (`+m.In+`);`,
+				append([]interface{}{batchKey}, m.TranTypes...)...)
+			if !bq.OK() {
+				return constants.BatchReturnError
+			}
+
+			bq.ScopeName("CreateInvtCommitDisposableBatch")
+		}
 	}
 
 	return constants.BatchReturnValid
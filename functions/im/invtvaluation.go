@@ -0,0 +1,176 @@
+package im
+
+import (
+	"gosqljobs/invtcommit/functions/constants"
+	"gosqljobs/invtcommit/functions/dec"
+	"time"
+
+	du "github.com/eaglebush/datautils"
+)
+
+// InvtValuationLine - on hand inventory of an item in a warehouse valued from its cost tiers
+type InvtValuationLine struct {
+	WhseKey         int                             `json:"whsekey"`
+	WhseID          string                          `json:"whseid"`
+	ItemKey         int                             `json:"itemkey"`
+	ItemID          string                          `json:"itemid"`
+	ValuationMeth   constants.ValuationMethConstant `json:"valuationmeth"`
+	QtyOnHand       dec.Decimal                     `json:"qtyonhand"`
+	UnitCost        dec.Decimal                     `json:"unitcost"`
+	ExtCost         dec.Decimal                     `json:"extcost"`
+	PendQtyIncrease dec.Decimal                     `json:"pendqtyincrease"`
+	PendQtyDecrease dec.Decimal                     `json:"pendqtydecrease"`
+}
+
+// InvtValuationTotal - the inventory value compared with the balance of the GL inventory accounts
+type InvtValuationTotal struct {
+	AsOfDate    time.Time   `json:"asofdate"`
+	ExtCost     dec.Decimal `json:"extcost"`
+	GLBalance   dec.Decimal `json:"glbalance"`
+	VarianceAmt dec.Decimal `json:"varianceamt"`
+}
+
+// InvtValuation - Values the on hand inventory from the cost tiers as of a date
+// ---------------------------------------------------------------------
+// The quantity of a cost tier as of the date is its OrigQty less the quantity
+// distributed (timInvtTranCost) to the decreases posted (timInvtTran) up to the
+// date.  Only the cost tiers with a CostingDate up to the date are included.  The
+// extended cost is the quantity times the unit cost of each tier.  The unit cost
+// of a line is the extended cost divided by the quantity.
+//
+// The pending quantities are those of today.  They are the commits that are not
+// posted yet and are not part of the value.
+//
+// The total value is compared with the balance of the GL inventory accounts at the
+// end of the fiscal period of the date, from the account history (tglAcctHist): the
+// beginning balance of the fiscal year plus the debits and credits of its periods
+// up to that period.  The account masks are the same as in ReconcileInvtToGL.  The
+// GL balance is for all the warehouses so the variance is only meaningful without
+// iWhseKey.
+//
+// Input Parameters:
+//    @_iCompanyId        Company ID
+//    @_iAsOfDate         Date of the valuation
+//    @_iWhseKey          Optional. Key of the warehouse (0 for all)
+//    @_iInvtAcctMask     GL account number masks of the inventory accounts, separated by commas
+// Output Parameters:
+//    oRetVal      ReturnValue:
+// 	  0 - Did not make it through the procedure
+// 	  1 - Value ties to the GL
+// 	  2 - Value does not tie to the GL
+//    oLines       Value per warehouse and item in WhseID, ItemID order
+//    oTotal       Total value and GL balance
+// ---------------------------------------------------------------------
+func InvtValuation(
+	bq *du.BatchQuery,
+	iCompanyID string,
+	iAsOfDate time.Time,
+	iWhseKey int,
+	iInvtAcctMask string) (Result constants.ResultConstant, Lines []InvtValuationLine, Total InvtValuationTotal) {
+
+	bq.ScopeName("InvtValuation")
+
+	Total.AsOfDate = iAsOfDate
+
+	// The account list is dropped on every exit path, also when a query failed
+	defer func() {
+		bq.Waive()
+		bq.Set(`IF OBJECT_ID('tempdb..#timReconInvtAcct') IS NOT NULL DROP TABLE #timReconInvtAcct;`)
+	}()
+
+	if collectInvtAccts(bq, iCompanyID, iInvtAcctMask) != constants.ResultSuccess {
+		return constants.ResultError, nil, Total
+	}
+
+	bq.ScopeName("InvtValuation")
+
+	asOf := iAsOfDate.Format("2006-01-02")
+
+	qr := bq.Get(`SELECT t.WhseKey, w.WhseID, t.ItemKey, i.ItemID, i.ValuationMeth,
+					SUM(t.Qty) AS QtyOnHand,
+					SUM(t.Qty * t.UnitCost) AS ExtCost,
+					SUM(t.PendQtyIncrease) AS PendQtyIncrease,
+					SUM(t.PendQtyDecrease) AS PendQtyDecrease
+				  FROM (SELECT ct.WhseKey, ct.ItemKey, ct.UnitCost, ct.PendQtyIncrease, ct.PendQtyDecrease,
+							CASE WHEN ct.CostingDate <= ? THEN ct.OrigQty - COALESCE(d.DistQty, 0) ELSE 0 END AS Qty
+						FROM timCostTier ct WITH (NOLOCK)
+							LEFT JOIN (SELECT itc.CostTierKey, SUM(itc.DistQty) AS DistQty
+									   FROM timInvtTranCost itc WITH (NOLOCK)
+										JOIN timInvtTran it WITH (NOLOCK) ON itc.InvtTranKey = it.InvtTranKey
+										JOIN timTranType tt WITH (NOLOCK) ON it.TranType = tt.TranType
+									   WHERE it.CompanyID=? AND it.TranDate <= ? AND tt.QtyOnHandEffect=?
+									   GROUP BY itc.CostTierKey) d ON ct.CostTierKey = d.CostTierKey
+						WHERE ct.WhseKey=COALESCE(?, ct.WhseKey)) t
+					JOIN timWarehouse w WITH (NOLOCK) ON t.WhseKey = w.WhseKey
+					JOIN timItem i WITH (NOLOCK) ON t.ItemKey = i.ItemKey
+				  WHERE w.CompanyID=?
+				  GROUP BY t.WhseKey, w.WhseID, t.ItemKey, i.ItemID, i.ValuationMeth
+				  HAVING SUM(t.Qty) <> 0 OR SUM(t.PendQtyIncrease) <> 0 OR SUM(t.PendQtyDecrease) <> 0
+				  ORDER BY w.WhseID, i.ItemID;`,
+		asOf, iCompanyID, asOf, constants.InventoryDecrease, nullKey(iWhseKey), iCompanyID)
+	if !bq.OK() {
+		return constants.ResultError, nil, Total
+	}
+
+	Lines = make([]InvtValuationLine, 0, len(qr.Data))
+	for _, v := range qr.Data {
+		qty, err := dec.Cols(v, "QtyOnHand", "ExtCost", "PendQtyIncrease", "PendQtyDecrease")
+		if err != nil {
+			return constants.ResultError, nil, Total
+		}
+
+		l := InvtValuationLine{
+			WhseKey:         int(v.ValueInt64("WhseKey")),
+			WhseID:          v.ValueString("WhseID"),
+			ItemKey:         int(v.ValueInt64("ItemKey")),
+			ItemID:          v.ValueString("ItemID"),
+			ValuationMeth:   constants.ValuationMethConstant(v.ValueInt64("ValuationMeth")),
+			QtyOnHand:       qty[0],
+			ExtCost:         qty[1].RoundAmt(),
+			PendQtyIncrease: qty[2],
+			PendQtyDecrease: qty[3],
+		}
+		l.UnitCost = l.ExtCost.Div(l.QtyOnHand, dec.CostPlaces)
+
+		Total.ExtCost = Total.ExtCost.Add(l.ExtCost)
+		Lines = append(Lines, l)
+	}
+
+	qr = bq.Get(`SELECT FiscYear, FiscPer
+				 FROM tglFiscalPeriod WITH (NOLOCK)
+				 WHERE CompanyID=? AND ? BETWEEN StartDate AND EndDate;`, iCompanyID, asOf)
+	if !bq.OK() || !qr.HasData {
+		return constants.ResultError, nil, Total
+	}
+	fiscYear := qr.First().ValueString("FiscYear")
+	fiscPer := qr.First().ValueInt64("FiscPer")
+
+	qr = bq.Get(`SELECT COALESCE(SUM(CASE WHEN h.FiscPer = 1 THEN h.BegBal ELSE 0 END), 0)
+					+ COALESCE(SUM(h.DebitAmt), 0) - COALESCE(SUM(h.CreditAmt), 0)
+				 FROM tglAcctHist h WITH (NOLOCK)
+					JOIN #timReconInvtAcct a ON h.GLAcctKey = a.GLAcctKey
+				 WHERE h.FiscYear=? AND h.FiscPer <= ?;`, fiscYear, fiscPer)
+	if !bq.OK() {
+		return constants.ResultError, nil, Total
+	}
+	glBal, err := dec.Ord(qr.First(), 0)
+	if err != nil {
+		return constants.ResultError, nil, Total
+	}
+	Total.GLBalance = glBal.RoundAmt()
+	Total.VarianceAmt = Total.ExtCost.Sub(Total.GLBalance)
+
+	if !Total.VarianceAmt.IsZero() {
+		return constants.ResultFail, Lines, Total
+	}
+
+	return constants.ResultSuccess, Lines, Total
+}
+
+// nullKey - returns nil for a zero key so that COALESCE(?, column) matches all rows
+func nullKey(iKey int) interface{} {
+	if iKey == 0 {
+		return nil
+	}
+	return iKey
+}
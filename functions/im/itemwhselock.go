@@ -0,0 +1,231 @@
+package im
+
+import (
+	"gosqljobs/invtcommit/functions/constants"
+	"gosqljobs/invtcommit/functions/sm"
+	"time"
+
+	du "github.com/eaglebush/datautils"
+)
+
+// LockCommitItemWhse - Locks the warehouses and items of the transactions in #tciTransToCommit
+// ---------------------------------------------------------------------
+// Two commits of the same item in the same warehouse update the same cost tiers.
+// Before the cost tiers are touched, a logical lock (tsmLogicalLock) is taken for
+// each warehouse and item of the transactions that have not been processed yet.
+// The LogicalLockType is constants.LogicalLockTypeItemWhse, registered in
+// tsmLogicalLockType by sql/imItemWhseLockType.sql, and the LogicalLockID is
+// ItemWhse:<WhseKey>:<ItemKey>.
+//
+// A warehouse and item that is decreased by any of the transactions is locked
+// exclusively.  One that is only increased (receipts, returns) gets a shared lock,
+// since increases only add new cost tiers.  The locks are requested in WhseKey,
+// ItemKey order so that two commits never wait on each other.
+//
+// Drop shipments are not locked; they do not go through the warehouse.
+//
+// Locks held by another commit are requested again up to iRetries times, iWait
+// apart.  The transactions that still cannot be locked are deferred: their
+// CommitStatus is set to CommitStatusLockedByUser and they are left for the next
+// run.  The rest of the transactions are committed as usual.
+//
+// The locks are kept in #tciItemWhseLocks, which has the shape of #LogicalLocks,
+// and are added with LogicalLockAddMultipleTable.  They are not kept in
+// #LogicalLocks because the GL posting truncates it and removes all its locks.
+// The UserKey of the locks is NULL.  CommitInvtTrans takes them before
+// the disposable batches are created and releases them with UnlockCommitItemWhse
+// once the cost tiers are updated.  Other callers must do the same around their
+// cost tier updates.
+//
+// Input Parameters:
+//    @_iLoginID        Login ID of the lock owner
+//    @_iRetries        Number of times the locks held by others are requested again
+//    @_iWait           Time between the requests
+// Output Parameters:
+//    oRetVal      ReturnValue:
+// 	  0 - Did not make it through the procedure
+// 	  1 - All the transactions were locked
+// 	  2 - Some transactions were deferred
+//    oDeferred    Number of transactions deferred
+// ---------------------------------------------------------------------
+func LockCommitItemWhse(
+	bq *du.BatchQuery,
+	iLoginID string,
+	iRetries int,
+	iWait time.Duration) (Result constants.ResultConstant, Deferred int) {
+
+	bq.ScopeName("LockCommitItemWhse")
+
+	// Without the lock type every lock would be refused and every transaction deferred
+	qr := bq.Get(`SELECT 1 FROM tsmLogicalLockType WITH (NOLOCK) WHERE LogicalLockType=?;`, constants.LogicalLockTypeItemWhse)
+	if !bq.OK() || !qr.HasData {
+		return constants.ResultError, 0
+	}
+
+	// Same shape as #LogicalLocks
+	bq.Set(`IF OBJECT_ID('tempdb..#tciItemWhseLocks') IS NULL
+			BEGIN
+				CREATE TABLE #tciItemWhseLocks
+				(
+					id INT IDENTITY(1,1),
+					logicallocktype   SMALLINT,
+					logicallockid     VARCHAR(80),
+					userkey           INTEGER NULL,
+					locktype          SMALLINT,
+					logicallockkey    INT NULL,
+					status            INTEGER NULL,
+					lockcleanupparam1 INTEGER NULL,
+					lockcleanupparam2 INTEGER NULL,
+					lockcleanupparam3 VARCHAR(255) NULL,
+					lockcleanupparam4 VARCHAR(255) NULL,
+					lockcleanupparam5 VARCHAR(255) NULL
+				)
+
+				CREATE CLUSTERED INDEX cls_tciitemwhselocks_idx ON #tciItemWhseLocks (userkey, logicallockkey, logicallocktype)
+			END;`)
+
+	bq.Set(`IF OBJECT_ID('tempdb..#tciCommitItemWhse') IS NOT NULL
+				TRUNCATE TABLE #tciCommitItemWhse
+			ELSE
+				CREATE TABLE #tciCommitItemWhse
+				(
+					trantype INTEGER NOT NULL,
+					trankey  INTEGER NOT NULL,
+					whsekey  INTEGER NOT NULL,
+					itemkey  INTEGER NOT NULL,
+					eoi      SMALLINT NOT NULL
+				);`)
+	if !bq.OK() {
+		return constants.ResultError, 0
+	}
+
+	// Shipments
+	bq.Set(`INSERT INTO #tciCommitItemWhse (trantype, trankey, whsekey, itemkey, eoi)
+			SELECT DISTINCT t.TranType, t.TranKey, ps.WhseKey, sl.ItemKey,
+				CASE WHEN t.TranType=? THEN ? ELSE ? END
+			FROM #tciTransToCommit t
+				JOIN tsoPendShipment ps WITH (NOLOCK) ON t.TranKey = ps.ShipKey
+				JOIN tsoShipLine sl WITH (NOLOCK) ON ps.ShipKey = sl.ShipKey
+			WHERE COALESCE(t.CommitStatus, 0)=? AND COALESCE(t.DispoBatchKey, 0)=0
+				AND t.TranType IN (?,?,?);`,
+		constants.SOTranTypeCustRtrn, constants.InventoryIncrease, constants.InventoryDecrease,
+		constants.CommitStatusDefault,
+		constants.SOTranTypeCustShip, constants.SOTranTypeTransShip, constants.SOTranTypeCustRtrn)
+
+	// IM transactions
+	bq.Set(`INSERT INTO #tciCommitItemWhse (trantype, trankey, whsekey, itemkey, eoi)
+			SELECT DISTINCT t.TranType, t.TranKey, pit.WhseKey, pit.ItemKey, tt.QtyOnHandEffect
+			FROM #tciTransToCommit t
+				JOIN timPendInvtTran pit WITH (NOLOCK) ON t.TranKey = pit.InvtTranKey
+				JOIN timTranType tt WITH (NOLOCK) ON pit.TranType = tt.TranType
+			WHERE COALESCE(t.CommitStatus, 0)=? AND COALESCE(t.DispoBatchKey, 0)=0
+				AND tt.QtyOnHandEffect IN (?,?);`,
+		constants.CommitStatusDefault, constants.InventoryDecrease, constants.InventoryIncrease)
+
+	// PO receivers
+	bq.Set(`INSERT INTO #tciCommitItemWhse (trantype, trankey, whsekey, itemkey, eoi)
+			SELECT DISTINCT t.TranType, t.TranKey, r.WhseKey, pl.ItemKey,
+				CASE WHEN t.TranType=? THEN ? ELSE ? END
+			FROM #tciTransToCommit t
+				JOIN tpoPendReceiver r WITH (NOLOCK) ON t.TranKey = r.RcvrKey
+				JOIN tpoRcvrLine rl WITH (NOLOCK) ON r.RcvrKey = rl.RcvrKey
+				JOIN tpoPOLine pl WITH (NOLOCK) ON rl.POLineKey = pl.POLineKey
+			WHERE COALESCE(t.CommitStatus, 0)=? AND COALESCE(t.DispoBatchKey, 0)=0
+				AND t.TranType IN (?,?,?);`,
+		constants.POTranTypeReturn, constants.InventoryDecrease, constants.InventoryIncrease,
+		constants.CommitStatusDefault,
+		constants.POTranTypeRcptVendor, constants.POTranTypeReturn, constants.POTranTypeWarehouse)
+	if !bq.OK() {
+		return constants.ResultError, 0
+	}
+
+	// One lock per warehouse and item in WhseKey, ItemKey order.  The identity of
+	// #tciItemWhseLocks follows the ORDER BY.
+	bq.Set(`INSERT INTO #tciItemWhseLocks (LogicalLockType, LogicalLockID, UserKey, LockType)
+			SELECT ?, 'ItemWhse:' + CONVERT(VARCHAR(10), c.whsekey) + ':' + CONVERT(VARCHAR(10), c.itemkey),
+				NULL, MAX(CASE WHEN c.eoi=? THEN ? ELSE ? END)
+			FROM #tciCommitItemWhse c
+			WHERE NOT EXISTS (SELECT 1 FROM #tciItemWhseLocks ll
+							  WHERE ll.LogicalLockType=?
+								AND ll.LogicalLockID='ItemWhse:' + CONVERT(VARCHAR(10), c.whsekey) + ':' + CONVERT(VARCHAR(10), c.itemkey))
+			GROUP BY c.whsekey, c.itemkey
+			ORDER BY c.whsekey, c.itemkey;`,
+		constants.LogicalLockTypeItemWhse, constants.InventoryDecrease, constants.LockTypeExclusive, constants.LockTypeShared,
+		constants.LogicalLockTypeItemWhse)
+	if !bq.OK() {
+		return constants.ResultError, 0
+	}
+
+	for i := 0; i <= iRetries; i++ {
+		if i > 0 {
+			time.Sleep(iWait)
+		}
+
+		// The locks refused on the previous request have no LogicalLockKey and are requested again
+		res, _, rejected := sm.LogicalLockAddMultipleTable(bq, "#tciItemWhseLocks", false, iLoginID)
+
+		bq.ScopeName("LockCommitItemWhse")
+
+		if res != constants.ResultSuccess || !bq.OK() {
+			return constants.ResultError, 0
+		}
+		if rejected == 0 {
+			break
+		}
+	}
+
+	// Defer the transactions of the warehouses and items that are still locked
+	qr = bq.Set(`UPDATE t
+				  SET t.CommitStatus=?
+				  FROM #tciTransToCommit t
+				  WHERE EXISTS (SELECT 1
+								FROM #tciCommitItemWhse c
+									JOIN #tciItemWhseLocks ll ON ll.LogicalLockID='ItemWhse:' + CONVERT(VARCHAR(10), c.whsekey) + ':' + CONVERT(VARCHAR(10), c.itemkey)
+								WHERE c.trantype=t.TranType AND c.trankey=t.TranKey
+									AND COALESCE(ll.Status, 0)<>?);`,
+		constants.CommitStatusLockedByUser, constants.LogLockResultCreated)
+	if !bq.OK() {
+		return constants.ResultError, 0
+	}
+
+	if qr.HasData {
+		Deferred = int(qr.Get(0).ValueInt64("Affected"))
+	}
+
+	if Deferred > 0 {
+		return constants.ResultFail, Deferred
+	}
+
+	return constants.ResultSuccess, 0
+}
+
+// UnlockCommitItemWhse - Releases the warehouse and item locks taken by LockCommitItemWhse
+// ---------------------------------------------------------------------
+// The locks of #tciItemWhseLocks are released and the table is emptied.
+// #LogicalLocks and the locks of other owners are not touched.
+//
+// Output Parameters:
+//    oRetVal      ReturnValue:
+// 	  0 - Did not make it through the procedure
+// 	  1 - Successful
+// ---------------------------------------------------------------------
+func UnlockCommitItemWhse(bq *du.BatchQuery) constants.ResultConstant {
+	bq.ScopeName("UnlockCommitItemWhse")
+
+	qr := bq.Get(`SELECT ISNULL(OBJECT_ID('tempdb..#tciItemWhseLocks'),0);`)
+	if qr.HasData && qr.First().ValueInt64Ord(0) == 0 {
+		return constants.ResultSuccess
+	}
+
+	bq.Set(`DELETE LL
+			FROM tsmLogicalLock LL
+				INNER JOIN #tciItemWhseLocks T ON T.LogicalLockKey = LL.LogicalLockKey
+			WHERE T.LogicalLockKey > 0 AND T.Status=?;`, constants.LogLockResultCreated)
+
+	bq.Set(`TRUNCATE TABLE #tciItemWhseLocks;`)
+	if !bq.OK() {
+		return constants.ResultError
+	}
+
+	return constants.ResultSuccess
+}
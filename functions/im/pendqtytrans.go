@@ -0,0 +1,161 @@
+package im
+
+import (
+	"gosqljobs/invtcommit/functions/constants"
+
+	du "github.com/eaglebush/datautils"
+)
+
+// loadPendQtyTrans - Loads the transactions that hold pending cost tier quantities and their expected quantities by cost tier
+// ---------------------------------------------------------------------
+// The expected pending quantities of CheckCostTiers, and of ReconcilePendQty which
+// is built on it, come from here.  CheckCostTiers loads them once for the check and
+// repairCostTiers again once the cost tiers are locked.  A transaction holds
+// pending quantities until its module posting starts.  These are:
+//    - the IM transactions in timPendInvtTran
+//    - the shipment lines of tsoPendShipment and their transit warehouse transfers in
+//    - the IM posting rows (timPosting) of the batches that have not started the
+//      module posting, which are the committed transactions not yet posted
+// A transaction found by more than one rule is counted once.
+//
+// The transactions are loaded the same way PermanentHiddenBatchRecovery and
+// PostAPIUndoCostTiersUpdate load them, into work tables with the shapes of
+// #timposting (#timPendQtyPosting) and #timUndoCostTierTranWrk (#timPendQtyTranWrk).
+// The expected quantities are the sums of the distributed quantities
+// (timInvtTranCost) of the transactions by inventory effect, the same as
+// APIUndoCostTiersVector computes them.  They are written to #timPendQtyExp.
+//
+// Set iLocked when the cost tiers are locked by the caller's transaction.  The rows
+// are then read without NOLOCK so that only committed work is counted.
+//
+// Input Parameters:
+//    @_iCompanyId      Company ID
+//    @_iLocked         Read committed rows only
+// Output Parameters:
+//    oRetVal      ReturnValue:
+// 	  0 - Did not make it through the procedure
+// 	  1 - Successful
+// ---------------------------------------------------------------------
+func loadPendQtyTrans(bq *du.BatchQuery, iCompanyID string, iLocked bool) constants.ResultConstant {
+	nolock := `WITH (NOLOCK)`
+	if iLocked {
+		nolock = ``
+	}
+
+	// Same shape as #timposting
+	bq.Set(`IF OBJECT_ID('tempdb..#timPendQtyPosting') IS NOT NULL
+				TRUNCATE TABLE #timPendQtyPosting
+			ELSE
+				CREATE TABLE #timPendQtyPosting
+				(
+					batchkey          INT NOT NULL,
+					tranqty           DECIMAL(16, 8) NOT NULL,
+					invttrankey       INT NOT NULL,
+					sourceinvttrankey INT NULL,
+					addinvt           SMALLINT NOT NULL,
+					trantype          INT NOT NULL
+				);`)
+
+	// Same shape as #timUndoCostTierTranWrk
+	bq.Set(`IF OBJECT_ID('tempdb..#timPendQtyTranWrk') IS NOT NULL
+				TRUNCATE TABLE #timPendQtyTranWrk
+			ELSE
+				CREATE TABLE #timPendQtyTranWrk
+				(
+					invttrankey        INTEGER NOT NULL,
+					trnsfrorderlinekey INTEGER NULL,
+					eoi                SMALLINT NOT NULL
+				);`)
+
+	bq.Set(`IF OBJECT_ID('tempdb..#timPendQtyExp') IS NOT NULL
+				TRUNCATE TABLE #timPendQtyExp
+			ELSE
+				CREATE TABLE #timPendQtyExp
+				(
+					costtierkey     INTEGER NOT NULL PRIMARY KEY,
+					pendqtyincrease DECIMAL(16, 8) NOT NULL,
+					pendqtydecrease DECIMAL(16, 8) NOT NULL
+				);`)
+	if !bq.OK() {
+		return constants.ResultError
+	}
+
+	// Pending IM transactions
+	bq.Set(`INSERT INTO #timPendQtyPosting (batchkey, tranqty, invttrankey, sourceinvttrankey, addinvt, trantype)
+			SELECT COALESCE(pit.BatchKey, 0), 1, pit.InvtTranKey, NULL,
+				CASE WHEN tt.QtyOnHandEffect = ? THEN 1 ELSE 0 END,
+				pit.TranType
+			FROM timPendInvtTran pit `+nolock+`
+				JOIN timTranType tt WITH (NOLOCK) ON pit.TranType = tt.TranType
+				JOIN timWarehouse w WITH (NOLOCK) ON pit.WhseKey = w.WhseKey
+			WHERE w.CompanyID=?
+				AND tt.QtyOnHandEffect IN (?,?);`,
+		constants.InventoryIncrease, iCompanyID, constants.InventoryDecrease, constants.InventoryIncrease)
+
+	// Pending shipment lines
+	bq.Set(`INSERT INTO #timPendQtyPosting (batchkey, tranqty, invttrankey, sourceinvttrankey, addinvt, trantype)
+			SELECT COALESCE(ps.BatchKey, 0), 1, sl.InvtTranKey, NULL,
+				CASE WHEN ps.TranType IN (?) THEN 1 ELSE 0 END,
+				ps.TranType
+			FROM tsoShipLine sl `+nolock+`
+				JOIN tsoPendShipment ps `+nolock+` ON sl.ShipKey = ps.ShipKey
+			WHERE ps.CompanyID=?
+				AND ps.TranType IN (?,?,?)
+				AND sl.InvtTranKey IS NOT NULL;`,
+		constants.SOTranTypeCustRtrn, iCompanyID,
+		constants.SOTranTypeCustShip, constants.SOTranTypeCustRtrn, constants.SOTranTypeTransShip)
+
+	// Transit warehouse transfers in of the pending transfer shipments
+	bq.Set(`INSERT INTO #timPendQtyPosting (batchkey, tranqty, invttrankey, sourceinvttrankey, addinvt, trantype)
+			SELECT COALESCE(ps.BatchKey, 0), 1, sl.TransitInvtTranKey, sl.InvtTranKey, 1, ?
+			FROM tsoShipLine sl `+nolock+`
+				JOIN tsoPendShipment ps `+nolock+` ON sl.ShipKey = ps.ShipKey
+			WHERE ps.CompanyID=?
+				AND ps.TranType IN (?)
+				AND sl.TransitInvtTranKey IS NOT NULL;`,
+		constants.SOTranTypeTransIn, iCompanyID, constants.SOTranTypeTransShip)
+
+	// Committed transactions of the batches that have not started the module posting
+	bq.Set(`INSERT INTO #timPendQtyPosting (batchkey, tranqty, invttrankey, sourceinvttrankey, addinvt, trantype)
+			SELECT tp.BatchKey, tp.TranQty, tp.InvtTranKey, tp.SourceInvtTranKey, COALESCE(tp.AddInvt, -1), tp.TranType
+			FROM timPosting tp `+nolock+`
+				JOIN timTranType tt WITH (NOLOCK) ON tp.TranType = tt.TranType
+				JOIN tciBatchLog bl `+nolock+` ON tp.BatchKey = bl.BatchKey
+			WHERE bl.SourceCompanyID=?
+				AND tp.TranQty <> 0
+				AND tt.QtyOnHandEffect IN (?,?)
+				AND tp.InvtTranKey IS NOT NULL
+				AND bl.PostStatus <= ?;`,
+		iCompanyID, constants.InventoryDecrease, constants.InventoryIncrease, constants.BatchPostStatusModStarted)
+	if !bq.OK() {
+		return constants.ResultError
+	}
+
+	bq.Set(`INSERT #timPendQtyTranWrk (invttrankey, trnsfrorderlinekey, eoi)
+			SELECT p.invttrankey,
+				NULL,
+				MAX(CASE
+					WHEN p.addinvt = 1 THEN 1
+					WHEN p.addinvt = 0 THEN -1
+					ELSE 0
+				END)
+			FROM #timPendQtyPosting p
+			WHERE p.tranqty <> 0
+			GROUP BY p.invttrankey;`)
+
+	bq.Set(`INSERT INTO #timPendQtyExp (costtierkey, pendqtyincrease, pendqtydecrease)
+			SELECT itc.CostTierKey,
+				SUM(CASE WHEN tw.eoi=? THEN itc.DistQty ELSE 0 END),
+				SUM(CASE WHEN tw.eoi=? THEN itc.DistQty ELSE 0 END)
+			FROM #timPendQtyTranWrk tw
+				INNER JOIN timInvtTranCost itc `+nolock+` ON tw.invttrankey = itc.InvtTranKey
+			WHERE tw.eoi IN (?,?)
+			GROUP BY itc.CostTierKey;`,
+		constants.InventoryIncrease, constants.InventoryDecrease,
+		constants.InventoryDecrease, constants.InventoryIncrease)
+	if !bq.OK() {
+		return constants.ResultError
+	}
+
+	return constants.ResultSuccess
+}
@@ -0,0 +1,290 @@
+package im
+
+import (
+	"gosqljobs/invtcommit/functions/constants"
+	"gosqljobs/invtcommit/functions/dec"
+	"gosqljobs/invtcommit/functions/sm"
+	"strings"
+	"time"
+
+	du "github.com/eaglebush/datautils"
+)
+
+// InvtGLReconSummary - inventory and GL totals of a fiscal period and warehouse
+type InvtGLReconSummary struct {
+	FiscYear      string      `json:"fiscyear"`
+	FiscPer       int         `json:"fiscper"`
+	WhseKey       int         `json:"whsekey"`
+	WhseID        string      `json:"whseid"`
+	TranCount     int         `json:"trancount"`
+	SubLedgerAmt  dec.Decimal `json:"subledgeramt"`
+	GLAmt         dec.Decimal `json:"glamt"`
+	VarianceAmt   dec.Decimal `json:"varianceamt"`
+	VarianceCount int         `json:"variancecount"`
+}
+
+// InvtGLReconVariance - an inventory transaction that does not tie to the GL
+type InvtGLReconVariance struct {
+	InvtTranKey  int         `json:"invttrankey"`
+	TranType     int         `json:"trantype"`
+	TranDate     time.Time   `json:"trandate"`
+	FiscYear     string      `json:"fiscyear"`
+	FiscPer      int         `json:"fiscper"`
+	WhseID       string      `json:"whseid"`
+	ItemID       string      `json:"itemid"`
+	SubLedgerAmt dec.Decimal `json:"subledgeramt"`
+	GLAmt        dec.Decimal `json:"glamt"`
+	VarianceAmt  dec.Decimal `json:"varianceamt"`
+	NoGLTran     bool        `json:"nogltran"`
+}
+
+// ReconcileInvtToGL - Compares the inventory value of the transactions in timInvtTran and
+//                     timInvtTranCost with the amounts posted to the GL inventory accounts
+//                     in tglTransaction.  Batchless posting ties each GL record to its
+//                     source transaction through the InvtTranKey (TranKey), so each
+//                     inventory transaction can be matched with its GL entries.  The
+//                     batches not yet posted to GL are read from tglPosting instead.
+//
+//                     The inventory value of a transaction is the sum of its cost tier
+//                     distributions (timInvtTranCost.DistQty * timCostTier.UnitCost) signed
+//                     by the quantity on hand effect of the transaction type.  The GL amount is
+//                     the sum of the PostAmtHC of the GL rows of the same TranKey
+//                     and TranType posted to the inventory accounts.
+// ---------------------------------------------------------------------
+// Input Parameters:
+//    iCompanyID      Company ID
+//    iFiscYear       Fiscal year to reconcile
+//    iFromFiscPer    Starting fiscal period
+//    iToFiscPer      Ending fiscal period
+//    iWhseKey        Optional. Warehouse to reconcile.  0 means all warehouses.
+//    iInvtAcctMask   GL account number of the inventory accounts.  Asterisks (*) in the
+//                    mask match any character, the same way the Retained Earnings mask
+//                    does.  Segment separators are ignored.  Several masks may be
+//                    separated by commas.
+// Output Parameters:
+//    Result          ReturnValue:
+//                       0 - Unexpected error
+//                       1 - Inventory ties to the GL
+//                       2 - Variances found
+//    Summary         Totals per fiscal period and warehouse
+//    Variances       InvtTranKeys with no GL transaction or whose amounts differ
+// ---------------------------------------------------------------------
+func ReconcileInvtToGL(
+	bq *du.BatchQuery,
+	iCompanyID string,
+	iFiscYear string,
+	iFromFiscPer int,
+	iToFiscPer int,
+	iWhseKey int,
+	iInvtAcctMask string) (Result constants.ResultConstant, Summary []InvtGLReconSummary, Variances []InvtGLReconVariance) {
+
+	bq.ScopeName("ReconcileInvtToGL")
+
+	if len(invtAcctMasks(iInvtAcctMask)) == 0 {
+		return constants.ResultError, nil, nil
+	}
+
+	// The work tables are dropped on every exit path, also when a query failed
+	defer func() {
+		bq.Waive()
+		bq.Set(`IF OBJECT_ID('tempdb..#timReconInvtTran') IS NOT NULL DROP TABLE #timReconInvtTran;`)
+		bq.Set(`IF OBJECT_ID('tempdb..#timReconInvtAcct') IS NOT NULL DROP TABLE #timReconInvtAcct;`)
+	}()
+
+	bq.Set(`IF OBJECT_ID('tempdb..#timReconInvtTran') IS NOT NULL
+				TRUNCATE TABLE #timReconInvtTran
+			ELSE
+			BEGIN
+				CREATE TABLE #timReconInvtTran (
+					InvtTranKey  INTEGER NOT NULL,
+					TranType     INTEGER NOT NULL,
+					TranDate     DATETIME NOT NULL,
+					FiscYear     VARCHAR(5) NOT NULL,
+					FiscPer      SMALLINT NOT NULL,
+					WhseKey      INTEGER NOT NULL,
+					ItemKey      INTEGER NOT NULL,
+					SubLedgerAmt DECIMAL(15,3) NOT NULL DEFAULT 0,
+					GLAmt        DECIMAL(15,3) NOT NULL DEFAULT 0,
+					GLTranCount  INTEGER NOT NULL DEFAULT 0
+				)
+
+				CREATE CLUSTERED INDEX cls_timReconInvtTran_idx ON #timReconInvtTran (InvtTranKey, TranType)
+			END;`)
+	if !bq.OK() {
+		return constants.ResultError, nil, nil
+	}
+
+	if collectInvtAccts(bq, iCompanyID, iInvtAcctMask) != constants.ResultSuccess {
+		return constants.ResultError, nil, nil
+	}
+
+	bq.ScopeName("ReconcileInvtToGL")
+
+	// Inventory transactions of the periods
+	var whse interface{}
+	if iWhseKey != 0 {
+		whse = iWhseKey
+	}
+
+	bq.Set(`INSERT INTO #timReconInvtTran (InvtTranKey, TranType, TranDate, FiscYear, FiscPer, WhseKey, ItemKey)
+			SELECT it.InvtTranKey, it.TranType, it.TranDate, fp.FiscYear, fp.FiscPer, it.WhseKey, it.ItemKey
+			FROM timInvtTran it WITH (NOLOCK)
+				JOIN tglFiscalPeriod fp WITH (NOLOCK) ON fp.CompanyID=it.CompanyID
+					AND it.TranDate BETWEEN fp.StartDate AND fp.EndDate
+				JOIN timTranType tt WITH (NOLOCK) ON it.TranType=tt.TranType
+			WHERE it.CompanyID=?
+				AND fp.FiscYear=?
+				AND fp.FiscPer BETWEEN ? AND ?
+				AND tt.QtyOnHandEffect IN (?,?)
+				AND it.WhseKey=COALESCE(?, it.WhseKey);`,
+		iCompanyID, iFiscYear, iFromFiscPer, iToFiscPer,
+		constants.InventoryDecrease, constants.InventoryIncrease, whse)
+	if !bq.OK() {
+		return constants.ResultError, nil, nil
+	}
+
+	// Inventory value from the cost tier distributions
+	bq.Set(`UPDATE t
+			SET t.SubLedgerAmt = ROUND(cst.CostAmt * tt.QtyOnHandEffect, 3)
+			FROM #timReconInvtTran t
+				JOIN timTranType tt WITH (NOLOCK) ON t.TranType=tt.TranType
+				JOIN (SELECT itc.InvtTranKey, SUM(itc.DistQty * ct.UnitCost) CostAmt
+					  FROM timInvtTranCost itc WITH (NOLOCK)
+						JOIN timCostTier ct WITH (NOLOCK) ON itc.CostTierKey=ct.CostTierKey
+					  WHERE itc.InvtTranKey IN (SELECT InvtTranKey FROM #timReconInvtTran)
+					  GROUP BY itc.InvtTranKey) cst ON t.InvtTranKey=cst.InvtTranKey;`)
+	if !bq.OK() {
+		return constants.ResultError, nil, nil
+	}
+
+	// GL amounts posted to the inventory accounts.  The tglPosting rows of a batch are
+	// deleted once it is posted (PostAPIGLPosting), so the posted amounts come from
+	// tglTransaction and only the batches not yet posted to GL are read from tglPosting.
+	bq.Set(`UPDATE t
+			SET t.GLAmt = gl.PostAmtHC,
+				t.GLTranCount = gl.TranCount
+			FROM #timReconInvtTran t
+				JOIN (SELECT g.TranKey, g.TranType, SUM(g.PostAmtHC) PostAmtHC, COUNT(*) TranCount
+					  FROM (SELECT gt.TranKey, gt.TranType, gt.PostAmtHC
+							FROM tglTransaction gt WITH (NOLOCK)
+								JOIN #timReconInvtAcct a ON gt.GLAcctKey=a.GLAcctKey
+							WHERE gt.TranKey IN (SELECT InvtTranKey FROM #timReconInvtTran)
+							UNION ALL
+							SELECT gp.TranKey, gp.TranType, gp.PostAmtHC
+							FROM tglPosting gp WITH (NOLOCK)
+								JOIN #timReconInvtAcct a ON gp.GLAcctKey=a.GLAcctKey
+								JOIN tciBatchLog bl WITH (NOLOCK) ON gp.BatchKey=bl.BatchKey
+							WHERE gp.TranKey IN (SELECT InvtTranKey FROM #timReconInvtTran)
+								AND bl.PostStatus < ?) g
+					  GROUP BY g.TranKey, g.TranType) gl ON t.InvtTranKey=gl.TranKey AND t.TranType=gl.TranType;`,
+		constants.BatchPostStatusGLCompleted)
+	if !bq.OK() {
+		return constants.ResultError, nil, nil
+	}
+
+	qr := bq.Get(`SELECT t.FiscYear, t.FiscPer, t.WhseKey, w.WhseID,
+						COUNT(*) AS TranCount,
+						SUM(t.SubLedgerAmt) AS SubLedgerAmt,
+						SUM(t.GLAmt) AS GLAmt,
+						SUM(CASE WHEN t.GLTranCount = 0 OR t.SubLedgerAmt <> t.GLAmt THEN 1 ELSE 0 END) AS VarianceCount
+				FROM #timReconInvtTran t
+					JOIN timWarehouse w WITH (NOLOCK) ON t.WhseKey=w.WhseKey
+				GROUP BY t.FiscYear, t.FiscPer, t.WhseKey, w.WhseID
+				ORDER BY t.FiscYear, t.FiscPer, w.WhseID;`)
+	for _, v := range qr.Data {
+		amt, err := dec.Cols(v, "SubLedgerAmt", "GLAmt")
+		if err != nil {
+			return constants.ResultError, nil, nil
+		}
+
+		s := InvtGLReconSummary{
+			FiscYear:      v.ValueString("FiscYear"),
+			FiscPer:       int(v.ValueInt64("FiscPer")),
+			WhseKey:       int(v.ValueInt64("WhseKey")),
+			WhseID:        v.ValueString("WhseID"),
+			TranCount:     int(v.ValueInt64("TranCount")),
+			SubLedgerAmt:  amt[0],
+			GLAmt:         amt[1],
+			VarianceCount: int(v.ValueInt64("VarianceCount")),
+		}
+		s.VarianceAmt = s.SubLedgerAmt.Sub(s.GLAmt)
+		Summary = append(Summary, s)
+	}
+
+	qr = bq.Get(`SELECT t.InvtTranKey, t.TranType, t.TranDate, t.FiscYear, t.FiscPer, w.WhseID, i.ItemID,
+						t.SubLedgerAmt, t.GLAmt, t.GLTranCount
+				FROM #timReconInvtTran t
+					JOIN timWarehouse w WITH (NOLOCK) ON t.WhseKey=w.WhseKey
+					JOIN timItem i WITH (NOLOCK) ON t.ItemKey=i.ItemKey
+				WHERE t.GLTranCount = 0 OR t.SubLedgerAmt <> t.GLAmt
+				ORDER BY t.FiscYear, t.FiscPer, w.WhseID, t.InvtTranKey;`)
+	for _, v := range qr.Data {
+		amt, err := dec.Cols(v, "SubLedgerAmt", "GLAmt")
+		if err != nil {
+			return constants.ResultError, nil, nil
+		}
+
+		r := InvtGLReconVariance{
+			InvtTranKey:  int(v.ValueInt64("InvtTranKey")),
+			TranType:     int(v.ValueInt64("TranType")),
+			TranDate:     v.ValueTime("TranDate"),
+			FiscYear:     v.ValueString("FiscYear"),
+			FiscPer:      int(v.ValueInt64("FiscPer")),
+			WhseID:       v.ValueString("WhseID"),
+			ItemID:       v.ValueString("ItemID"),
+			SubLedgerAmt: amt[0],
+			GLAmt:        amt[1],
+			NoGLTran:     v.ValueInt64("GLTranCount") == 0,
+		}
+		r.VarianceAmt = r.SubLedgerAmt.Sub(r.GLAmt)
+		Variances = append(Variances, r)
+	}
+
+	if len(Variances) > 0 {
+		return constants.ResultFail, Summary, Variances
+	}
+
+	return constants.ResultSuccess, Summary, Variances
+}
+
+// collectInvtAccts - fills #timReconInvtAcct with the GL accounts matching the inventory account masks
+func collectInvtAccts(bq *du.BatchQuery, iCompanyID string, iInvtAcctMask string) constants.ResultConstant {
+	bq.ScopeName("collectInvtAccts")
+
+	bq.Set(`IF OBJECT_ID('tempdb..#timReconInvtAcct') IS NOT NULL
+				TRUNCATE TABLE #timReconInvtAcct
+			ELSE
+				CREATE TABLE #timReconInvtAcct (
+					GLAcctKey INTEGER NOT NULL PRIMARY KEY
+				);`)
+
+	for _, m := range invtAcctMasks(iInvtAcctMask) {
+		bq.Set(`INSERT INTO #timReconInvtAcct (GLAcctKey)
+				SELECT a.GLAcctKey
+				FROM tglAccount a WITH (NOLOCK)
+				WHERE a.CompanyID=?
+					AND a.GLAcctNo LIKE ?
+					AND a.GLAcctKey NOT IN (SELECT GLAcctKey FROM #timReconInvtAcct);`, iCompanyID, m)
+	}
+
+	qr := bq.Get(`SELECT COUNT(*) FROM #timReconInvtAcct;`)
+	if qr.First().ValueInt64Ord(0) == 0 {
+		return constants.ResultError
+	}
+
+	return constants.ResultSuccess
+}
+
+// invtAcctMasks - converts the inventory account masks, separated by commas, to LIKE patterns.
+// The account numbers are stored without the segment separators of the masks, so the
+// separators are removed and each asterisk matches one character.  Blank masks are skipped.
+func invtAcctMasks(iInvtAcctMask string) []string {
+	masks := make([]string, 0)
+	for _, m := range strings.Split(iInvtAcctMask, ",") {
+		if m = sm.UnformatAcct(m); m != "" {
+			masks = append(masks, strings.Replace(m, "*", "_", -1))
+		}
+	}
+
+	return masks
+}
@@ -0,0 +1,28 @@
+package im
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestInvtAcctMasks - segment separators are removed, asterisks match one character and
+// blank masks are skipped
+func TestInvtAcctMasks(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"1200", []string{"1200"}},
+		{"1200-***", []string{"1200___"}},
+		{"12-00-*1, 1300.**.*", []string{"1200_1", "1300___"}},
+		{"1200,,  ,1300", []string{"1200", "1300"}},
+		{" - ", []string{}},
+		{"", []string{}},
+	}
+
+	for _, tt := range tests {
+		if got := invtAcctMasks(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("invtAcctMasks(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package im
+
+import (
+	"gosqljobs/invtcommit/functions/constants"
+	"gosqljobs/invtcommit/functions/dec"
+
+	du "github.com/eaglebush/datautils"
+)
+
+// PendQtyDiff - a cost tier whose pending quantities do not agree with the pending transactions
+type PendQtyDiff struct {
+	CostTierKey     int         `json:"costtierkey"`
+	WhseID          string      `json:"whseid"`
+	ItemID          string      `json:"itemid"`
+	PendQtyIncrease dec.Decimal `json:"pendqtyincrease"`
+	PendQtyDecrease dec.Decimal `json:"pendqtydecrease"`
+	ExpQtyIncrease  dec.Decimal `json:"expqtyincrease"`
+	ExpQtyDecrease  dec.Decimal `json:"expqtydecrease"`
+}
+
+// ReconcilePendQty - Recomputes the pending quantities of the cost tiers from the pending transactions
+// ---------------------------------------------------------------------
+// It is built on CheckCostTiers, which loads the pending transactions and the
+// committed but unposted transactions and the expected pending quantities of
+// their cost tiers with loadPendQtyTrans.  Only the findings whose pending
+// quantities differ from the expected quantities are reported.
+//
+// Nothing is changed unless iApply is set.  The cost tiers that differ are then
+// repaired by repairCostTiers, the repair of CheckCostTiers: they are locked in
+// WhseKey, ItemKey, CostingDate, CostTierKey order, their expected quantities
+// are computed again inside the transaction and they are updated in the same
+// transaction.  Pending cost tiers left without quantity are removed.
+//
+// Input Parameters:
+//    @_iCompanyId      Company ID
+//    @_iWhseKey        Optional. Key of the warehouse (0 for all)
+//    @_iApply          Set the pending quantities to the expected quantities
+// Output Parameters:
+//    oRetVal      ReturnValue:
+// 	  0 - Did not make it through the procedure
+// 	  1 - Pending quantities agree (or were corrected)
+// 	  2 - Differences found and not applied
+//    oDiffs       Cost tiers whose pending quantities differ
+// ---------------------------------------------------------------------
+func ReconcilePendQty(
+	bq *du.BatchQuery,
+	iCompanyID string,
+	iWhseKey int,
+	iApply bool) (Result constants.ResultConstant, Diffs []PendQtyDiff) {
+
+	res, findings := CheckCostTiers(bq, iCompanyID, iWhseKey, 0, false)
+	if res == constants.ResultError {
+		return constants.ResultError, nil
+	}
+
+	bq.ScopeName("ReconcilePendQty")
+
+	Diffs = make([]PendQtyDiff, 0, len(findings))
+	diffs := make([]CostTierFinding, 0, len(findings))
+	for _, f := range findings {
+		if f.Kind == CostTierOrphanTrnsfrCost ||
+			(f.PendQtyIncrease.Equal(f.ExpQtyIncrease) && f.PendQtyDecrease.Equal(f.ExpQtyDecrease)) {
+			continue
+		}
+
+		diffs = append(diffs, f)
+		Diffs = append(Diffs, PendQtyDiff{
+			CostTierKey:     f.CostTierKey,
+			WhseID:          f.WhseID,
+			ItemID:          f.ItemID,
+			PendQtyIncrease: f.PendQtyIncrease,
+			PendQtyDecrease: f.PendQtyDecrease,
+			ExpQtyIncrease:  f.ExpQtyIncrease,
+			ExpQtyDecrease:  f.ExpQtyDecrease,
+		})
+	}
+
+	if len(Diffs) == 0 {
+		return constants.ResultSuccess, Diffs
+	}
+
+	if !iApply {
+		return constants.ResultFail, Diffs
+	}
+
+	// Only the cost tiers that differ are repaired
+	bq.Set(`UPDATE #timCostTierChk
+			SET finding=0
+			WHERE pendqtyincrease = expqtyincrease AND pendqtydecrease = expqtydecrease;`)
+	if !bq.OK() {
+		return constants.ResultError, Diffs
+	}
+
+	if repairCostTiers(bq, iCompanyID, diffs) != constants.ResultSuccess {
+		return constants.ResultError, Diffs
+	}
+
+	return constants.ResultSuccess, Diffs
+}
@@ -4,6 +4,7 @@ import (
 	"math"
 	"strings"
 	"time"
+	"unicode"
 )
 
 // Common functions
@@ -65,7 +66,7 @@ func SubstAcct(iGlAcctNo string, iRetAcctNo string) string {
 
 	for {
 		pos := strings.Index(iRetAcctNo, "*")
-		if pos == -1 || pos > iGLAcctLen {
+		if pos == -1 || pos >= iGLAcctLen {
 			break
 		}
 
@@ -76,6 +77,17 @@ func SubstAcct(iGlAcctNo string, iRetAcctNo string) string {
 	return iRetAcctNo
 }
 
+// UnformatAcct - removes the segment separators from a formatted GL account number or mask.
+// Account numbers are stored without separators in tglAccount.GLAcctNo.
+func UnformatAcct(iAcctNo string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '*' {
+			return r
+		}
+		return -1
+	}, iAcctNo)
+}
+
 // InStringArray - checks for the existence of value in a string array
 func InStringArray(stack *[]string, value string) bool {
 	value = strings.ToLower(value)
@@ -32,8 +32,8 @@ import (
 // 					LogicalLockType
 // 				1	Call spsmLogicalLockCleanup for each LogicalLockType.
 // 	#LogicalLocks
-// 				Temp table holding logical locks to be created.  This table
-// 				should have the following format:
+// 				Temp table holding logical locks to be created in ID order.
+// 				This table should have the following format:
 //  				CREATE TABLE #LogicalLocks
 //  					(
 //  					LogicalLockType			SMALLINT				-- value from tsmLogicalLockType (input)
@@ -61,9 +61,17 @@ import (
 //  					)
 // -----------------------------------------------------------------------------
 func LogicalLockAddMultiple(bq *du.BatchQuery, iCleanupLocksFirst bool, loginID string) (Result constants.ResultConstant, LocksCreated int, LocksRejected int) {
+	return LogicalLockAddMultipleTable(bq, "#LogicalLocks", iCleanupLocksFirst, loginID)
+}
+
+// LogicalLockAddMultipleTable - LogicalLockAddMultiple on a work table with the shape of
+// #LogicalLocks.  Callers that must keep their locks apart from #LogicalLocks, which the
+// GL posting truncates, use their own table.  iTable is a temp table name set by the
+// caller, never user input.
+func LogicalLockAddMultipleTable(bq *du.BatchQuery, iTable string, iCleanupLocksFirst bool, loginID string) (Result constants.ResultConstant, LocksCreated int, LocksRejected int) {
 	bq.ScopeName("LogicalLockAddMultiple")
 
-	qr := bq.Get(`SELECT CASE WHEN OBJECT_ID('tempdb..#LogicalLocks') IS NULL THEN 0 ELSE 1 END;`)
+	qr := bq.Get(`SELECT CASE WHEN OBJECT_ID('tempdb..` + iTable + `') IS NULL THEN 0 ELSE 1 END;`)
 	if qr.HasData {
 		if qr.First().ValueInt64Ord(0) == 0 {
 			return constants.ResultConstant(4), 0, 0
@@ -72,26 +80,26 @@ func LogicalLockAddMultiple(bq *du.BatchQuery, iCleanupLocksFirst bool, loginID
 
 	// Initialize temp table with starting values
 	// where the LogicalLockKey has not yet to be defined.
-	bq.Set(`UPDATE #LogicalLocks SET LogicalLockKey=NULL, Status=NULL WHERE (LogicalLockKey IS NULL OR LogicalLockKey=0);`)
+	bq.Set(`UPDATE ` + iTable + ` SET LogicalLockKey=NULL, Status=NULL WHERE (LogicalLockKey IS NULL OR LogicalLockKey=0);`)
 	if !bq.OK() {
 		return constants.ResultFail, 0, 0
 	}
 
-	qr = bq.Get(`SELECT COUNT(*) FROM #LogicalLocks WHERE Status IS NULL;`)
+	qr = bq.Get(`SELECT COUNT(*) FROM ` + iTable + ` WHERE Status IS NULL;`)
 	if qr.First().ValueInt64Ord(0) == 0 {
 		return constants.ResultSuccess, 0, 0
 	}
 
 	// Validate the LogicalLockType
 	bq.Set(`UPDATE LL SET Status= 2
-	     	 FROM #LogicalLocks LL 
+	     	 FROM ` + iTable + ` LL 
 			 WHERE Status IS NULL
 					AND NOT EXISTS (
 							SELECT LogicalLockType FROM tsmLogicalLockType LLT WITH (NOLOCK) WHERE LLT.LogicalLockType=LL.LogicalLockType
 						);`)
 
 	// Validate the LockType (shared/exclusive)
-	bq.Set(`UPDATE #LogicalLocks SET Status=3 WHERE	Status IS NULL AND LockType NOT IN (1,2);`)
+	bq.Set(`UPDATE ` + iTable + ` SET Status=3 WHERE	Status IS NULL AND LockType NOT IN (1,2);`)
 
 	// -----------------------------------------------------------------------
 	// -- Call lock cleanup procedure for each logical lock type if requested.
@@ -117,7 +125,7 @@ func LogicalLockAddMultiple(bq *du.BatchQuery, iCleanupLocksFirst bool, loginID
 	//=====================================================================================================================================================================================
 
 	// Set all lock keys to bad value
-	bq.Set(`UPDATE #LogicalLocks SET LogicalLockKey=-1 WHERE Status IS NULL;`)
+	bq.Set(`UPDATE ` + iTable + ` SET LogicalLockKey=-1 WHERE Status IS NULL;`)
 
 	// loginTime := time.Now()
 	// qr = bq.Get(`SELECT	LOGIN_TIME FROM master..sysprocesses WITH (NOLOCK) WHERE spid=@@SPID;`)
@@ -127,7 +135,7 @@ func LogicalLockAddMultiple(bq *du.BatchQuery, iCleanupLocksFirst bool, loginID
 
 	qr = bq.Get(`SELECT	ID, LogicalLockType, LogicalLockID, LockType,
 						LockCleanupParam1, LockCleanupParam2, LockCleanupParam3, LockCleanupParam4, LockCleanupParam5
-				FROM #LogicalLocks WHERE Status IS NULL;`)
+				FROM ` + iTable + ` WHERE Status IS NULL ORDER BY ID;`)
 	for _, r := range qr.Data {
 		llt := int(r.ValueInt64("LogicalLockType"))
 		llid := r.ValueString("LogicalLockID")
@@ -140,17 +148,17 @@ func LogicalLockAddMultiple(bq *du.BatchQuery, iCleanupLocksFirst bool, loginID
 
 		adres, lclogkey := LogicalLockAdd(bq, llt, llid, loginID, lt, false, lcp1, lcp2, lcp3, lcp4, lcp5)
 
-		bq.Set(`UPDATE #LogicalLocks SET LogicalLockKey=?, Status=? WHERE ID=?;`, lclogkey, adres, r.ValueInt64("ID"))
+		bq.Set(`UPDATE `+iTable+` SET LogicalLockKey=?, Status=? WHERE ID=?;`, lclogkey, adres, r.ValueInt64("ID"))
 	}
 
 	lockscreated := 0
 	locksrejected := 0
-	qr = bq.Get("SELECT COUNT(*) FROM #LogicalLocks WHERE LogicalLockKey > 0 AND Status=1;")
+	qr = bq.Get(`SELECT COUNT(*) FROM ` + iTable + ` WHERE LogicalLockKey > 0 AND Status=1;`)
 	if qr.HasData {
 		lockscreated = int(qr.First().ValueInt64Ord(0))
 	}
 
-	qr = bq.Get("SELECT COUNT(*) FROM #LogicalLocks")
+	qr = bq.Get(`SELECT COUNT(*) FROM ` + iTable + `;`)
 	if qr.HasData {
 		locksrejected = int(qr.First().ValueInt64Ord(0)) - lockscreated
 	}
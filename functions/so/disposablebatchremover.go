@@ -1,6 +1,7 @@
 package so
 
 import (
+	"gosqljobs/invtcommit/functions/bat"
 	"gosqljobs/invtcommit/functions/constants"
 
 	du "github.com/eaglebush/datautils"
@@ -57,10 +58,7 @@ func DisposableBatchRemover(
 
 	bq.ScopeName("DisposableBatchRemover")
 
-	ps := constants.BatchPostStatusUndefined
-	s := constants.BatchStatusUndefined
-
-	qr := bq.Get(`SELECT PostStatus, Status
+	qr := bq.Get(`SELECT 1
 				  FROM tciBatchLog WITH (NOLOCK)
 				  WHERE	BatchKey=? AND SourceCompanyID=?
 					AND BatchNo=0
@@ -77,10 +75,12 @@ func DisposableBatchRemover(
 		return constants.ResultFail
 	}
 
-	ps = constants.BatchPostStatusConstant(qr.First().ValueInt64Ord(0))
-	s = constants.BatchStatusConstant(qr.First().ValueInt64Ord(1))
+	res, b := bat.LoadBatch(bq, iDisposableBatchKey)
+	if res != constants.BatchReturnValid {
+		return constants.ResultFail
+	}
 
-	if !(ps == constants.BatchPostStatusOpen && s == constants.BatchStatusBalanced) {
+	if !(b.PostStatus == constants.BatchPostStatusOpen && b.Status == constants.BatchStatusBalanced) {
 		return constants.ResultFail
 	}
 
@@ -105,8 +105,7 @@ func DisposableBatchRemover(
 	// -- At this point, the batch appears to be a disposable batch
 	// -- that can safely be deleted.
 	// ------------------------------------------------------------
-	qr = bq.Set(`UPDATE tciBatchLog SET	PostStatus=? WHERE BatchKey=?;`, constants.BatchPostStatusDeleted, iDisposableBatchKey)
-	if !qr.HasAffectedRows {
+	if b.Delete(bq) != constants.BatchReturnValid {
 		return constants.ResultFail
 	}
 
@@ -1,6 +1,7 @@
 package so
 
 import (
+	"gosqljobs/invtcommit/functions/bat"
 	"gosqljobs/invtcommit/functions/constants"
 	"gosqljobs/invtcommit/functions/im"
 
@@ -168,6 +169,14 @@ func PermanentHiddenBatchRecovery(
 		return constants.ResultError
 	}
 
+	// The shipments moved between the batches
+	if bat.UpdateBatchTotals(bq, lPreCommitBatchKey) != constants.ResultSuccess ||
+		bat.UpdateBatchTotals(bq, iDisposableBatchKey) != constants.ResultSuccess {
+		return constants.ResultError
+	}
+
+	bq.ScopeName("PermanentHiddenBatchRecovery")
+
 	// Clean up the other posting related tables that were populated
 	// during pre-commit for this batch.
 	bq.Set(`DELETE timPostingAcct
@@ -188,8 +197,12 @@ func PermanentHiddenBatchRecovery(
 		return constants.ResultError
 	}
 
-	bq.Set(`UPDATE tciBatchLog SET PostStatus=?	WHERE BatchKey=?;`, constants.BatchPostStatusDeleted, iDisposableBatchKey)
-	if !bq.OK() {
+	res, b := bat.LoadBatch(bq, iDisposableBatchKey)
+	if res != constants.BatchReturnValid {
+		return constants.ResultError
+	}
+
+	if b.PostStatus != constants.BatchPostStatusDeleted && b.Delete(bq) != constants.BatchReturnValid {
 		return constants.ResultError
 	}
 
@@ -0,0 +1,247 @@
+package so
+
+import (
+	"gosqljobs/invtcommit/functions/bat"
+	"gosqljobs/invtcommit/functions/constants"
+	"gosqljobs/invtcommit/functions/dec"
+	"gosqljobs/invtcommit/functions/im"
+
+	du "github.com/eaglebush/datautils"
+)
+
+// GLPostFunc - posts the tglPosting rows of a batch to GL (gl.PostAPIGLPosting).
+// It is passed to ResumeBatchPosting since this package cannot depend on gl.
+type GLPostFunc func(bq *du.BatchQuery, iBatchKey int, iCompanyID string, iModuleNo int, iIntegrateWithGL bool, iUserID string) constants.ResultConstant
+
+// ResumeBatchPosting -
+// 					This will continue the posting of an interrupted shipment,
+// 					IM or MC batch from the last PostStatus checkpoint written
+// 					to tciBatchLog.  It supports the disposable batches of the
+// 					inventory commit (BatchNo = 0), the batchless GL posting
+// 					batches and the unfinished MC revaluation batches that
+// 					GetNextBatch returns (7).
+//
+// 					Each step checks what has already been written before it
+// 					does anything so that nothing is posted twice:
+// 					 100 - Validation.  The GL posting rows of the batch must
+// 						   balance and use active accounts.
+// 					 200 - Module posting.  The shipments (tsoPendShipment) or
+// 						   IM transactions (timPendInvtTran) of a disposable
+// 						   batch must no longer be pending.  The module posting
+// 						   itself is not repeated here; the commit must be run
+// 						   again.  The transactions not costed yet are costed
+// 						   with im.ApplyBatchCostTiers.  Batchless GL and MC
+// 						   batches have no module posting.
+// 					 300 - GL posting.  tglPosting is posted only when the
+// 						   batch has no tglTransaction rows yet.
+// 					 400 - Clean up.  The posting work rows of the batch are
+// 						   removed.  The tsoBatch or timBatch of a disposable
+// 						   batch is removed.
+//
+// 					When a step fails, the batch is marked as interrupted again
+// 					at the checkpoint of that step.
+// ------------------------------------------------------------------------------
+//  PARAMETERS
+// 	@iBatchKey:		Required.  The BatchKey of the batch to resume.
+//
+// 	@iCompanyID:	Required.  Used to validate that the batch belongs to the
+// 					company.
+//
+// 	@iUserID:		Required.  The user posting to GL.  The fiscal
+// 					period of the batch is created for this user when it
+// 					does not exist yet.
+//
+// 	@iPostGL:		Required.  Posts the batch to GL, normally
+// 					gl.PostAPIGLPosting.
+//
+// 	@iAllowNegative: Allow the cost tiers of a disposable batch to take the
+// 					quantity on hand below zero.
+//
+// 	@oRetVal:		0 = Unexpected error or the batch was changed by another
+// 						process.
+// 					1 = Successful.  The batch is posted.
+// 					2 = Nothing to resume.  The batch is not being posted or
+// 						was interrupted before the posting started.
+// 					3 = The batch does not exist or is not a shipment, IM or
+// 						MC batch of the company.
+// 					4 = The module posting did not complete.  The commit must
+// 						be run again for the pending transactions, or there
+// 						is not enough quantity in the cost tiers.
+// 					5 = The validation or the GL posting failed.
+//
+// 	@oPostStatus:	The PostStatus of the batch when the procedure ended.
+// ------------------------------------------------------------------------------
+func ResumeBatchPosting(
+	bq *du.BatchQuery,
+	iBatchKey int,
+	iCompanyID string,
+	iUserID string,
+	iPostGL GLPostFunc,
+	iAllowNegative bool) (Result constants.ResultConstant, PostStatus constants.BatchPostStatusConstant) {
+
+	bq.ScopeName("ResumeBatchPosting")
+
+	res, b := bat.LoadBatch(bq, iBatchKey)
+	if res == constants.BatchReturnError {
+		return constants.ResultError, constants.BatchPostStatusUndefined
+	}
+	if res != constants.BatchReturnValid || b.CompanyID != iCompanyID {
+		return constants.ResultConstant(3), constants.BatchPostStatusUndefined
+	}
+
+	var lModuleNo constants.ModuleConstant
+	switch constants.BatchTranTypeConstant(b.BatchType) {
+	case constants.BatchTranTypeSOProcShip, constants.BatchTranTypeSOProcCustRtrn:
+		lModuleNo = constants.ModuleSO
+	case constants.BatchTranTypeIMProcInvTran, constants.BatchTranTypeIMProcKitAss:
+		lModuleNo = constants.ModuleIM
+	case constants.BatchTranTypeMCGlReval, constants.BatchTranTypeMCAPReval,
+		constants.BatchTranTypeMCARReval, constants.BatchTranTypeMCRevReval:
+		lModuleNo = constants.ModuleMC
+	default:
+		return constants.ResultConstant(3), constants.BatchPostStatusUndefined
+	}
+
+	if b.Status == constants.BatchStatusInterrupted {
+		if b.PostStatus <= constants.BatchPostStatusOpen {
+			return constants.ResultFail, b.PostStatus
+		}
+
+		if b.Resume(bq) != constants.BatchReturnValid {
+			return constants.ResultError, b.PostStatus
+		}
+	}
+
+	if b.Status != constants.BatchStatusPosting {
+		return constants.ResultFail, b.PostStatus
+	}
+
+	lDisposable := (lModuleNo == constants.ModuleSO || lModuleNo == constants.ModuleIM) && b.BatchNo == 0
+
+	// The pending transactions and batch table of the module of a disposable batch
+	lPendTable, lBatchTable := "tsoPendShipment", "tsoBatch"
+	if lModuleNo == constants.ModuleIM {
+		lPendTable, lBatchTable = "timPendInvtTran", "timBatch"
+	}
+
+	// interrupt - stops at the current checkpoint
+	interrupt := func(rv constants.ResultConstant) (constants.ResultConstant, constants.BatchPostStatusConstant) {
+		bq.Waive()
+		b.Interrupt(bq)
+		return rv, b.PostStatus
+	}
+
+	for {
+		bres := constants.BatchReturnValid
+
+		switch b.PostStatus {
+		case constants.BatchPostStatusPrepStarted:
+			qr := bq.Get(`SELECT COALESCE(SUM(PostAmtHC), 0) FROM tglPosting WITH (NOLOCK) WHERE BatchKey=?;`, iBatchKey)
+			if !bq.OK() {
+				return interrupt(constants.ResultError)
+			}
+			lBalance, err := dec.Ord(qr.First(), 0)
+			if err != nil {
+				return interrupt(constants.ResultError)
+			}
+			if !lBalance.RoundAmt().IsZero() {
+				return interrupt(constants.ResultConstant(5))
+			}
+
+			qr = bq.Get(`SELECT 1
+						 FROM tglPosting p WITH (NOLOCK)
+							LEFT JOIN tglAccount a WITH (NOLOCK) ON p.GLAcctKey = a.GLAcctKey
+						 WHERE p.BatchKey=? AND COALESCE(a.Status, 0) <> 1;`, iBatchKey)
+			if !bq.OK() {
+				return interrupt(constants.ResultError)
+			}
+			if qr.HasData {
+				return interrupt(constants.ResultConstant(5))
+			}
+
+			bres = b.EndPrep(bq)
+
+		case constants.BatchPostStatusPrepCompleted:
+			bres = b.BeginModulePost(bq)
+
+		case constants.BatchPostStatusModStarted:
+			if lDisposable {
+				qr := bq.Get(`SELECT 1 FROM `+lPendTable+` WITH (NOLOCK) WHERE BatchKey=?;`, iBatchKey)
+				if !bq.OK() {
+					return interrupt(constants.ResultError)
+				}
+				if qr.HasData {
+					return interrupt(constants.ResultConstant(4))
+				}
+
+				// The costing skips the transactions costed before the interruption
+				cres, _ := im.ApplyBatchCostTiers(bq, iBatchKey, iAllowNegative)
+
+				bq.ScopeName("ResumeBatchPosting")
+
+				switch cres {
+				case constants.ResultSuccess:
+				case constants.ResultError:
+					return interrupt(constants.ResultError)
+				default:
+					return interrupt(constants.ResultConstant(4))
+				}
+			}
+
+			bres = b.MarkModulePosted(bq)
+
+		case constants.BatchPostStatusModCompleted:
+			bres = b.BeginGLPost(bq)
+
+		case constants.BatchPostStatusGLStarted:
+			// The GL posting was written. Only the posting rows are left.
+			qr := bq.Get(`SELECT 1 FROM tglTransaction WITH (NOLOCK) WHERE BatchKey=?;`, iBatchKey)
+			if !bq.OK() {
+				return interrupt(constants.ResultError)
+			}
+			if !qr.HasData {
+				pres := iPostGL(bq, iBatchKey, iCompanyID, int(lModuleNo), true, iUserID)
+
+				bq.ScopeName("ResumeBatchPosting")
+
+				if pres != constants.ResultSuccess {
+					return interrupt(constants.ResultConstant(5))
+				}
+			}
+
+			bres = b.MarkGLPosted(bq)
+
+		case constants.BatchPostStatusGLCompleted:
+			bres = b.BeginCleanup(bq)
+
+		case constants.BatchPostStatusModClnUpStarted:
+			bq.Set(`DELETE timPostingAcct
+					FROM timPostingAcct pa
+						JOIN timPosting p ON pa.impostingkey=p.impostingkey
+					WHERE p.BatchKey=?;`, iBatchKey)
+			bq.Set(`DELETE timPosting WHERE BatchKey=?;`, iBatchKey)
+			bq.Set(`DELETE tglPosting WHERE BatchKey=?;`, iBatchKey)
+			if lDisposable {
+				bq.Set(`DELETE `+lBatchTable+` WHERE BatchKey=?;`, iBatchKey)
+			}
+			if !bq.OK() {
+				return interrupt(constants.ResultError)
+			}
+
+			bres = b.EndCleanup(bq)
+
+		case constants.BatchPostStatusModClnUpCompleted:
+			bres = b.Complete(bq)
+
+		case constants.BatchPostStatusCompleted:
+			return constants.ResultSuccess, b.PostStatus
+
+		default:
+			return constants.ResultFail, b.PostStatus
+		}
+
+		if bres != constants.BatchReturnValid {
+			return constants.ResultError, b.PostStatus
+		}
+	}
+}
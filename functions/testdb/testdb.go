@@ -0,0 +1,86 @@
+// Package testdb connects the tests to a Sage 500 test database.
+//
+// The tests that need a database are skipped unless these are set:
+//    INVTCOMMIT_TEST_CONFIG    Configuration file with the databases (see config.json)
+//    INVTCOMMIT_TEST_DB        ID of the test database in the configuration file
+//    INVTCOMMIT_TEST_COMPANY   Company ID of the test company
+// Some tests need more fixture keys.  They are read with Int and skip the test
+// when they are not set.
+//
+// Never point these at a live company.  The tests create batches and write
+// fixture rows.  Fixture rows are written in a transaction that is rolled back.
+package testdb
+
+import (
+	"os"
+	"strconv"
+	"testing"
+
+	_ "github.com/denisenkom/go-mssqldb"
+	cfg "github.com/eaglebush/config"
+	du "github.com/eaglebush/datautils"
+)
+
+// Open - connects to the test database or skips the test.
+// The connection is closed when the test ends.
+func Open(t testing.TB) *du.BatchQuery {
+	t.Helper()
+
+	file := os.Getenv("INVTCOMMIT_TEST_CONFIG")
+	dbID := os.Getenv("INVTCOMMIT_TEST_DB")
+	if file == "" || dbID == "" {
+		t.Skip("INVTCOMMIT_TEST_CONFIG and INVTCOMMIT_TEST_DB are not set")
+	}
+
+	config, err := cfg.LoadConfig(file)
+	if err != nil {
+		t.Fatalf("Configuration file %s not loaded: %s", file, err)
+	}
+
+	bq := du.NewBatchQuery(config)
+	if !bq.Connect(dbID) {
+		t.Fatalf("Test database %s not connected: %s", dbID, bq.LastErrorText())
+	}
+	t.Cleanup(func() { bq.Disconnect() })
+
+	return bq
+}
+
+// CompanyID - returns the test company or skips the test
+func CompanyID(t testing.TB) string {
+	t.Helper()
+
+	cid := os.Getenv("INVTCOMMIT_TEST_COMPANY")
+	if cid == "" {
+		t.Skip("INVTCOMMIT_TEST_COMPANY is not set")
+	}
+
+	return cid
+}
+
+// Int - returns a fixture key from the environment or skips the test
+func Int(t testing.TB, iName string) int {
+	t.Helper()
+
+	v, err := strconv.Atoi(os.Getenv(iName))
+	if err != nil {
+		t.Skipf("%s is not set", iName)
+	}
+
+	return v
+}
+
+// Begin - starts the transaction of the fixture rows.  It is rolled back when the
+// test ends, together with everything the test wrote on the connection.
+func Begin(t testing.TB, bq *du.BatchQuery) {
+	t.Helper()
+
+	bq.Set(`BEGIN TRAN;`)
+	if !bq.OK() {
+		t.Fatalf("BEGIN TRAN: %s", bq.LastErrorText())
+	}
+	t.Cleanup(func() {
+		bq.Waive()
+		bq.Set(`IF @@TRANCOUNT > 0 ROLLBACK;`)
+	})
+}
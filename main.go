@@ -10,10 +10,12 @@ Release:
 package main
 
 import (
+	"gosqljobs/invtcommit/functions/bat"
+	"gosqljobs/invtcommit/functions/constants"
 	"gosqljobs/invtcommit/functions/sm"
 	"log"
 	"os"
-	"strings"
+	"time"
 
 	_ "github.com/denisenkom/go-mssqldb"
 	cfg "github.com/eaglebush/config"
@@ -21,27 +23,11 @@ import (
 )
 
 func main() {
-	argswp := os.Args[1:]
-	tranid := ""
-	whse := ""
+	cmd, args := parseArgs(os.Args[1:])
+	tranid := args.String("tranid", "")
+	whse := args.String("whse", "")
 	//whsekey := int64(0)
-	configfile := "config.json"
-
-	for _, kv := range argswp {
-		p := strings.Index(kv, "=")
-		k := kv[:p]
-		v := kv[p+1:]
-
-		if k == "/whse" {
-			whse = v
-		}
-		if k == "/tranid" {
-			tranid = v
-		}
-		if k == "/config" {
-			configfile = v
-		}
-	}
+	configfile := args.String("config", "config.json")
 
 	log.Println(tranid)
 	log.Println(whse)
@@ -51,21 +37,66 @@ func main() {
 		log.Fatal("Configuration file not found!")
 	}
 
+	if err = loadBatchCmntTemplates(configfile); err != nil {
+		log.Printf("Batch comment templates not loaded: %s", err)
+	}
+	if err = loadCommitRegisters(configfile); err != nil {
+		log.Printf("Commit registers not loaded: %s", err)
+	}
+	bat.SetBatchCmntRunID(args.String("runid", time.Now().Format("060102150405")))
+
 	bq := du.NewBatchQuery(config)
 	//og.Println(bq)
 
-	connected := bq.Connect("DEST_MDCI")
+	connected := bq.Connect(args.String("db", "DEST_MDCI"))
 	defer bq.Disconnect()
 
 	if !connected {
 		log.Println(bq.LastErrorText())
+		return
 	}
 
-	if connected {
+	res := constants.ResultSuccess
+
+	switch cmd {
+	case "commit":
+		res = runCommit(bq, args, os.Stdout)
+	case "reconcile-invt":
+		res = runReconcileInvt(bq, args, os.Stdout)
+	case "check-cost-tiers":
+		res = runCheckCostTiers(bq, args, os.Stdout)
+	case "invt-valuation":
+		res = runInvtValuation(bq, args, os.Stdout)
+	case "reconcile-pendqty":
+		res = runReconcilePendQty(bq, args, os.Stdout)
+	case "trial-balance":
+		res = runTrialBalance(bq, args, os.Stdout)
+	case "rebuild-accthist":
+		res = runRebuildAcctHist(bq, args, os.Stdout)
+	case "resume":
+		res = runResume(bq, args, os.Stdout)
+	case "batches list":
+		res = runBatchesList(bq, args, os.Stdout)
+	case "batches show":
+		res = runBatchesShow(bq, args, os.Stdout)
+	case "batches delete":
+		res = runBatchesDelete(bq, args, os.Stdout)
+	case "batches verify":
+		res = runBatchesVerify(bq, args, os.Stdout)
+	case "batches audit":
+		res = runBatchesAudit(bq, args, os.Stdout)
+	case "":
 		// test get next block surrogate key
 		stk, ek := sm.GetNextBlockSurrogateKey(bq, `TestTable`, 10)
 		log.Printf("Start Key: %d, End Key: %d\r\n", stk, ek)
 		log.Println(bq.LastErrorText())
+	default:
+		log.Fatalf("Unknown command: %s", cmd)
 	}
 
+	if res == constants.ResultError {
+		log.Println(bq.LastErrorText())
+		bq.Disconnect()
+		os.Exit(1)
+	}
 }
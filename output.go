@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"gosqljobs/invtcommit/functions/dec"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Output formats of the report commands
+const (
+	formatText = "text"
+	formatCSV  = "csv"
+	formatJSON = "json"
+)
+
+// writeTable - writes rows with their headers in text or CSV format
+func writeTable(w io.Writer, format string, title string, headers []string, rows [][]string) error {
+	switch format {
+	case formatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(headers); err != nil {
+			return err
+		}
+		if err := cw.WriteAll(rows); err != nil {
+			return err
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		if title != "" {
+			fmt.Fprintln(w, title)
+			fmt.Fprintln(w, strings.Repeat("=", len(title)))
+		}
+
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(tw, strings.Join(headers, "\t")+"\t")
+		for _, r := range rows {
+			fmt.Fprintln(tw, strings.Join(r, "\t")+"\t")
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+
+		fmt.Fprintln(w)
+		return nil
+	}
+}
+
+// writeJSON - writes any value in indented JSON format
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// fmtAmt - formats an amount for report output
+func fmtAmt(v dec.Decimal) string {
+	return v.StringFixed(2)
+}
+
+// fmtQty - formats a quantity for report output
+func fmtQty(v dec.Decimal) string {
+	return v.StringFixed(4)
+}
+
+// fmtDate - formats a date for report output
+func fmtDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
@@ -0,0 +1,21 @@
+USE [MDCI_MAS500_APP]
+
+GO
+
+/****** Logical lock type of the warehouse and item locks of the inventory commit (LockCommitItemWhse) ******/
+SET ANSI_NULLS ON
+
+GO
+
+SET QUOTED_IDENTIFIER ON
+
+GO
+
+-- constants.LogicalLockTypeItemWhse
+--      LogicalLockID is ItemWhse:<WhseKey>:<ItemKey>.  The locks are removed by
+--      UnlockCommitItemWhse, so the type has no cleanup procedure.
+IF NOT EXISTS (SELECT 1 FROM tsmLogicalLockType WHERE LogicalLockType = 9901)
+  INSERT INTO tsmLogicalLockType (LogicalLockType, Description, LockCleanupProcedure)
+  VALUES (9901, 'Inventory commit warehouse and item', NULL)
+
+GO
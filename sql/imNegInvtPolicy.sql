@@ -0,0 +1,28 @@
+USE [MDCI_MAS500_APP]
+
+GO
+
+/****** Messages of the negative inventory policy used by the inventory commit (CheckNegativeInvt) ******/
+SET ANSI_NULLS ON
+
+GO
+
+SET QUOTED_IDENTIFIER ON
+
+GO
+
+-- The policies are read from the optional table timNegInvtPolicy_HAI.  Its layout is
+-- in the doc comment of CheckNegativeInvt.  Without the table every transaction is
+-- committed.
+
+-- Messages logged by CheckNegativeInvt
+--      {0} TranID, {1} WhseID, {2} ItemID, {3} WhseBinID, {4} LotNo
+IF NOT EXISTS (SELECT 1 FROM tsmLocalString WHERE StringNo = 990100 AND LanguageID = 1033)
+  INSERT INTO tsmLocalString (StringNo, LanguageID, LocalText)
+  VALUES (990100, 1033, 'Transaction {0} takes item {2} below zero in warehouse {1}, bin {3} {4}. The transaction is committed.')
+
+IF NOT EXISTS (SELECT 1 FROM tsmLocalString WHERE StringNo = 990101 AND LanguageID = 1033)
+  INSERT INTO tsmLocalString (StringNo, LanguageID, LocalText)
+  VALUES (990101, 1033, 'Transaction {0} takes item {2} below zero in warehouse {1}, bin {3} {4}. Negative inventory is not allowed; the transaction is not committed.')
+
+GO